@@ -0,0 +1,233 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// AliasRule rewrites aliases of Phonemes, and affixes of prefix.map the same way.
+type AliasRule interface {
+	// Alias returns the alias of a Phoneme in the subfolder rewritten.
+	Alias(subfolder string, alias string) string
+	// Affix returns the affix of prefix.map rewritten, where subfolders are the ones
+	// the aliases with the affix are in.
+	Affix(a Affix, subfolders map[string]bool) Affix
+}
+
+type aliasRegexpRule struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// NewAliasRegexpRule creates an AliasRule replacing matches of the regular expression by the replacement,
+// in which `$1` and `${name}` refer to the capture groups as regexp.Regexp.ReplaceAllString does.
+// It rewrites prefixes and suffixes of prefix.map by itself as well.
+func NewAliasRegexpRule(pattern string, replacement string) (AliasRule, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.New("The given pattern is invalid; `" + pattern + "`; " + err.Error())
+	}
+	return aliasRegexpRule{re: re, replacement: replacement}, nil
+}
+
+func (r aliasRegexpRule) Alias(_ string, alias string) string {
+	return r.re.ReplaceAllString(alias, r.replacement)
+}
+
+func (r aliasRegexpRule) Affix(a Affix, _ map[string]bool) Affix {
+	return Affix{Prefix: r.re.ReplaceAllString(a.Prefix, r.replacement), Suffix: r.re.ReplaceAllString(a.Suffix, r.replacement)}
+}
+
+type aliasAffixRule struct {
+	subfolder string
+	prefix    string
+	suffix    string
+	remove    bool
+}
+
+// NewAliasAffixRule creates an AliasRule adding the prefix and the suffix to the aliases in the subfolder,
+// such as the suffix `_C4` to the subfolder `C4`. Aliases having them already are kept.
+// The affixes of prefix.map for the notes whose aliases are in the subfolder get them as well.
+func NewAliasAffixRule(subfolder string, prefix string, suffix string) AliasRule {
+	return aliasAffixRule{subfolder: subfolder, prefix: prefix, suffix: suffix}
+}
+
+// NewAliasAffixRemovalRule creates an AliasRule removing the prefix and the suffix from aliases
+// in all subfolders, such as the suffix `↑`. It removes them from the affixes of prefix.map as well.
+func NewAliasAffixRemovalRule(prefix string, suffix string) AliasRule {
+	return aliasAffixRule{prefix: prefix, suffix: suffix, remove: true}
+}
+
+func (r aliasAffixRule) Alias(subfolder string, alias string) string {
+	if r.remove {
+		return strings.TrimSuffix(strings.TrimPrefix(alias, r.prefix), r.suffix)
+	}
+	if subfolder != r.subfolder {
+		return alias
+	}
+	if !strings.HasPrefix(alias, r.prefix) {
+		alias = r.prefix + alias
+	}
+	if !strings.HasSuffix(alias, r.suffix) {
+		alias = alias + r.suffix
+	}
+	return alias
+}
+
+func (r aliasAffixRule) Affix(a Affix, subfolders map[string]bool) Affix {
+	if r.remove {
+		return Affix{Prefix: strings.TrimPrefix(a.Prefix, r.prefix), Suffix: strings.TrimSuffix(a.Suffix, r.suffix)}
+	}
+	if !subfolders[r.subfolder] {
+		return a
+	}
+	if !strings.HasPrefix(a.Prefix, r.prefix) {
+		a.Prefix = r.prefix + a.Prefix
+	}
+	if !strings.HasSuffix(a.Suffix, r.suffix) {
+		a.Suffix = a.Suffix + r.suffix
+	}
+	return a
+}
+
+// AliasCollision is an alias the rewrite gives to Phonemes which had different aliases.
+// Sources are the subfolders and the filenames of the Phonemes such as `C4/ka.wav`.
+type AliasCollision struct {
+	Alias   string   `json:"alias"`
+	Sources []string `json:"sources"`
+}
+
+// AliasRewriteResult is what rewriting aliases of a Voicebank changes.
+// Affixes are the entries of prefix.map changed, with the values after the rewrite.
+type AliasRewriteResult struct {
+	Changes    []*OtoChange      `json:"changes"`
+	Collisions []*AliasCollision `json:"collisions"`
+	Affixes    map[string]*Affix `json:"affixes"`
+}
+
+// AliasRewriter rewrites aliases of a Voicebank by AliasRules applied in order.
+type AliasRewriter struct {
+	rules []AliasRule
+}
+
+// NewAliasRewriter creates an AliasRewriter applying the rules in order.
+func NewAliasRewriter(rules ...AliasRule) *AliasRewriter {
+	return &AliasRewriter{rules: rules}
+}
+
+func (r *AliasRewriter) alias(subfolder string, alias string) string {
+	for _, rule := range r.rules {
+		alias = rule.Alias(subfolder, alias)
+	}
+	return alias
+}
+
+// effectiveAlias returns the alias the Phoneme is looked up by, which is the filename without the extension
+// for a Phoneme without alias.
+func effectiveAlias(p *Phoneme) string {
+	if p.Alias == "" {
+		return strings.TrimSuffix(p.Filename, ".wav")
+	}
+	return p.Alias
+}
+
+// affixSubfolders returns the subfolders having aliases with the affix.
+// An empty affix is for the subfolder named as the note if any, or the root.
+func affixSubfolders(vb *Voicebank, note string, a *Affix) map[string]bool {
+	res := map[string]bool{}
+	if a.Prefix == "" && a.Suffix == "" {
+		if _, ok := vb.PhonemesMap[note]; ok {
+			res[note] = true
+		} else {
+			res[""] = true
+		}
+		return res
+	}
+	for sub, ps := range vb.PhonemesMap {
+		for _, p := range *ps {
+			alias := effectiveAlias(p)
+			if len(alias) > len(a.Prefix)+len(a.Suffix) && strings.HasPrefix(alias, a.Prefix) && strings.HasSuffix(alias, a.Suffix) {
+				res[sub] = true
+				break
+			}
+		}
+	}
+	return res
+}
+
+// Rewrite rewrites the aliases of all Phonemes in the Voicebank and the affixes of its prefix.map.
+// A Phoneme without alias is rewritten from its filename without the extension.
+// It reports aliases the rewrite makes collide, and fails without changing anything if any unless dryRun is set.
+// The Voicebank is updated in place unless dryRun is set.
+func (r *AliasRewriter) Rewrite(vb *Voicebank, dryRun bool) (*AliasRewriteResult, error) {
+	res := &AliasRewriteResult{Changes: []*OtoChange{}, Collisions: []*AliasCollision{}, Affixes: map[string]*Affix{}}
+	type member struct {
+		before string
+		source string
+	}
+	members := map[string][]member{}
+	for _, sub := range sortedSubfolders(vb) {
+		for _, p := range *vb.PhonemesMap[sub] {
+			before := effectiveAlias(p)
+			after := r.alias(sub, before)
+			members[after] = append(members[after], member{before: before, source: strings.TrimPrefix(sub+"/"+p.Filename, "/")})
+			if after == before {
+				continue
+			}
+			c := &OtoChange{Subfolder: sub, Phoneme: p, Before: *p, After: *p}
+			c.After.Alias = after
+			res.Changes = append(res.Changes, c)
+		}
+	}
+	aliases := make([]string, 0, len(members))
+	for a := range members {
+		aliases = append(aliases, a)
+	}
+	sort.Strings(aliases)
+	for _, a := range aliases {
+		ms := members[a]
+		for _, m := range ms[1:] {
+			if m.before != ms[0].before {
+				c := &AliasCollision{Alias: a, Sources: []string{}}
+				for _, m := range ms {
+					c.Sources = append(c.Sources, m.source)
+				}
+				res.Collisions = append(res.Collisions, c)
+				break
+			}
+		}
+	}
+
+	if vb.Affixes != nil {
+		for note, a := range *vb.Affixes {
+			subs := affixSubfolders(vb, note, a)
+			after := *a
+			for _, rule := range r.rules {
+				after = rule.Affix(after, subs)
+			}
+			if after != *a {
+				res.Affixes[note] = &after
+			}
+		}
+	}
+
+	if dryRun {
+		return res, nil
+	}
+	if len(res.Collisions) > 0 {
+		return res, errors.New("The rewrite makes aliases collide; `" + res.Collisions[0].Alias + "`")
+	}
+	for _, c := range res.Changes {
+		c.Apply()
+	}
+	for note, a := range res.Affixes {
+		(*vb.Affixes)[note] = a
+	}
+	return res, nil
+}
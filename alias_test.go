@@ -0,0 +1,83 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAliasRewriteVoicebank() *Voicebank {
+	return newTestVoicebank(map[string]string{
+		"":   "ka.wav=a か,0,0,0,0,0\nさ.wav=,0,0,0,0,0",
+		"C5": "ka.wav=a か↑,0,0,0,0,0\nsa.wav=さ↑,0,0,0,0,0",
+	}, &Affixes{"C4": &Affix{}, "C5": &Affix{Suffix: "↑"}})
+}
+
+func TestAliasRules(t *testing.T) {
+	re, err := NewAliasRegexpRule(`^(\S+) (\S+)$`, "${1}_$2")
+	assert.Equal(t, nil, err)
+	testCases := []struct {
+		rule      AliasRule
+		subfolder string
+		alias     string
+		res       string
+		affix     Affix
+		affixRes  Affix
+	}{
+		{re, "", "a か", "a_か", Affix{Suffix: "↑"}, Affix{Suffix: "↑"}},
+		{re, "", "か", "か", Affix{}, Affix{}},
+		{NewAliasAffixRule("C4", "", "_C4"), "C4", "a か", "a か_C4", Affix{}, Affix{Suffix: "_C4"}},
+		{NewAliasAffixRule("C4", "", "_C4"), "C4", "a か_C4", "a か_C4", Affix{Suffix: "_C4"}, Affix{Suffix: "_C4"}},
+		{NewAliasAffixRule("C4", "*", ""), "", "a か", "a か", Affix{}, Affix{}},
+		{NewAliasAffixRemovalRule("", "↑"), "C5", "a か↑", "a か", Affix{Suffix: "↑"}, Affix{}},
+	}
+	for i, tc := range testCases {
+		t.Logf("Test case %v.; %v", i+1, tc.alias)
+		assert.Equal(t, tc.res, tc.rule.Alias(tc.subfolder, tc.alias))
+		assert.Equal(t, tc.affixRes, tc.rule.Affix(tc.affix, map[string]bool{tc.subfolder: true}))
+	}
+
+	_, err = NewAliasRegexpRule("(", "")
+	assert.NotEqual(t, nil, err)
+}
+
+func TestAliasRewriterRewrites(t *testing.T) {
+	re, _ := NewAliasRegexpRule(`^(\S+) (\S+)$`, "${1}_$2")
+	vb := newTestAliasRewriteVoicebank()
+	sut := NewAliasRewriter(NewAliasAffixRemovalRule("", "↑"), re, NewAliasAffixRule("C5", "", "_C5"))
+
+	res, err := sut.Rewrite(vb, true)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(res.Collisions))
+	assert.Equal(t, map[string]*Affix{"C5": {Suffix: "_C5"}}, res.Affixes)
+	assert.Equal(t, "--- a/oto.ini\n+++ b/oto.ini\n"+
+		"-ka.wav=a か,0,0,0,0,0\n+ka.wav=a_か,0,0,0,0,0\n"+
+		"--- a/C5/oto.ini\n+++ b/C5/oto.ini\n"+
+		"-ka.wav=a か↑,0,0,0,0,0\n+ka.wav=a_か_C5,0,0,0,0,0\n"+
+		"-sa.wav=さ↑,0,0,0,0,0\n+sa.wav=さ_C5,0,0,0,0,0\n", FormatOtoChanges(res.Changes))
+	assert.Equal(t, "a か", (*vb.PhonemesMap[""])[0].Alias, "Dry run should not modify Phonemes.")
+
+	_, err = sut.Rewrite(vb, false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "a_か_C5", (*vb.PhonemesMap["C5"])[0].Alias)
+	assert.Equal(t, "", (*vb.PhonemesMap[""])[1].Alias, "Aliases from filenames should be kept when unchanged.")
+	_, p, ok := vb.Lookup("さ", 72)
+	assert.Equal(t, true, ok, "prefix.map should still resolve.")
+	assert.Equal(t, "sa.wav", p.Filename)
+}
+
+func TestAliasRewriterReportsCollisions(t *testing.T) {
+	vb := newTestAliasRewriteVoicebank()
+	res, err := NewAliasRewriter(NewAliasAffixRemovalRule("", "↑")).Rewrite(vb, false)
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, []*AliasCollision{
+		{Alias: "a か", Sources: []string{"ka.wav", "C5/ka.wav"}},
+		{Alias: "さ", Sources: []string{"さ.wav", "C5/sa.wav"}},
+	}, res.Collisions)
+	assert.Equal(t, "a か↑", (*vb.PhonemesMap["C5"])[0].Alias, "Nothing should change when aliases collide.")
+	assert.Equal(t, "↑", (*vb.Affixes)["C5"].Suffix)
+}
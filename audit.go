@@ -0,0 +1,246 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Kinds of AuditIssue.
+const (
+	AuditClipping      = "clipping"
+	AuditDCOffset      = "dc_offset"
+	AuditNoiseFloor    = "noise_floor"
+	AuditSampleRate    = "sample_rate"
+	AuditBitsPerSample = "bits_per_sample"
+	AuditStereo        = "stereo"
+	AuditUnreadable    = "unreadable"
+)
+
+// silenceDB is the level reported instead of negative infinity.
+const silenceDB = -144.0
+
+// AuditOptions configures thresholds of the audit.
+type AuditOptions struct {
+	// ClipLevel is the absolute sample value regarded as clipped.
+	ClipLevel float64 `json:"clip_level"`
+	// MaxDCOffset is the largest acceptable mean of samples.
+	MaxDCOffset float64 `json:"max_dc_offset"`
+	// MaxNoiseFloor is the largest acceptable RMS in dBFS of the LeftBlank region.
+	MaxNoiseFloor float64 `json:"max_noise_floor"`
+	// MinNoiseRegion is the shortest LeftBlank region in milliseconds that is measured.
+	MinNoiseRegion float64 `json:"min_noise_region"`
+}
+
+// DefaultAuditOptions returns AuditOptions that fit most of voicebanks.
+func DefaultAuditOptions() AuditOptions {
+	return AuditOptions{
+		ClipLevel:      0.999,
+		MaxDCOffset:    0.01,
+		MaxNoiseFloor:  -50,
+		MinNoiseRegion: 20,
+	}
+}
+
+// AuditIssue is a single problem found by the audit.
+type AuditIssue struct {
+	Subfolder string `json:"subfolder"`
+	Filename  string `json:"filename"`
+	Kind      string `json:"kind"`
+	Message   string `json:"message"`
+}
+
+// SampleAudit is the result of the audit of a single wave file.
+type SampleAudit struct {
+	Subfolder      string   `json:"subfolder"`
+	Filename       string   `json:"filename"`
+	Path           string   `json:"path"`
+	Aliases        []string `json:"aliases"`
+	SampleRate     int      `json:"sample_rate"`
+	BitsPerSample  int      `json:"bits_per_sample"`
+	Channels       int      `json:"channels"`
+	ClippedSamples int      `json:"clipped_samples"`
+	DCOffset       float64  `json:"dc_offset"`
+	// NoiseFloor is the RMS in dBFS of the region before the shortest LeftBlank.
+	// It is nil when the region is too short to be measured.
+	NoiseFloor *float64 `json:"noise_floor,omitempty"`
+	// Loudness is the integrated loudness in LUFS.
+	Loudness float64 `json:"loudness"`
+
+	blocks []float64
+}
+
+// FolderAudit summarizes the audit of a single subfolder.
+type FolderAudit struct {
+	Subfolder string `json:"subfolder"`
+	Samples   int    `json:"samples"`
+	// Loudness is the integrated loudness in LUFS over all samples of the subfolder.
+	Loudness float64 `json:"loudness"`
+}
+
+// AuditReport is the result of the audit of a Voicebank.
+type AuditReport struct {
+	// SampleRate and BitsPerSample are the most common format in the Voicebank.
+	SampleRate    int            `json:"sample_rate"`
+	BitsPerSample int            `json:"bits_per_sample"`
+	Samples       []*SampleAudit `json:"samples"`
+	Folders       []*FolderAudit `json:"folders"`
+	Issues        []*AuditIssue  `json:"issues"`
+}
+
+// AuditWave measures a single Wave.
+// noiseRegion is the length in milliseconds from the beginning that should contain no voice.
+func AuditWave(w *Wave, noiseRegion float64, o AuditOptions) *SampleAudit {
+	a := &SampleAudit{
+		SampleRate:    w.SampleRate,
+		BitsPerSample: w.BitsPerSample,
+		Channels:      w.NumChannels(),
+	}
+	sum := 0.0
+	for _, c := range w.Channels {
+		for _, v := range c {
+			if math.Abs(v) >= o.ClipLevel {
+				a.ClippedSamples++
+			}
+			sum += v
+		}
+	}
+	if n := w.Len() * w.NumChannels(); n > 0 {
+		a.DCOffset = sum / float64(n)
+	}
+	if noiseRegion >= o.MinNoiseRegion {
+		s := w.Mono()
+		end := clampInt(w.MsToFrame(noiseRegion), 0, len(s))
+		if end > 0 {
+			sq := 0.0
+			for _, v := range s[:end] {
+				sq += v * v
+			}
+			nf := finiteDB(10 * math.Log10(sq/float64(end)))
+			a.NoiseFloor = &nf
+		}
+	}
+	a.blocks = loudnessBlocks(w)
+	a.Loudness = finiteDB(gatedLoudness(a.blocks))
+	return a
+}
+
+func finiteDB(v float64) float64 {
+	if math.IsInf(v, -1) || math.IsNaN(v) || v < silenceDB {
+		return silenceDB
+	}
+	return v
+}
+
+// VoicebankAuditor audits the wave files referenced by a Voicebank.
+type VoicebankAuditor interface {
+	Audit(*Voicebank) (*AuditReport, error)
+}
+
+type voicebankAuditorDefault struct {
+	wr WaveReader
+	o  AuditOptions
+}
+
+// NewVoicebankAuditor creates a VoicebankAuditor that reads samples from filesystem.
+func NewVoicebankAuditor(o AuditOptions) VoicebankAuditor {
+	return voicebankAuditorDefault{wr: NewWaveReader(), o: o}
+}
+
+// Audit all wave files referenced by Voicebank.PhonemesMap.
+// Each file is measured once even if several aliases refer to it.
+func (va voicebankAuditorDefault) Audit(vb *Voicebank) (*AuditReport, error) {
+	r := &AuditReport{Samples: []*SampleAudit{}, Folders: []*FolderAudit{}, Issues: []*AuditIssue{}}
+	for _, sub := range sortedSubfolders(vb) {
+		type sample struct {
+			filename string
+			path     string
+			aliases  []string
+			left     float64
+		}
+		samples := []*sample{}
+		byPath := map[string]*sample{}
+		for _, p := range *vb.PhonemesMap[sub] {
+			path := vb.SamplePath(sub, p)
+			s, ok := byPath[path]
+			if !ok {
+				s = &sample{filename: p.Filename, path: path, left: p.LeftBlank}
+				byPath[path] = s
+				samples = append(samples, s)
+			}
+			s.aliases = append(s.aliases, p.Alias)
+			s.left = math.Min(s.left, p.LeftBlank)
+		}
+		f := &FolderAudit{Subfolder: sub}
+		blocks := []float64{}
+		for _, s := range samples {
+			w, err := va.wr.Read(s.path)
+			if err != nil {
+				r.Issues = append(r.Issues, &AuditIssue{sub, s.filename, AuditUnreadable, err.Error()})
+				continue
+			}
+			a := AuditWave(w, s.left, va.o)
+			a.Subfolder, a.Filename, a.Path, a.Aliases = sub, s.filename, s.path, s.aliases
+			r.Samples = append(r.Samples, a)
+			blocks = append(blocks, a.blocks...)
+			f.Samples++
+		}
+		f.Loudness = finiteDB(gatedLoudness(blocks))
+		r.Folders = append(r.Folders, f)
+	}
+	r.SampleRate = mostCommon(r.Samples, func(a *SampleAudit) int { return a.SampleRate })
+	r.BitsPerSample = mostCommon(r.Samples, func(a *SampleAudit) int { return a.BitsPerSample })
+	for _, a := range r.Samples {
+		r.Issues = append(r.Issues, va.issues(r, a)...)
+	}
+	return r, nil
+}
+
+func (va voicebankAuditorDefault) issues(r *AuditReport, a *SampleAudit) []*AuditIssue {
+	res := []*AuditIssue{}
+	add := func(kind string, format string, args ...interface{}) {
+		res = append(res, &AuditIssue{a.Subfolder, a.Filename, kind, fmt.Sprintf(format, args...)})
+	}
+	if a.ClippedSamples > 0 {
+		add(AuditClipping, "%d samples are clipped", a.ClippedSamples)
+	}
+	if math.Abs(a.DCOffset) > va.o.MaxDCOffset {
+		add(AuditDCOffset, "DC offset is %.4f", a.DCOffset)
+	}
+	if a.NoiseFloor != nil && *a.NoiseFloor > va.o.MaxNoiseFloor {
+		add(AuditNoiseFloor, "Noise floor is %.1f dBFS", *a.NoiseFloor)
+	}
+	if a.SampleRate != r.SampleRate {
+		add(AuditSampleRate, "Sample rate is %d Hz while most samples are %d Hz", a.SampleRate, r.SampleRate)
+	}
+	if a.BitsPerSample != r.BitsPerSample {
+		add(AuditBitsPerSample, "Bits per sample is %d while most samples are %d", a.BitsPerSample, r.BitsPerSample)
+	}
+	if a.Channels > 1 {
+		add(AuditStereo, "The sample has %d channels", a.Channels)
+	}
+	return res
+}
+
+func mostCommon(as []*SampleAudit, f func(*SampleAudit) int) int {
+	counts := map[int]int{}
+	for _, a := range as {
+		counts[f(a)]++
+	}
+	keys := []int{}
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	res, max := 0, 0
+	for _, k := range keys {
+		if counts[k] > max {
+			res, max = k, counts[k]
+		}
+	}
+	return res
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditWaveMeasuresProblems(t *testing.T) {
+	w := newTestWave()
+	w.Channels[0][10000] = 1
+	for i := range w.Channels[0][:4410] {
+		w.Channels[0][i] += 0.02
+	}
+	a := AuditWave(w, 100, DefaultAuditOptions())
+	assert.Equal(t, 1, a.ClippedSamples)
+	assert.InDelta(t, 0.02*100/550, a.DCOffset, 0.001)
+	assert.InDelta(t, -34, *a.NoiseFloor, 0.1)
+	assert.True(t, a.Loudness > -30 && a.Loudness < -5)
+}
+
+func TestAuditWaveSkipsShortNoiseRegion(t *testing.T) {
+	a := AuditWave(NewWave(44100, 16, 1, 44100), 5, DefaultAuditOptions())
+	assert.Nil(t, a.NoiseFloor)
+	assert.Equal(t, silenceDB, a.Loudness)
+}
+
+func TestVoicebankAuditorReportsIssues(t *testing.T) {
+	mockedWaveReader := new(waveReaderMock)
+	stereo := newTestWave()
+	stereo.Channels = append(stereo.Channels, stereo.Channels[0])
+	lowRate := newTestWave()
+	lowRate.SampleRate = 22050
+	vb := &Voicebank{Path: "vb", PhonemesMap: map[string]*Phonemes{
+		"": {
+			&Phoneme{Filename: "a.wav", Alias: "あ", LeftBlank: 100},
+			&Phoneme{Filename: "a.wav", Alias: "- あ", LeftBlank: 80},
+			&Phoneme{Filename: "b.wav", Alias: "い", LeftBlank: 100},
+		},
+		"C4": {
+			&Phoneme{Filename: "c.wav", Alias: "う", LeftBlank: 100},
+			&Phoneme{Filename: "d.wav", Alias: "え", LeftBlank: 100},
+			&Phoneme{Filename: "e.wav", Alias: "お", LeftBlank: 100},
+		},
+	}}
+	mockedWaveReader.On("Read", resolvePath("vb", "a.wav")).Return(newTestWave(), nil).Once()
+	mockedWaveReader.On("Read", resolvePath("vb", "b.wav")).Return(stereo, nil)
+	mockedWaveReader.On("Read", resolvePath(resolvePath("vb", "C4"), "c.wav")).Return(lowRate, nil)
+	mockedWaveReader.On("Read", resolvePath(resolvePath("vb", "C4"), "d.wav")).Return(newTestWave(), nil)
+	mockedWaveReader.On("Read", resolvePath(resolvePath("vb", "C4"), "e.wav")).Return((*Wave)(nil), errors.New("FAILED"))
+	sut := voicebankAuditorDefault{wr: mockedWaveReader, o: DefaultAuditOptions()}
+	r, err := sut.Audit(vb)
+	assert.Equal(t, nil, err)
+	mockedWaveReader.AssertExpectations(t)
+	assert.Equal(t, 44100, r.SampleRate)
+	assert.Equal(t, 16, r.BitsPerSample)
+	assert.Equal(t, 4, len(r.Samples))
+	assert.Equal(t, []string{"あ", "- あ"}, r.Samples[0].Aliases)
+	assert.Equal(t, 2, len(r.Folders))
+	assert.Equal(t, 2, r.Folders[1].Samples)
+	kinds := map[string]string{}
+	for _, i := range r.Issues {
+		kinds[i.Filename] = i.Kind
+	}
+	assert.Equal(t, map[string]string{"b.wav": AuditStereo, "c.wav": AuditSampleRate, "e.wav": AuditUnreadable}, kinds)
+	_, err = json.Marshal(r)
+	assert.Equal(t, nil, err)
+}
@@ -0,0 +1,176 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Resamplers known to leave cache files next to samples.
+const (
+	CacheResamplerUTAU        = "utau"
+	CacheResamplerMoresampler = "moresampler"
+	CacheResamplerWORLD       = "world"
+	CacheResamplerTnFnds      = "tn_fnds"
+)
+
+// cacheKinds maps extensions of per-sample cache files to the resampler producing them.
+var cacheKinds = map[string]string{
+	".frq":      CacheResamplerUTAU,
+	".llsm":     CacheResamplerMoresampler,
+	".mrq":      CacheResamplerMoresampler,
+	".dio":      CacheResamplerWORLD,
+	".star":     CacheResamplerWORLD,
+	".platinum": CacheResamplerWORLD,
+	".pmk":      CacheResamplerTnFnds,
+}
+
+// folderCaches are cache files that cover all samples in their directory.
+var folderCaches = map[string]string{
+	"desc.mrq": CacheResamplerMoresampler,
+}
+
+// CacheFile is a single resampler cache found in a Voicebank directory.
+type CacheFile struct {
+	Path      string    `json:"path"`
+	Resampler string    `json:"resampler"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mod_time"`
+	// Sources are the samples the cache is made from.
+	// A cache of a whole directory has all samples in the directory.
+	Sources []string `json:"sources"`
+	// Orphan is set when none of the sources exists.
+	Orphan bool `json:"orphan"`
+	// Stale is set when any of the sources is newer than the cache.
+	Stale bool `json:"stale"`
+}
+
+// ClassifyCacheFile returns the resampler producing the file, or empty string if the file is not a cache.
+func ClassifyCacheFile(path string) string {
+	base := strings.ToLower(filepath.Base(path))
+	if r, ok := folderCaches[base]; ok {
+		return r
+	}
+	return cacheKinds[filepath.Ext(base)]
+}
+
+// cacheSourceCandidates returns the names of samples a per-sample cache may come from.
+// Resamplers name their caches `a_wav.frq`, `a.wav.dio` or `a.llsm` for `a.wav`.
+func cacheSourceCandidates(path string) []string {
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(path, ext)
+	lower := strings.ToLower(stem)
+	switch {
+	case strings.HasSuffix(lower, "_wav"):
+		return []string{stem[:len(stem)-4] + ".wav"}
+	case strings.HasSuffix(lower, ".wav"):
+		return []string{stem}
+	}
+	return []string{stem + ".wav"}
+}
+
+// CachePurgeOptions selects cache files to purge.
+type CachePurgeOptions struct {
+	// Resamplers limits the purge to caches of the resamplers. Empty means all resamplers.
+	Resamplers []string `json:"resamplers"`
+	// All purges every cache regardless of Orphans and Stale.
+	All     bool `json:"all"`
+	Orphans bool `json:"orphans"`
+	Stale   bool `json:"stale"`
+	DryRun  bool `json:"dry_run"`
+}
+
+// Selects reports whether the cache file is selected by the options.
+func (o CachePurgeOptions) Selects(c *CacheFile) bool {
+	if len(o.Resamplers) > 0 {
+		found := false
+		for _, r := range o.Resamplers {
+			found = found || r == c.Resampler
+		}
+		if !found {
+			return false
+		}
+	}
+	return o.All || (o.Orphans && c.Orphan) || (o.Stale && c.Stale)
+}
+
+// CacheInventory finds and purges resampler caches in a Voicebank directory.
+type CacheInventory interface {
+	Scan(*Voicebank) ([]*CacheFile, error)
+	Purge([]*CacheFile, CachePurgeOptions) ([]*CacheFile, error)
+}
+
+type cacheInventoryDefault struct {
+	fw fileWalker
+	rm fileRemover
+}
+
+// NewCacheInventory creates a CacheInventory that works on filesystem.
+func NewCacheInventory() CacheInventory {
+	return cacheInventoryDefault{fw: fileWalkerDefault{}, rm: fileRemoverDefault{}}
+}
+
+// Scan walks the directory of the Voicebank and classifies every cache file.
+// Sample names are matched case-insensitively as UTAU runs on Windows.
+func (ci cacheInventoryDefault) Scan(vb *Voicebank) ([]*CacheFile, error) {
+	es, err := ci.fw.Walk(vb.Path)
+	if err != nil {
+		return nil, err
+	}
+	samples := map[string]fileEntry{}
+	dirs := map[string][]fileEntry{}
+	for _, e := range es {
+		if strings.ToLower(filepath.Ext(e.path)) != ".wav" {
+			continue
+		}
+		samples[strings.ToLower(e.path)] = e
+		dir := strings.ToLower(filepath.Dir(e.path))
+		dirs[dir] = append(dirs[dir], e)
+	}
+	res := []*CacheFile{}
+	for _, e := range es {
+		r := ClassifyCacheFile(e.path)
+		if r == "" {
+			continue
+		}
+		c := &CacheFile{Path: e.path, Resampler: r, Size: e.info.Size(), ModTime: e.info.ModTime(), Sources: []string{}}
+		sources := []fileEntry{}
+		if _, ok := folderCaches[strings.ToLower(filepath.Base(e.path))]; ok {
+			sources = dirs[strings.ToLower(filepath.Dir(e.path))]
+		} else {
+			for _, s := range cacheSourceCandidates(e.path) {
+				if f, ok := samples[strings.ToLower(s)]; ok {
+					sources = append(sources, f)
+				}
+			}
+		}
+		for _, s := range sources {
+			c.Sources = append(c.Sources, s.path)
+			c.Stale = c.Stale || s.info.ModTime().After(c.ModTime)
+		}
+		c.Orphan = len(sources) == 0
+		res = append(res, c)
+	}
+	return res, nil
+}
+
+// Purge removes the cache files selected by the options and returns them.
+func (ci cacheInventoryDefault) Purge(cs []*CacheFile, o CachePurgeOptions) ([]*CacheFile, error) {
+	res := []*CacheFile{}
+	for _, c := range cs {
+		if !o.Selects(c) {
+			continue
+		}
+		if !o.DryRun {
+			if err := ci.rm.Remove(c.Path); err != nil {
+				return res, err
+			}
+		}
+		res = append(res, c)
+	}
+	return res, nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyCacheFile(t *testing.T) {
+	type TestCase struct {
+		input    string
+		expected string
+	}
+	for i, tc := range []TestCase{
+		{"C4/_ああ_wav.frq", CacheResamplerUTAU},
+		{"C4/_ああ.llsm", CacheResamplerMoresampler},
+		{"C4/desc.mrq", CacheResamplerMoresampler},
+		{"C4/_ああ.wav.dio", CacheResamplerWORLD},
+		{"C4/_ああ.star", CacheResamplerWORLD},
+		{"C4/_ああ.platinum", CacheResamplerWORLD},
+		{"C4/_ああ.pmk", CacheResamplerTnFnds},
+		{"C4/_ああ.wav", ""},
+		{"C4/oto.ini", ""},
+	} {
+		t.Logf("Test case %v.; `%v` should be classified as `%v`.", i+1, tc.input, tc.expected)
+		assert.Equal(t, tc.expected, ClassifyCacheFile(tc.input))
+	}
+}
+
+func TestCacheInventoryScansCaches(t *testing.T) {
+	old := time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)
+	newer := time.Date(2020, 4, 2, 0, 0, 0, 0, time.UTC)
+	file := func(path string, t time.Time) fileEntry {
+		return fileEntry{path: path, info: dummyFileInfo{n: path, s: 10, t: t}}
+	}
+	mockedFileWalker := new(fileWalkerMock)
+	mockedFileWalker.On("Walk", "vb").Return([]fileEntry{
+		file("vb/C4/a.wav", old),
+		file("vb/C4/a_wav.frq", newer),
+		file("vb/C4/I.WAV", newer),
+		file("vb/C4/i.wav.dio", old),
+		file("vb/C4/u.llsm", newer),
+		file("vb/C4/desc.mrq", old),
+		file("vb/C4/oto.ini", old),
+	}, nil)
+	sut := cacheInventoryDefault{fw: mockedFileWalker}
+	cs, err := sut.Scan(&Voicebank{Path: "vb"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []*CacheFile{
+		{Path: "vb/C4/a_wav.frq", Resampler: CacheResamplerUTAU, Size: 10, ModTime: newer, Sources: []string{"vb/C4/a.wav"}},
+		{Path: "vb/C4/i.wav.dio", Resampler: CacheResamplerWORLD, Size: 10, ModTime: old, Sources: []string{"vb/C4/I.WAV"}, Stale: true},
+		{Path: "vb/C4/u.llsm", Resampler: CacheResamplerMoresampler, Size: 10, ModTime: newer, Sources: []string{}, Orphan: true},
+		{Path: "vb/C4/desc.mrq", Resampler: CacheResamplerMoresampler, Size: 10, ModTime: old, Sources: []string{"vb/C4/a.wav", "vb/C4/I.WAV"}, Stale: true},
+	}, cs)
+}
+
+func TestCacheInventoryFailsWhenWalkingFails(t *testing.T) {
+	mockedFileWalker := new(fileWalkerMock)
+	expected := errors.New("FAILED")
+	mockedFileWalker.On("Walk", "vb").Return([]fileEntry(nil), expected)
+	sut := cacheInventoryDefault{fw: mockedFileWalker}
+	_, err := sut.Scan(&Voicebank{Path: "vb"})
+	assert.Equal(t, expected, err)
+}
+
+func TestCacheInventoryPurgesSelectedCaches(t *testing.T) {
+	cs := []*CacheFile{
+		{Path: "a_wav.frq", Resampler: CacheResamplerUTAU, Orphan: true},
+		{Path: "b.llsm", Resampler: CacheResamplerMoresampler, Orphan: true},
+		{Path: "c.pmk", Resampler: CacheResamplerTnFnds, Stale: true},
+		{Path: "d_wav.frq", Resampler: CacheResamplerUTAU},
+	}
+	mockedFileRemover := new(fileRemoverMock)
+	mockedFileRemover.On("Remove", "a_wav.frq").Return(nil)
+	mockedFileRemover.On("Remove", "c.pmk").Return(nil)
+	sut := cacheInventoryDefault{rm: mockedFileRemover}
+	actual, err := sut.Purge(cs, CachePurgeOptions{Resamplers: []string{CacheResamplerUTAU, CacheResamplerTnFnds}, Orphans: true, Stale: true})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []*CacheFile{cs[0], cs[2]}, actual)
+	mockedFileRemover.AssertExpectations(t)
+
+	actual, err = sut.Purge(cs, CachePurgeOptions{All: true, DryRun: true})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, cs, actual)
+	mockedFileRemover.AssertNumberOfCalls(t, "Remove", 2)
+}
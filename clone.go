@@ -0,0 +1,149 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// CloneOptions configures how timings are cloned from a subfolder into others.
+type CloneOptions struct {
+	// Targets are the subfolders to clone into, and empty for all the subfolders but the source.
+	Targets []string `json:"targets"`
+	// Refine refines each copy against its own sample by RefineOptions.
+	Refine        bool          `json:"refine"`
+	RefineOptions RefineOptions `json:"refine_options"`
+	// DryRun leaves the Phonemes untouched and only reports the changes.
+	DryRun bool `json:"dry_run"`
+}
+
+// CloneResult is what cloning timings changes.
+// Unmatched are the Phonemes of the targets without counterparts in the source such as `C5/ka.wav`.
+type CloneResult struct {
+	Changes   []*OtoChange `json:"changes"`
+	Unmatched []string     `json:"unmatched"`
+}
+
+// subfolderAffixes returns the non-empty affixes of prefix.map whose aliases are in the subfolder, longest first.
+func subfolderAffixes(vb *Voicebank, subfolder string) []Affix {
+	res := []Affix{}
+	if vb.Affixes == nil {
+		return res
+	}
+	seen := map[Affix]bool{}
+	for note, a := range *vb.Affixes {
+		if (a.Prefix == "" && a.Suffix == "") || seen[*a] {
+			continue
+		}
+		if affixSubfolders(vb, note, a)[subfolder] {
+			seen[*a] = true
+			res = append(res, *a)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		li, lj := len(res[i].Prefix)+len(res[i].Suffix), len(res[j].Prefix)+len(res[j].Suffix)
+		if li != lj {
+			return li > lj
+		}
+		return res[i].Prefix+res[i].Suffix < res[j].Prefix+res[j].Suffix
+	})
+	return res
+}
+
+// baseAlias removes the first of the affixes the alias has.
+func baseAlias(alias string, affixes []Affix) string {
+	for _, a := range affixes {
+		if len(alias) > len(a.Prefix)+len(a.Suffix) && strings.HasPrefix(alias, a.Prefix) && strings.HasSuffix(alias, a.Suffix) {
+			return strings.TrimSuffix(strings.TrimPrefix(alias, a.Prefix), a.Suffix)
+		}
+	}
+	return alias
+}
+
+// TimingCloner copies timings of Phonemes in a subfolder into the corresponding Phonemes in other subfolders.
+type TimingCloner interface {
+	Clone(vb *Voicebank, source string, o CloneOptions) (*CloneResult, error)
+}
+
+type timingClonerDefault struct {
+	wr WaveReader
+}
+
+// NewTimingCloner creates a TimingCloner that reads samples from filesystem to refine copies.
+func NewTimingCloner() TimingCloner {
+	return timingClonerDefault{wr: NewWaveReader()}
+}
+
+// Clone copies LeftBlank, Consonant, RightBlank, PreUtterance and Overlap of the Phonemes in the source subfolder
+// into the Phonemes of the targets. A Phoneme of a target corresponds to the Phoneme of the source
+// with the same alias once the affixes of prefix.map for each subfolder are removed, such as `か↑` to `か`,
+// or to the one with the same filename otherwise. Copies whose samples cannot be read are not refined.
+// Phonemes are updated in place unless CloneOptions.DryRun is set.
+func (tc timingClonerDefault) Clone(vb *Voicebank, source string, o CloneOptions) (*CloneResult, error) {
+	src, ok := vb.PhonemesMap[source]
+	if !ok {
+		return nil, errors.New("The given source subfolder does not exist; `" + source + "`")
+	}
+	targets := o.Targets
+	if len(targets) == 0 {
+		targets = []string{}
+		for _, sub := range sortedSubfolders(vb) {
+			if sub != source {
+				targets = append(targets, sub)
+			}
+		}
+	}
+
+	srcAffixes := subfolderAffixes(vb, source)
+	byAlias, byFilename := map[string]*Phoneme{}, map[string]*Phoneme{}
+	for _, p := range *src {
+		if a := baseAlias(effectiveAlias(p), srcAffixes); byAlias[a] == nil {
+			byAlias[a] = p
+		}
+		if byFilename[p.Filename] == nil {
+			byFilename[p.Filename] = p
+		}
+	}
+
+	res := &CloneResult{Changes: []*OtoChange{}, Unmatched: []string{}}
+	for _, sub := range targets {
+		ps, ok := vb.PhonemesMap[sub]
+		if !ok {
+			return nil, errors.New("The given target subfolder does not exist; `" + sub + "`")
+		}
+		if sub == source {
+			continue
+		}
+		affixes := subfolderAffixes(vb, sub)
+		for _, p := range *ps {
+			s := byAlias[baseAlias(effectiveAlias(p), affixes)]
+			if s == nil {
+				s = byFilename[p.Filename]
+			}
+			if s == nil {
+				res.Unmatched = append(res.Unmatched, strings.TrimPrefix(sub+"/"+p.Filename, "/"))
+				continue
+			}
+			after := *s
+			after.Filename, after.Alias = p.Filename, p.Alias
+			if o.Refine {
+				if w, err := tc.wr.Read(vb.SamplePath(sub, p)); err == nil {
+					after = RefinePhoneme(&after, w, o.RefineOptions).After
+				}
+			}
+			if c := (&OtoChange{Subfolder: sub, Phoneme: p, Before: *p, After: after}); c.Changed() {
+				res.Changes = append(res.Changes, c)
+			}
+		}
+	}
+	if !o.DryRun {
+		for _, c := range res.Changes {
+			c.Apply()
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCloneVoicebank() *Voicebank {
+	return newTestVoicebank(map[string]string{
+		"C4": "ka.wav=か,95,70,-400,70,20\nsa.wav=さ,50,90,-300,80,10",
+		"C5": "ka.wav=か↑,0,0,0,0,0\nsa.wav=sa↑,0,0,0,0,0\nta.wav=た↑,0,0,0,0,0",
+		"G3": "ka_low.wav=か↓,0,0,0,0,0",
+	}, &Affixes{"C4": &Affix{}, "C5": &Affix{Suffix: "↑"}, "G3": &Affix{Suffix: "↓"}})
+}
+
+func TestSubfolderAffixes(t *testing.T) {
+	vb := newTestCloneVoicebank()
+	assert.Equal(t, []Affix{{Suffix: "↑"}}, subfolderAffixes(vb, "C5"))
+	assert.Equal(t, []Affix{}, subfolderAffixes(vb, "C4"))
+	assert.Equal(t, "か", baseAlias("か↑", subfolderAffixes(vb, "C5")))
+	assert.Equal(t, "↑", baseAlias("↑", subfolderAffixes(vb, "C5")))
+}
+
+func TestTimingClonerClones(t *testing.T) {
+	vb := newTestCloneVoicebank()
+	sut := timingClonerDefault{wr: new(waveReaderMock)}
+	res, err := sut.Clone(vb, "C4", CloneOptions{DryRun: true})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"C5/ta.wav"}, res.Unmatched)
+	assert.Equal(t, "--- a/C5/oto.ini\n+++ b/C5/oto.ini\n"+
+		"-ka.wav=か↑,0,0,0,0,0\n+ka.wav=か↑,95,70,-400,70,20\n"+
+		"-sa.wav=sa↑,0,0,0,0,0\n+sa.wav=sa↑,50,90,-300,80,10\n"+
+		"--- a/G3/oto.ini\n+++ b/G3/oto.ini\n"+
+		"-ka_low.wav=か↓,0,0,0,0,0\n+ka_low.wav=か↓,95,70,-400,70,20\n", FormatOtoChanges(res.Changes))
+	assert.Equal(t, 0.0, (*vb.PhonemesMap["C5"])[0].LeftBlank, "Dry run should not modify Phonemes.")
+
+	res, err = sut.Clone(vb, "C4", CloneOptions{Targets: []string{"C5"}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(res.Changes))
+	assert.Equal(t, 95.0, (*vb.PhonemesMap["C5"])[0].LeftBlank)
+	assert.Equal(t, "か↑", (*vb.PhonemesMap["C5"])[0].Alias)
+	assert.Equal(t, 0.0, (*vb.PhonemesMap["G3"])[0].LeftBlank)
+
+	_, err = sut.Clone(vb, "D4", CloneOptions{})
+	assert.NotEqual(t, nil, err)
+	_, err = sut.Clone(vb, "C4", CloneOptions{Targets: []string{"D4"}})
+	assert.NotEqual(t, nil, err)
+}
+
+func TestTimingClonerRefinesCopies(t *testing.T) {
+	vb := newTestCloneVoicebank()
+	mockedWaveReader := new(waveReaderMock)
+	mockedWaveReader.On("Read", vb.SamplePath("C5", (*vb.PhonemesMap["C5"])[0])).Return(newTestWave(), nil)
+	mockedWaveReader.On("Read", vb.SamplePath("C5", (*vb.PhonemesMap["C5"])[1])).Return((*Wave)(nil), errors.New("not found"))
+	sut := timingClonerDefault{wr: mockedWaveReader}
+	res, err := sut.Clone(vb, "C4", CloneOptions{Targets: []string{"C5"}, Refine: true, RefineOptions: DefaultRefineOptions()})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(res.Changes))
+	ka := (*vb.PhonemesMap["C5"])[0]
+	assert.InDelta(t, 150, ka.LeftBlank+ka.PreUtterance, 5, "The copy should be refined against its own sample.")
+	assert.Equal(t, 50.0, (*vb.PhonemesMap["C5"])[1].LeftBlank, "A copy without its sample should be kept as copied.")
+}
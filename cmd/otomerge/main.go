@@ -0,0 +1,26 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Command otomerge is a git merge driver for oto.ini. Install it by
+//
+//	go install utau/cmd/otomerge
+//	git config merge.oto.driver "otomerge %O %A %B"
+//	echo "oto.ini merge=oto" >> .gitattributes
+//
+// It exits with status 1 when the merge has conflicts, so that git leaves them to be resolved.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"utau"
+)
+
+func main() {
+	if err := utau.RunMergeDriver(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "otomerge: "+err.Error())
+		os.Exit(1)
+	}
+}
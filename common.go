@@ -7,6 +7,9 @@ package utau
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
 type fileReader interface {
@@ -28,6 +31,53 @@ func readFile(filename string) (string, error) {
 	return string(bs), nil
 }
 
+type fileWriter interface {
+	Write(string, string) error
+}
+
+type fileWriterDefault struct {
+}
+
+func (fw fileWriterDefault) Write(filename string, text string) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, []byte(text), 0644)
+}
+
+type fileModeChanger interface {
+	Chmod(string, os.FileMode) error
+}
+
+type fileModeChangerDefault struct {
+}
+
+func (fc fileModeChangerDefault) Chmod(filename string, mode os.FileMode) error {
+	return os.Chmod(filename, mode)
+}
+
+type fileRemover interface {
+	Remove(string) error
+}
+
+type fileRemoverDefault struct {
+}
+
+func (fr fileRemoverDefault) Remove(filename string) error {
+	return os.Remove(filename)
+}
+
+type fileStater interface {
+	Stat(string) (os.FileInfo, error)
+}
+
+type fileStaterDefault struct {
+}
+
+func (fs fileStaterDefault) Stat(filename string) (os.FileInfo, error) {
+	return os.Stat(filename)
+}
+
 type directoryEnumerator interface {
 	Enumerate(string) ([]os.FileInfo, error)
 }
@@ -48,3 +98,50 @@ func (de directoryEnumeratorDefault) Enumerate(path string) ([]os.FileInfo, erro
 	}
 	return res, nil
 }
+
+type fileEntry struct {
+	path string
+	info os.FileInfo
+}
+
+type fileWalker interface {
+	Walk(string) ([]fileEntry, error)
+}
+
+type fileWalkerDefault struct {
+}
+
+func (fw fileWalkerDefault) Walk(root string) ([]fileEntry, error) {
+	res := []fileEntry{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			res = append(res, fileEntry{path: path, info: info})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func sortedSubfolders(vb *Voicebank) []string {
+	res := make([]string, 0, len(vb.PhonemesMap))
+	for k := range vb.PhonemesMap {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func writeDiffHeader(b *strings.Builder, subfolder string) {
+	writeFileDiffHeader(b, otoName(subfolder))
+}
+
+func writeFileDiffHeader(b *strings.Builder, name string) {
+	b.WriteString("--- a/" + name + "\n")
+	b.WriteString("+++ b/" + name + "\n")
+}
@@ -6,6 +6,7 @@ package utau
 
 import (
 	"os"
+	"strings"
 	"time"
 
 	"github.com/stretchr/testify/mock"
@@ -20,6 +21,45 @@ func (m *fileReaderMock) Read(fn string) (string, error) {
 	return args.Get(0).(string), args.Error(1)
 }
 
+type fileWriterMock struct {
+	mock.Mock
+}
+
+func (m *fileWriterMock) Write(fn string, text string) error {
+	args := m.Called(fn, text)
+	return args.Error(0)
+}
+
+type fileRemoverMock struct {
+	mock.Mock
+}
+
+func (m *fileRemoverMock) Remove(fn string) error {
+	args := m.Called(fn)
+	return args.Error(0)
+}
+
+type fileModeChangerMock struct {
+	mock.Mock
+}
+
+func (m *fileModeChangerMock) Chmod(fn string, mode os.FileMode) error {
+	args := m.Called(fn, mode)
+	return args.Error(0)
+}
+
+type fileStaterMock struct {
+	mock.Mock
+}
+
+func (m *fileStaterMock) Stat(fn string) (os.FileInfo, error) {
+	args := m.Called(fn)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(os.FileInfo), args.Error(1)
+}
+
 type directoryEnumeratorMock struct {
 	mock.Mock
 }
@@ -29,6 +69,15 @@ func (m *directoryEnumeratorMock) Enumerate(path string) ([]os.FileInfo, error)
 	return args.Get(0).([]os.FileInfo), args.Error(1)
 }
 
+type fileWalkerMock struct {
+	mock.Mock
+}
+
+func (m *fileWalkerMock) Walk(root string) ([]fileEntry, error) {
+	args := m.Called(root)
+	return args.Get(0).([]fileEntry), args.Error(1)
+}
+
 type dummyFileInfo struct {
 	n string
 	s int64
@@ -42,3 +91,45 @@ func (d dummyFileInfo) Mode() os.FileMode  { return d.m }
 func (d dummyFileInfo) ModTime() time.Time { return d.t }
 func (d dummyFileInfo) IsDir() bool        { return d.m.IsDir() }
 func (d dummyFileInfo) Sys() interface{}   { return nil }
+
+// newTestPhonemes builds Phonemes from the text of oto.ini.
+func newTestPhonemes(text string) *Phonemes {
+	ps, _ := NewPhonemesFromText(text)
+	return ps
+}
+
+// newTestVoicebank builds a Voicebank at `vb` from the text of oto.ini in each subfolder.
+func newTestVoicebank(otos map[string]string, affixes *Affixes) *Voicebank {
+	vb := &Voicebank{Path: "vb", PhonemesMap: map[string]*Phonemes{}, Affixes: affixes}
+	for sub, text := range otos {
+		vb.PhonemesMap[sub] = newTestPhonemes(text)
+	}
+	return vb
+}
+
+// newTestAliasVoicebank builds a Voicebank whose Phonemes have the aliases and the same timings.
+func newTestAliasVoicebank(aliases ...string) *Voicebank {
+	lines := []string{}
+	for _, a := range aliases {
+		lines = append(lines, a+".wav="+a+",100,100,-300,100,30")
+	}
+	return newTestVoicebank(map[string]string{"": strings.Join(lines, "\n")}, &Affixes{})
+}
+
+// newLyricNotes builds notes of 480 ticks at C4 with the lyrics separated by spaces.
+func newLyricNotes(lyrics string) []*Note {
+	res := []*Note{}
+	for _, l := range strings.Fields(lyrics) {
+		res = append(res, &Note{Length: 480, Lyric: l, NoteNum: 60, Extra: []*UstEntry{}})
+	}
+	return res
+}
+
+// newTestProject builds a Project of the notes of the lyrics, each at the note number given in order.
+func newTestProject(lyrics string, noteNums ...int) *Project {
+	p := &Project{ProjectName: "test", Tempo: 120, Flags: "g-5", Settings: []*UstEntry{}, Notes: newLyricNotes(lyrics)}
+	for i, n := range noteNums {
+		p.Notes[i].NoteNum = n
+	}
+	return p
+}
@@ -0,0 +1,276 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// Kinds of PhonemeDiff.
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+)
+
+// phonemeFields are the names of the timing fields of Phoneme in the order of oto.ini.
+var phonemeFields = []string{PhonemeFieldLeftBlank, PhonemeFieldConsonant, PhonemeFieldRightBlank, PhonemeFieldPreUtterance, PhonemeFieldOverlap}
+
+// FieldDelta is a timing field of Phoneme changed by Delta milliseconds.
+type FieldDelta struct {
+	Field  string  `json:"field"`
+	Before float64 `json:"before"`
+	After  float64 `json:"after"`
+	Delta  float64 `json:"delta"`
+}
+
+// PhonemeDiff is a Phoneme added, removed or changed in a subfolder, keyed by its alias.
+// Renamed tells the Phoneme refers to another file.
+type PhonemeDiff struct {
+	Kind      string        `json:"kind"`
+	Subfolder string        `json:"subfolder"`
+	Alias     string        `json:"alias"`
+	Before    *Phoneme      `json:"before,omitempty"`
+	After     *Phoneme      `json:"after,omitempty"`
+	Renamed   bool          `json:"renamed"`
+	Deltas    []*FieldDelta `json:"deltas,omitempty"`
+}
+
+// CharacterDiff is a value of character.txt changed, where Key is the key in the file such as `name`.
+type CharacterDiff struct {
+	Key    string `json:"key"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// AffixDiff is an entry of prefix.map changed, where Before or After is nil for an entry added or removed.
+type AffixDiff struct {
+	Note   string `json:"note"`
+	Before *Affix `json:"before"`
+	After  *Affix `json:"after"`
+}
+
+// VoicebankDiff is the difference between two Voicebanks.
+type VoicebankDiff struct {
+	Phonemes  []*PhonemeDiff   `json:"phonemes"`
+	Character []*CharacterDiff `json:"character"`
+	Affixes   []*AffixDiff     `json:"affixes"`
+}
+
+// DiffPhonemes compares two versions of oto.ini in the subfolder by aliases,
+// where a Phoneme without alias is keyed by its filename without the extension.
+// Phonemes sharing an alias are paired in their order. Removed and changed Phonemes come in the order of before,
+// and added ones follow in the order of after.
+func DiffPhonemes(subfolder string, before *Phonemes, after *Phonemes) []*PhonemeDiff {
+	res := []*PhonemeDiff{}
+	index := map[string][]*Phoneme{}
+	if after != nil {
+		for _, p := range *after {
+			index[effectiveAlias(p)] = append(index[effectiveAlias(p)], p)
+		}
+	}
+	if before != nil {
+		for _, p := range *before {
+			alias := effectiveAlias(p)
+			ps := index[alias]
+			if len(ps) == 0 {
+				res = append(res, &PhonemeDiff{Kind: DiffRemoved, Subfolder: subfolder, Alias: alias, Before: p})
+				continue
+			}
+			q := ps[0]
+			index[alias] = ps[1:]
+			if *p == *q {
+				continue
+			}
+			d := &PhonemeDiff{Kind: DiffChanged, Subfolder: subfolder, Alias: alias, Before: p, After: q, Renamed: p.Filename != q.Filename, Deltas: []*FieldDelta{}}
+			for _, f := range phonemeFields {
+				b, a := *phonemeField(p, f), *phonemeField(q, f)
+				if a != b {
+					d.Deltas = append(d.Deltas, &FieldDelta{Field: f, Before: b, After: a, Delta: roundOtoValue(a - b)})
+				}
+			}
+			res = append(res, d)
+		}
+	}
+	if after != nil {
+		for _, p := range *after {
+			alias := effectiveAlias(p)
+			if ps := index[alias]; len(ps) > 0 && ps[0] == p {
+				index[alias] = ps[1:]
+				res = append(res, &PhonemeDiff{Kind: DiffAdded, Subfolder: subfolder, Alias: alias, After: p})
+			}
+		}
+	}
+	return res
+}
+
+// characterEntries returns the values of character.txt by the keys in the file.
+func characterEntries(c *Character) [][2]string {
+	if c == nil {
+		c = &Character{}
+	}
+	return [][2]string{{"name", c.Name}, {"image", c.ImagePath}, {"sample", c.SampleWavePath}, {"author", c.Author}, {"web", c.WebURLPath}}
+}
+
+// DiffVoicebanks compares two Voicebanks by subfolders and aliases, Character and Affixes.
+// Subfolders and notes of prefix.map come in the order of their names.
+func DiffVoicebanks(before *Voicebank, after *Voicebank) *VoicebankDiff {
+	res := &VoicebankDiff{Phonemes: []*PhonemeDiff{}, Character: []*CharacterDiff{}, Affixes: []*AffixDiff{}}
+	subs := map[string]bool{}
+	for _, sub := range sortedSubfolders(before) {
+		subs[sub] = true
+	}
+	for _, sub := range sortedSubfolders(after) {
+		subs[sub] = true
+	}
+	names := make([]string, 0, len(subs))
+	for sub := range subs {
+		names = append(names, sub)
+	}
+	sort.Strings(names)
+	for _, sub := range names {
+		res.Phonemes = append(res.Phonemes, DiffPhonemes(sub, before.PhonemesMap[sub], after.PhonemesMap[sub])...)
+	}
+
+	bs, as := characterEntries(before.Character), characterEntries(after.Character)
+	for i := range bs {
+		if bs[i][1] != as[i][1] {
+			res.Character = append(res.Character, &CharacterDiff{Key: bs[i][0], Before: bs[i][1], After: as[i][1]})
+		}
+	}
+
+	ba, aa := Affixes{}, Affixes{}
+	if before.Affixes != nil {
+		ba = *before.Affixes
+	}
+	if after.Affixes != nil {
+		aa = *after.Affixes
+	}
+	notes := []string{}
+	for n := range ba {
+		notes = append(notes, n)
+	}
+	for n := range aa {
+		if _, ok := ba[n]; !ok {
+			notes = append(notes, n)
+		}
+	}
+	sort.Strings(notes)
+	for _, n := range notes {
+		b, a := ba[n], aa[n]
+		if b != nil && a != nil && *b == *a {
+			continue
+		}
+		res.Affixes = append(res.Affixes, &AffixDiff{Note: n, Before: b, After: a})
+	}
+	return res
+}
+
+// Empty reports whether the Voicebanks are the same.
+func (d *VoicebankDiff) Empty() bool {
+	return len(d.Phonemes) == 0 && len(d.Character) == 0 && len(d.Affixes) == 0
+}
+
+func otoName(subfolder string) string {
+	if subfolder == "" {
+		return "oto.ini"
+	}
+	return subfolder + "/oto.ini"
+}
+
+func affixLine(note string, a *Affix) string {
+	return note + "\t" + a.Prefix + "\t" + a.Suffix
+}
+
+// Text formats the difference for people, a line for each Phoneme, value and entry.
+func (d *VoicebankDiff) Text() string {
+	var b strings.Builder
+	for _, p := range d.Phonemes {
+		b.WriteString(otoName(p.Subfolder) + ": " + p.Kind + " `" + p.Alias + "`")
+		switch p.Kind {
+		case DiffAdded:
+			b.WriteString(" (" + p.After.Filename + ")")
+		case DiffRemoved:
+			b.WriteString(" (" + p.Before.Filename + ")")
+		default:
+			changes := []string{}
+			if p.Before.Alias != p.After.Alias {
+				changes = append(changes, "alias `"+p.Before.Alias+"` -> `"+p.After.Alias+"`")
+			}
+			if p.Renamed {
+				changes = append(changes, "file "+p.Before.Filename+" -> "+p.After.Filename)
+			}
+			for _, f := range p.Deltas {
+				sign := ""
+				if f.Delta > 0 {
+					sign = "+"
+				}
+				changes = append(changes, f.Field+" "+formatFloat(f.Before)+" -> "+formatFloat(f.After)+" ("+sign+formatFloat(f.Delta)+")")
+			}
+			b.WriteString("; " + strings.Join(changes, ", "))
+		}
+		b.WriteString("\n")
+	}
+	for _, c := range d.Character {
+		b.WriteString("character.txt: " + c.Key + " `" + c.Before + "` -> `" + c.After + "`\n")
+	}
+	for _, a := range d.Affixes {
+		switch {
+		case a.Before == nil:
+			b.WriteString("prefix.map: added " + a.Note + " `" + a.After.Prefix + "` `" + a.After.Suffix + "`\n")
+		case a.After == nil:
+			b.WriteString("prefix.map: removed " + a.Note + " `" + a.Before.Prefix + "` `" + a.Before.Suffix + "`\n")
+		default:
+			b.WriteString("prefix.map: changed " + a.Note + " `" + a.Before.Prefix + "` `" + a.Before.Suffix + "` -> `" + a.After.Prefix + "` `" + a.After.Suffix + "`\n")
+		}
+	}
+	return b.String()
+}
+
+// Unified formats the difference like a unified diff of oto.ini, character.txt and prefix.map lines.
+func (d *VoicebankDiff) Unified() string {
+	var b strings.Builder
+	current := ""
+	first := true
+	for _, p := range d.Phonemes {
+		if first || p.Subfolder != current {
+			writeDiffHeader(&b, p.Subfolder)
+			current = p.Subfolder
+			first = false
+		}
+		if p.Before != nil {
+			b.WriteString("-" + p.Before.Line() + "\n")
+		}
+		if p.After != nil {
+			b.WriteString("+" + p.After.Line() + "\n")
+		}
+	}
+	if len(d.Character) > 0 {
+		writeFileDiffHeader(&b, "character.txt")
+		for _, c := range d.Character {
+			b.WriteString("-" + c.Key + "=" + c.Before + "\n")
+			b.WriteString("+" + c.Key + "=" + c.After + "\n")
+		}
+	}
+	if len(d.Affixes) > 0 {
+		writeFileDiffHeader(&b, "prefix.map")
+		for _, a := range d.Affixes {
+			if a.Before != nil {
+				b.WriteString("-" + affixLine(a.Note, a.Before) + "\n")
+			}
+			if a.After != nil {
+				b.WriteString("+" + affixLine(a.Note, a.After) + "\n")
+			}
+		}
+	}
+	return b.String()
+}
+
+// JSON formats the difference as JSON.
+func (d *VoicebankDiff) JSON() ([]byte, error) {
+	return json.Marshal(d)
+}
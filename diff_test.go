@@ -0,0 +1,88 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestDiffVoicebanks() (*Voicebank, *Voicebank) {
+	before := newTestVoicebank(map[string]string{
+		"":   "ka.wav=か,95,70,-400,70,20\nsa.wav=さ,50,90,-300,80,10",
+		"C5": "ka.wav=か↑,0,0,0,0,0",
+	}, &Affixes{"C4": &Affix{}, "C5": &Affix{Suffix: "↑"}})
+	before.Path, before.Character = "old", &Character{Name: "Teto", Author: "A"}
+	after := newTestVoicebank(map[string]string{
+		"":   "ka2.wav=か,100,70,-400,62.5,20\nta.wav=た,0,0,0,0,0",
+		"C5": "ka.wav=か↑,0,0,0,0,0",
+	}, &Affixes{"C5": &Affix{Suffix: "_C5"}, "D5": &Affix{Suffix: "_C5"}})
+	after.Path, after.Character = "new", &Character{Name: "Teto", Author: "B"}
+	return before, after
+}
+
+func TestDiffPhonemes(t *testing.T) {
+	before := &Phonemes{
+		&Phoneme{Filename: "a.wav", Alias: "あ"},
+		&Phoneme{Filename: "a2.wav", Alias: "あ"},
+		&Phoneme{Filename: "i.wav"},
+	}
+	after := &Phonemes{
+		&Phoneme{Filename: "a.wav", Alias: "あ"},
+		&Phoneme{Filename: "a3.wav", Alias: "あ"},
+		&Phoneme{Filename: "a4.wav", Alias: "あ"},
+		&Phoneme{Filename: "i.wav", Alias: "i"},
+	}
+	ds := DiffPhonemes("C4", before, after)
+	assert.Equal(t, 3, len(ds))
+	assert.Equal(t, DiffChanged, ds[0].Kind)
+	assert.Equal(t, true, ds[0].Renamed)
+	assert.Equal(t, "a3.wav", ds[0].After.Filename)
+	assert.Equal(t, 0, len(ds[0].Deltas))
+	assert.Equal(t, DiffChanged, ds[1].Kind, "A Phoneme without alias should be keyed by its filename.")
+	assert.Equal(t, "i", ds[1].Alias)
+	assert.Equal(t, false, ds[1].Renamed)
+	assert.Equal(t, "C4/oto.ini: changed `i`; alias `` -> `i`\n", (&VoicebankDiff{Phonemes: ds[1:2]}).Text())
+	assert.Equal(t, DiffAdded, ds[2].Kind)
+	assert.Equal(t, "a4.wav", ds[2].After.Filename)
+
+	assert.Equal(t, 3, len(DiffPhonemes("", before, nil)))
+	assert.Equal(t, 0, len(DiffPhonemes("", testPhonemes, testPhonemes)))
+}
+
+func TestDiffVoicebanks(t *testing.T) {
+	before, after := newTestDiffVoicebanks()
+	d := DiffVoicebanks(before, after)
+	assert.Equal(t, false, d.Empty())
+	assert.Equal(t, true, DiffVoicebanks(testVoicebank, testVoicebank).Empty())
+
+	assert.Equal(t, "oto.ini: changed `か`; file ka.wav -> ka2.wav, left_blank 95 -> 100 (+5), pre_utterance 70 -> 62.5 (-7.5)\n"+
+		"oto.ini: removed `さ` (sa.wav)\n"+
+		"oto.ini: added `た` (ta.wav)\n"+
+		"character.txt: author `A` -> `B`\n"+
+		"prefix.map: removed C4 `` ``\n"+
+		"prefix.map: changed C5 `` `↑` -> `` `_C5`\n"+
+		"prefix.map: added D5 `` `_C5`\n", d.Text())
+
+	assert.Equal(t, "--- a/oto.ini\n+++ b/oto.ini\n"+
+		"-ka.wav=か,95,70,-400,70,20\n+ka2.wav=か,100,70,-400,62.5,20\n"+
+		"-sa.wav=さ,50,90,-300,80,10\n"+
+		"+ta.wav=た,0,0,0,0,0\n"+
+		"--- a/character.txt\n+++ b/character.txt\n-author=A\n+author=B\n"+
+		"--- a/prefix.map\n+++ b/prefix.map\n-C4\t\t\n-C5\t\t↑\n+C5\t\t_C5\n+D5\t\t_C5\n", d.Unified())
+
+	bs, err := d.JSON()
+	assert.Equal(t, nil, err)
+	var decoded VoicebankDiff
+	assert.Equal(t, nil, json.Unmarshal(bs, &decoded))
+	assert.Equal(t, 3, len(decoded.Phonemes))
+	assert.Equal(t, []*FieldDelta{
+		{Field: PhonemeFieldLeftBlank, Before: 95, After: 100, Delta: 5},
+		{Field: PhonemeFieldPreUtterance, Before: 70, After: 62.5, Delta: -7.5},
+	}, decoded.Phonemes[0].Deltas)
+	assert.Contains(t, string(bs), `"kind":"removed"`)
+}
@@ -0,0 +1,126 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// fft computes the discrete Fourier transform in place.
+// The length of x must be a power of two.
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		w := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			wk := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a := x[start+k]
+				b := x[start+k+size/2] * wk
+				x[start+k] = a + b
+				x[start+k+size/2] = a - b
+				wk *= w
+			}
+		}
+	}
+}
+
+// ifft computes the inverse discrete Fourier transform in place.
+func ifft(x []complex128) {
+	for i := range x {
+		x[i] = cmplx.Conj(x[i])
+	}
+	fft(x)
+	n := complex(float64(len(x)), 0)
+	for i := range x {
+		x[i] = cmplx.Conj(x[i]) / n
+	}
+}
+
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+func hann(n int) []float64 {
+	res := make([]float64, n)
+	if n == 1 {
+		res[0] = 1
+		return res
+	}
+	for i := range res {
+		res[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n-1))
+	}
+	return res
+}
+
+// magnitudeSpectrum returns the magnitude of the first half of the spectrum
+// of the frame centered at the given index, windowed by a Hann window.
+func magnitudeSpectrum(s []float64, center int, size int, window []float64) []float64 {
+	x := make([]complex128, size)
+	for i := 0; i < size; i++ {
+		j := center - size/2 + i
+		if j >= 0 && j < len(s) {
+			x[i] = complex(s[j]*window[i], 0)
+		}
+	}
+	fft(x)
+	res := make([]float64, size/2+1)
+	for i := range res {
+		res[i] = cmplx.Abs(x[i])
+	}
+	return res
+}
+
+// resample converts the sample rate of s by band limited interpolation with a Hann windowed sinc.
+func resample(s []float64, from int, to int) []float64 {
+	if from == to || from <= 0 || to <= 0 {
+		return append([]float64{}, s...)
+	}
+	const taps = 32
+	ratio := float64(to) / float64(from)
+	cutoff := math.Min(1, ratio)
+	n := int(math.Ceil(float64(len(s)) * ratio))
+	res := make([]float64, n)
+	for i := range res {
+		t := float64(i) / ratio
+		center := int(math.Floor(t))
+		sum, weight := 0.0, 0.0
+		for j := center - taps + 1; j <= center+taps; j++ {
+			x := t - float64(j)
+			w := 0.5 + 0.5*math.Cos(math.Pi*x/taps)
+			v := cutoff * sinc(cutoff*x) * w
+			weight += v
+			if j >= 0 && j < len(s) {
+				sum += s[j] * v
+			}
+		}
+		if weight != 0 {
+			res[i] = sum / weight
+		}
+	}
+	return res
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
@@ -0,0 +1,53 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFFTFindsTheFrequencyOfSine(t *testing.T) {
+	const size = 64
+	x := make([]complex128, size)
+	for i := range x {
+		x[i] = complex(math.Sin(2*math.Pi*4*float64(i)/size), 0)
+	}
+	fft(x)
+	for i, v := range x {
+		if i == 4 || i == size-4 {
+			assert.InDelta(t, size/2, math.Hypot(real(v), imag(v)), 1e-9)
+		} else {
+			assert.InDelta(t, 0, math.Hypot(real(v), imag(v)), 1e-9)
+		}
+	}
+}
+
+func TestIFFTInvertsFFT(t *testing.T) {
+	x := []complex128{1, 2, 3, 4, 0, -1, -2, -3}
+	y := append([]complex128{}, x...)
+	fft(y)
+	ifft(y)
+	for i := range x {
+		assert.InDelta(t, real(x[i]), real(y[i]), 1e-9)
+		assert.InDelta(t, 0, imag(y[i]), 1e-9)
+	}
+}
+
+func TestNextPowerOfTwo(t *testing.T) {
+	assert.Equal(t, 1, nextPowerOfTwo(1))
+	assert.Equal(t, 1024, nextPowerOfTwo(1000))
+	assert.Equal(t, 1024, nextPowerOfTwo(1024))
+}
+
+func TestResampleKeepsFrequency(t *testing.T) {
+	w := newSineWave(22050, 440, 0.5, 100)
+	actual := resample(w.Channels[0], 22050, 44100)
+	assert.Equal(t, 4410, len(actual))
+	expected := newSineWave(44100, 440, 0.5, 100).Channels[0]
+	assert.InDeltaSlice(t, expected[100:4300], actual[100:4300], 0.01)
+}
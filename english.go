@@ -0,0 +1,401 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Conventions of aliases of English voicebanks.
+const (
+	// EnglishConventionARPAsing has aliases of pairs of ARPABET phones such as `- hh`, `hh ae` and `ae l`.
+	EnglishConventionARPAsing = "arpasing"
+	// EnglishConventionVCCV has aliases of syllables and transitions in VCCV symbols such as `- k@`, `k@` and `@ t`.
+	EnglishConventionVCCV = "vccv"
+)
+
+// arpabetVowels maps ARPABET vowels into the symbols VCCV uses for them.
+var arpabetVowels = map[string]string{
+	"aa": "a", "ae": "@", "ah": "u", "ao": "9", "aw": "8", "ay": "I", "eh": "E", "er": "3",
+	"ey": "A", "ih": "i", "iy": "e", "ow": "O", "oy": "Q", "uh": "6", "uw": "o",
+}
+
+// arpabetConsonants maps ARPABET consonants into the symbols VCCV uses for them.
+var arpabetConsonants = map[string]string{
+	"b": "b", "ch": "ch", "d": "d", "dh": "D", "f": "f", "g": "g", "hh": "h", "jh": "j",
+	"k": "k", "l": "l", "m": "m", "n": "n", "ng": "N", "p": "p", "r": "r", "s": "s",
+	"sh": "S", "t": "t", "th": "T", "v": "v", "w": "w", "y": "y", "z": "z", "zh": "Z",
+}
+
+// englishOnsets are the clusters of consonants that can start an English syllable besides single ones.
+var englishOnsets = map[string]bool{
+	"p r": true, "p l": true, "b r": true, "b l": true, "t r": true, "d r": true, "k r": true, "k l": true,
+	"g r": true, "g l": true, "f r": true, "f l": true, "th r": true, "sh r": true, "t w": true, "d w": true,
+	"k w": true, "g w": true, "s w": true, "s p": true, "s t": true, "s k": true, "s m": true, "s n": true,
+	"s l": true, "p y": true, "b y": true, "k y": true, "m y": true, "f y": true, "v y": true, "hh y": true,
+	"s p r": true, "s p l": true, "s t r": true, "s k r": true, "s k w": true,
+}
+
+func isArpabetPhone(s string) bool {
+	_, v := arpabetVowels[s]
+	_, c := arpabetConsonants[s]
+	return v || c
+}
+
+// Pronunciations is a pronunciation dictionary in the format of CMUdict.
+// Words are in lowercase, and pronunciations are ARPABET phones in lowercase without stresses.
+type Pronunciations map[string][][]string
+
+// NewPronunciationsFromText creates Pronunciations from a dictionary such as cmudict.dict.
+// Each line is a word followed by its phones, and alternatives are written as `word(2)`.
+// Lines starting with `;;;` or `#` are comments, and so is the rest of a line after `#`.
+func NewPronunciationsFromText(t string) (*Pronunciations, error) {
+	res := Pronunciations{}
+	for i, l := range strings.Split(t, "\n") {
+		if j := strings.Index(l, "#"); j >= 0 {
+			l = l[:j]
+		}
+		es := strings.Fields(l)
+		if len(es) == 0 || strings.HasPrefix(es[0], ";;;") {
+			continue
+		}
+		if len(es) < 2 {
+			return nil, errors.New("The given line has no phones; `" + strings.TrimSpace(l) + "` at " + strconv.Itoa(i+1))
+		}
+		word := strings.ToLower(es[0])
+		if j := strings.Index(word, "("); j > 0 && strings.HasSuffix(word, ")") {
+			word = word[:j]
+		}
+		phones := []string{}
+		for _, e := range es[1:] {
+			p := strings.ToLower(strings.TrimRight(e, "012"))
+			if !isArpabetPhone(p) {
+				return nil, errors.New("The given phone is not ARPABET; `" + e + "` of `" + es[0] + "`")
+			}
+			phones = append(phones, p)
+		}
+		res[word] = append(res[word], phones)
+	}
+	return &res, nil
+}
+
+// Lookup returns the first pronunciation of the word, ignoring its case and the punctuation around it.
+func (d *Pronunciations) Lookup(word string) ([]string, bool) {
+	w := strings.ToLower(strings.TrimFunc(word, func(r rune) bool {
+		return unicode.IsPunct(r) && r != '\''
+	}))
+	ps, ok := (*d)[w]
+	if !ok || len(ps) == 0 {
+		return nil, false
+	}
+	return ps[0], true
+}
+
+// PronunciationsReader reads Pronunciations from filesystem.
+type PronunciationsReader interface {
+	Read(string) (*Pronunciations, error)
+}
+
+type pronunciationsReaderDefault struct {
+	fr fileReader
+}
+
+// NewPronunciationsReader creates a default PronunciationsReader that reads a dictionary from filesystem.
+func NewPronunciationsReader() PronunciationsReader {
+	return pronunciationsReaderDefault{fr: fileReaderDefault{}}
+}
+
+// Read Pronunciations from the dictionary file specified by filename.
+func (pr pronunciationsReaderDefault) Read(filename string) (*Pronunciations, error) {
+	t, err := pr.fr.Read(filename)
+	if err != nil {
+		return nil, err
+	}
+	return NewPronunciationsFromText(t)
+}
+
+// Syllabify splits ARPABET phones into syllables by the maximal onset principle;
+// consonants between vowels start the following syllable as many as they form an English onset.
+// Consonants before the first vowel and after the last one belong to the first and the last syllables.
+func Syllabify(phones []string) [][]string {
+	vowels := []int{}
+	for i, p := range phones {
+		if _, ok := arpabetVowels[p]; ok {
+			vowels = append(vowels, i)
+		}
+	}
+	if len(vowels) == 0 {
+		return [][]string{phones}
+	}
+	res := [][]string{}
+	start := 0
+	for k := 0; k+1 < len(vowels); k++ {
+		onset := vowels[k+1]
+		for j := vowels[k+1] - 1; j > vowels[k]; j-- {
+			c := strings.Join(phones[j:vowels[k+1]], " ")
+			if j == vowels[k+1]-1 && c != "ng" || englishOnsets[c] {
+				onset = j
+			}
+		}
+		res = append(res, phones[start:onset])
+		start = onset
+	}
+	return append(res, phones[start:])
+}
+
+// vccvSyllable returns the syllable in VCCV symbols, and the vowel of it.
+func vccvSyllable(s []string) (string, string) {
+	var b strings.Builder
+	vowel := ""
+	for _, p := range s {
+		if v, ok := arpabetVowels[p]; ok {
+			b.WriteString(v)
+			vowel = v
+			break
+		}
+		b.WriteString(arpabetConsonants[p])
+	}
+	return b.String(), vowel
+}
+
+func isVCCVVowel(s string) bool {
+	for _, v := range arpabetVowels {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func isVCCVConsonant(s string) bool {
+	for _, c := range arpabetConsonants {
+		if s == c {
+			return true
+		}
+	}
+	return false
+}
+
+// vccvConsonants splits the text into VCCV symbols of consonants, or returns false if it is not only consonants.
+func vccvConsonants(s string) ([]string, bool) {
+	res := []string{}
+	for s != "" {
+		found := false
+		for _, c := range arpabetConsonants {
+			if strings.HasPrefix(s, c) {
+				res = append(res, c)
+				s = s[len(c):]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return res, true
+}
+
+// isVCCVSyllable reports whether the alias is VCCV symbols of consonants followed by a vowel.
+func isVCCVSyllable(s string) bool {
+	for _, v := range arpabetVowels {
+		if !strings.HasSuffix(s, v) {
+			continue
+		}
+		if _, ok := vccvConsonants(strings.TrimSuffix(s, v)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isVCCVAlias reports whether the alias is one only VCCV banks have, which is not romaji such as `ka` or `a k`;
+// a syllable such as `k@`, a transition from a vowel into consonants such as `E l`,
+// an ending such as `E-` or `O -` with a vowel romaji lacks, or a cluster of consonants such as `st`.
+func isVCCVAlias(a string) bool {
+	es := strings.Fields(a)
+	if len(es) == 0 || isJapaneseAlias(a) {
+		return false
+	}
+	last := es[len(es)-1]
+	if len(es) == 2 && isVCCVVowel(es[0]) {
+		if last == "-" {
+			return !strings.Contains("aiueo", es[0])
+		}
+		cs, ok := vccvConsonants(last)
+		return ok && len(cs) > 0
+	}
+	if v := strings.TrimSuffix(last, "-"); v != last && isVCCVVowel(v) {
+		return true
+	}
+	if cs, ok := vccvConsonants(last); ok {
+		return len(es) == 1 && len(cs) > 1
+	}
+	return isVCCVSyllable(last)
+}
+
+// DetectEnglishConvention detects the convention of the English Voicebank from its aliases.
+// Aliases of two ARPABET phones count for ARPAsing, and the aliases isVCCVAlias tells count for VCCV,
+// so that romaji such as `ka` and `shi` is not taken as VCCV syllables.
+// It returns an empty string for a Voicebank of neither.
+func DetectEnglishConvention(vb *Voicebank) string {
+	arpasing, vccv := countEnglishConventions(voicebankAliases(vb))
+	switch {
+	case arpasing > 0 && arpasing >= vccv:
+		return EnglishConventionARPAsing
+	case vccv > 0:
+		return EnglishConventionVCCV
+	}
+	return ""
+}
+
+// countEnglishConventions counts the aliases of ARPAsing and the ones of VCCV.
+func countEnglishConventions(aliases []string) (int, int) {
+	arpasing, vccv := 0, 0
+	for _, a := range aliases {
+		es := strings.Fields(a)
+		if len(es) == 2 && isArpabetPhone(es[0]) && isArpabetPhone(es[1]) {
+			arpasing++
+			continue
+		}
+		if isVCCVAlias(a) {
+			vccv++
+		}
+	}
+	return arpasing, vccv
+}
+
+// EnglishConverter converts English words into aliases of a Voicebank.
+type EnglishConverter interface {
+	Convert(words []string, noteNum int) ([]string, error)
+}
+
+type englishConverterDefault struct {
+	vb         *Voicebank
+	dict       *Pronunciations
+	convention string
+}
+
+// NewEnglishConverter creates an EnglishConverter for the Voicebank of the convention DetectEnglishConvention tells.
+func NewEnglishConverter(vb *Voicebank, dict *Pronunciations) (EnglishConverter, error) {
+	c := DetectEnglishConvention(vb)
+	if c == "" {
+		return nil, errors.New("The given voicebank has neither ARPAsing nor VCCV aliases; `" + vb.Path + "`")
+	}
+	return NewEnglishConverterOfConvention(vb, dict, c), nil
+}
+
+// NewEnglishConverterOfConvention creates an EnglishConverter treating the Voicebank as the convention.
+func NewEnglishConverterOfConvention(vb *Voicebank, dict *Pronunciations, convention string) EnglishConverter {
+	return englishConverterDefault{vb: vb, dict: dict, convention: convention}
+}
+
+// Convert returns the aliases the Voicebank has for the words sung as a phrase at the note number.
+// ARPAsing banks take a pair for each adjacent phones such as `- hh`, `hh ah` and `ow -`,
+// falling back to the latter phone alone. VCCV banks take a syllable for each syllable such as `- hE` and `lO`,
+// and transitions from the vowels into the whole clusters of the following consonants such as `E l`, `E st`
+// and `O -`, falling back to the first consonant followed by pairs of consonants such as `E s` and `st`.
+// Aliases the Voicebank lacks are left out of the result and reported by the error, which comes with the result.
+func (ec englishConverterDefault) Convert(words []string, noteNum int) ([]string, error) {
+	phones := []string{}
+	syllables := [][]string{}
+	for _, w := range words {
+		ps, ok := ec.dict.Lookup(w)
+		if !ok {
+			return nil, errors.New("The given word is not in the dictionary; `" + w + "`")
+		}
+		phones = append(phones, ps...)
+		syllables = append(syllables, Syllabify(ps)...)
+	}
+	res := []string{}
+	missing := []string{}
+	has := func(alias string) bool {
+		_, _, ok := ec.vb.Lookup(alias, noteNum)
+		return ok
+	}
+	add := func(candidates ...string) {
+		for _, c := range candidates {
+			if has(c) {
+				res = append(res, c)
+				return
+			}
+		}
+		missing = append(missing, candidates[0])
+	}
+	switch ec.convention {
+	case EnglishConventionARPAsing:
+		phones = append(append([]string{"-"}, phones...), "-")
+		for i := 1; i < len(phones); i++ {
+			if phones[i] == "-" {
+				add(phones[i-1] + " -")
+				continue
+			}
+			add(phones[i-1]+" "+phones[i], phones[i])
+		}
+	case EnglishConventionVCCV:
+		for i, s := range syllables {
+			cv, v := vccvSyllable(s)
+			if i == 0 {
+				add("- "+cv, cv)
+			} else {
+				add(cv)
+			}
+			if v == "" {
+				continue
+			}
+			cluster := []string{}
+			for j, p := range s {
+				if _, ok := arpabetVowels[p]; ok {
+					for _, c := range s[j+1:] {
+						cluster = append(cluster, arpabetConsonants[c])
+					}
+					break
+				}
+			}
+			if len(cluster) == 0 && i+1 < len(syllables) {
+				for _, c := range syllables[i+1] {
+					if _, ok := arpabetVowels[c]; ok {
+						break
+					}
+					cluster = append(cluster, arpabetConsonants[c])
+				}
+				if len(cluster) == 0 {
+					continue
+				}
+			}
+			if len(cluster) == 0 {
+				add(v + " -")
+				continue
+			}
+			whole := v + " " + strings.Join(cluster, "")
+			pairs := []string{v + " " + cluster[0]}
+			for j := 1; j < len(cluster); j++ {
+				pairs = append(pairs, cluster[j-1]+cluster[j])
+			}
+			if has(whole) || len(cluster) == 1 {
+				add(whole)
+				continue
+			}
+			found := true
+			for _, a := range pairs {
+				found = found && has(a)
+			}
+			if !found {
+				add(whole)
+				continue
+			}
+			res = append(res, pairs...)
+		}
+	default:
+		return nil, errors.New("The given convention is unknown; `" + ec.convention + "`")
+	}
+	if len(missing) > 0 {
+		return res, errors.New("The given voicebank lacks aliases; `" + strings.Join(missing, "`, `") + "`")
+	}
+	return res, nil
+}
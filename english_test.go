@@ -0,0 +1,139 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testDictionary = `;;; a part of cmudict
+HELLO  HH AH0 L OW1
+HELLO(2)  HH EH0 L OW1
+CAT  K AE1 T
+EXTRA  EH1 K S T R AH0
+SINGING  S IH1 NG IH0 NG # comment
+`
+
+func TestNewPronunciationsFromText(t *testing.T) {
+	d, err := NewPronunciationsFromText(testDictionary)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, len(*d))
+	assert.Equal(t, [][]string{{"hh", "ah", "l", "ow"}, {"hh", "eh", "l", "ow"}}, (*d)["hello"])
+
+	ps, ok := d.Lookup("Hello,")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, []string{"hh", "ah", "l", "ow"}, ps)
+	_, ok = d.Lookup("dog")
+	assert.Equal(t, false, ok)
+
+	_, err = NewPronunciationsFromText("CAT K AE1 X")
+	assert.NotEqual(t, nil, err)
+	_, err = NewPronunciationsFromText("CAT")
+	assert.NotEqual(t, nil, err)
+}
+
+func TestPronunciationsReaderReads(t *testing.T) {
+	frm := &fileReaderMock{}
+	frm.On("Read", "cmudict.dict").Return(testDictionary, nil)
+	frm.On("Read", "none.dict").Return("", errors.New("not found"))
+	pr := pronunciationsReaderDefault{fr: frm}
+	d, err := pr.Read("cmudict.dict")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, len(*d))
+	_, err = pr.Read("none.dict")
+	assert.NotEqual(t, nil, err)
+}
+
+func TestSyllabify(t *testing.T) {
+	testCases := []struct {
+		phones string
+		res    string
+	}{
+		{"hh ah l ow", "hh ah|l ow"},
+		{"k ae t", "k ae t"},
+		{"eh k s t r ah", "eh k|s t r ah"},
+		{"s ih ng ih ng", "s ih ng|ih ng"},
+		{"hh m", "hh m"},
+	}
+	for i, tc := range testCases {
+		t.Logf("Test case %v.; %v", i+1, tc.phones)
+		ss := []string{}
+		for _, s := range Syllabify(strings.Fields(tc.phones)) {
+			ss = append(ss, strings.Join(s, " "))
+		}
+		assert.Equal(t, tc.res, strings.Join(ss, "|"))
+	}
+}
+
+var testARPAsingBank = newTestAliasVoicebank("- hh", "hh ah", "ah l", "l ow", "ow -", "ow k", "k ae", "ae t", "t -", "ae")
+var testVCCVBank = newTestAliasVoicebank("- hu", "u l", "lO", "O -", "O k", "k@", "@ t", "- k@")
+
+func TestDetectEnglishConvention(t *testing.T) {
+	assert.Equal(t, EnglishConventionARPAsing, DetectEnglishConvention(testARPAsingBank))
+	assert.Equal(t, EnglishConventionVCCV, DetectEnglishConvention(testVCCVBank))
+	assert.Equal(t, "", DetectEnglishConvention(testCVBank))
+	assert.Equal(t, "", DetectEnglishConvention(newTestAliasVoicebank("ka", "shi", "tsu", "- ka", "a k", "a -")), "Romaji should not be taken as VCCV.")
+	assert.Equal(t, EnglishConventionVCCV, DetectEnglishConvention(newTestAliasVoicebank("ka", "E-", "st")))
+}
+
+func TestEnglishConverterConverts(t *testing.T) {
+	d, err := NewPronunciationsFromText(testDictionary)
+	assert.Equal(t, nil, err)
+	testCases := []struct {
+		vb    *Voicebank
+		words string
+		res   string
+	}{
+		{testARPAsingBank, "hello", "- hh,hh ah,ah l,l ow,ow -"},
+		{testARPAsingBank, "hello cat", "- hh,hh ah,ah l,l ow,ow k,k ae,ae t,t -"},
+		{testARPAsingBank, "at", "ae,ae t,t -"},
+		{testVCCVBank, "hello", "- hu,u l,lO,O -"},
+		{testVCCVBank, "hello cat", "- hu,u l,lO,O k,k@,@ t"},
+		{testVCCVBank, "cat", "- k@,@ t"},
+	}
+	for i, tc := range testCases {
+		t.Logf("Test case %v.; %v", i+1, tc.words)
+		if tc.words == "at" {
+			(*d)["at"] = [][]string{{"ae", "t"}}
+		}
+		ec, err := NewEnglishConverter(tc.vb, d)
+		assert.Equal(t, nil, err)
+		res, err := ec.Convert(strings.Fields(tc.words), 60)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, tc.res, strings.Join(res, ","))
+	}
+
+	(*d)["best"] = [][]string{{"b", "eh", "s", "t"}}
+	clusterCases := []struct {
+		vb  *Voicebank
+		res string
+	}{
+		{newTestAliasVoicebank("- bE", "E st", "E s", "st", "k@"), "- bE,E st"},
+		{newTestAliasVoicebank("- bE", "E s", "st", "k@"), "- bE,E s,st"},
+	}
+	for i, tc := range clusterCases {
+		t.Logf("Test case %v.; the coda cluster of `best` should be kept.", i+1)
+		res, err := NewEnglishConverterOfConvention(tc.vb, d, EnglishConventionVCCV).Convert([]string{"best"}, 60)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, tc.res, strings.Join(res, ","))
+	}
+	res, err := NewEnglishConverterOfConvention(newTestAliasVoicebank("- bE", "E s"), d, EnglishConventionVCCV).Convert([]string{"best"}, 60)
+	assert.Equal(t, errors.New("The given voicebank lacks aliases; `E st`"), err)
+	assert.Equal(t, []string{"- bE"}, res)
+	_, err = NewEnglishConverterOfConvention(testARPAsingBank, d, EnglishConventionARPAsing).Convert([]string{"best"}, 60)
+	assert.Equal(t, errors.New("The given voicebank lacks aliases; `- b`, `b eh`, `eh s`, `s t`"), err)
+
+	ec, _ := NewEnglishConverter(testARPAsingBank, d)
+	_, err = ec.Convert([]string{"dog"}, 60)
+	assert.NotEqual(t, nil, err)
+	_, err = NewEnglishConverter(testCVBank, d)
+	assert.NotEqual(t, nil, err)
+	_, err = NewEnglishConverterOfConvention(testCVBank, d, "unknown").Convert([]string{"cat"}, 60)
+	assert.NotEqual(t, nil, err)
+}
@@ -0,0 +1,96 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Envelope represents the volume envelope of a note in UTAU.
+// P holds p1, p2, p3 and optionally p4 and p5 in milliseconds,
+// and V holds v1 to v4 and optionally v5 in percent.
+type Envelope struct {
+	P []float64 `json:"p"`
+	V []float64 `json:"v"`
+}
+
+// DefaultEnvelope returns the envelope UTAU gives to a new note.
+func DefaultEnvelope() *Envelope {
+	return &Envelope{P: []float64{0, 5, 35}, V: []float64{0, 100, 100, 0}}
+}
+
+// NewEnvelopeFromText creates Envelope from the value of `Envelope` in UST,
+// such as `0,5,35,0,100,100,0,%,0,10,100`.
+func NewEnvelopeFromText(t string) (*Envelope, error) {
+	es := strings.Split(t, ",")
+	if len(es) < 7 {
+		return nil, errors.New("The given envelope does not contain 7 elements; `" + t + "`")
+	}
+	vs := []float64{}
+	for i, e := range es {
+		if i == 7 {
+			// The 8th element is `%`, which is the place of the overlap on the command line of wavtool.
+			continue
+		}
+		if e == "" {
+			break
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(e), 64)
+		if err != nil {
+			return nil, err
+		}
+		vs = append(vs, v)
+	}
+	if len(vs) < 7 {
+		return nil, errors.New("The given envelope does not contain 7 numbers; `" + t + "`")
+	}
+	res := &Envelope{P: append([]float64{}, vs[0:3]...), V: append([]float64{}, vs[3:7]...)}
+	if len(vs) > 7 {
+		res.P = append(res.P, vs[7])
+	}
+	if len(vs) > 9 {
+		res.P = append(res.P, vs[8])
+		res.V = append(res.V, vs[9])
+	}
+	return res, nil
+}
+
+// Fields returns p1, p2, p3, v1, v2, v3, v4 followed by p4, p5 and v5 if they exist,
+// which is the order of the arguments of wavtool without the overlap.
+// Values missing in a short Envelope are taken from DefaultEnvelope.
+func (e *Envelope) Fields() []string {
+	res := []string{}
+	d := DefaultEnvelope()
+	for i, v := range d.P {
+		if i < len(e.P) {
+			v = e.P[i]
+		}
+		res = append(res, formatFloat(v))
+	}
+	for i, v := range d.V {
+		if i < len(e.V) {
+			v = e.V[i]
+		}
+		res = append(res, formatFloat(v))
+	}
+	if len(e.P) > 3 {
+		res = append(res, formatFloat(e.P[3]))
+	}
+	if len(e.P) > 4 && len(e.V) > 4 {
+		res = append(res, formatFloat(e.P[4]), formatFloat(e.V[4]))
+	}
+	return res
+}
+
+// String formats the Envelope as the value of `Envelope` in UST.
+func (e *Envelope) String() string {
+	fs := e.Fields()
+	if len(fs) > 7 {
+		fs = append(fs[:7], append([]string{"%"}, fs[7:]...)...)
+	}
+	return strings.Join(fs, ",")
+}
@@ -0,0 +1,49 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuccessfulCasesOfNewEnvelopeFromText(t *testing.T) {
+	type TestCase struct {
+		input    string
+		expected *Envelope
+	}
+	for i, tc := range []TestCase{
+		{"0,5,35,0,100,100,0", DefaultEnvelope()},
+		{"0,5,35,0,100,100,0,%,10", &Envelope{P: []float64{0, 5, 35, 10}, V: []float64{0, 100, 100, 0}}},
+		{"0,5,35,0,100,100,0,%,10,20,50", &Envelope{P: []float64{0, 5, 35, 10, 20}, V: []float64{0, 100, 100, 0, 50}}},
+	} {
+		t.Logf("Test case %v.; `%v` should be interpreted as `%v`.", i+1, tc.input, tc.expected)
+		actual, err := NewEnvelopeFromText(tc.input)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, tc.expected, actual)
+		assert.Equal(t, tc.input, actual.String())
+	}
+}
+
+func TestFailedCasesOfNewEnvelopeFromText(t *testing.T) {
+	for i, tc := range []string{
+		"0,5,35,0,100,100",
+		"0,5,35,0,100,100,x",
+		"",
+	} {
+		t.Logf("Test case %v.; `%v` cannot be interpreted as Envelope.", i+1, tc)
+		_, err := NewEnvelopeFromText(tc)
+		assert.Error(t, err)
+	}
+}
+
+func TestEnvelopeFields(t *testing.T) {
+	e := &Envelope{P: []float64{0, 5, 35, 10, 20}, V: []float64{0, 100, 100, 0, 50}}
+	assert.Equal(t, []string{"0", "5", "35", "0", "100", "100", "0", "10", "20", "50"}, e.Fields())
+	e = &Envelope{P: []float64{10}, V: []float64{}}
+	assert.Equal(t, []string{"10", "5", "35", "0", "100", "100", "0"}, e.Fields(), "Missing values should be taken from DefaultEnvelope.")
+	assert.Equal(t, "0,5,35,0,100,100,0", (&Envelope{}).String())
+}
@@ -0,0 +1,269 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Flag is a single resampler flag such as `g-5`.
+// Switches such as `N` have no value.
+type Flag struct {
+	Name     string  `json:"name"`
+	Value    float64 `json:"value"`
+	HasValue bool    `json:"has_value"`
+}
+
+// String formats the Flag as it appears in flags.
+func (f *Flag) String() string {
+	if !f.HasValue {
+		return f.Name
+	}
+	return f.Name + formatFloat(f.Value)
+}
+
+// Flags is the ordered list of flags, which is how UTAU passes them to resamplers.
+type Flags []*Flag
+
+func isFlagLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isFlagDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || c == '.'
+}
+
+// ParseFlags parses flags such as `g-5B40Y80H0Mt20` into the ordered list of flags.
+// A name is a run of letters and the value is the signed number following it.
+// Use FlagRegistry.Parse to separate switches put right before another flag such as `NB40`.
+func ParseFlags(s string) (Flags, error) {
+	return parseFlags(s, nil)
+}
+
+func parseFlags(s string, names []string) (Flags, error) {
+	res := Flags{}
+	t := strings.Join(strings.Fields(s), "")
+	for i := 0; i < len(t); {
+		if !isFlagLetter(t[i]) {
+			return nil, errors.New("The given flags have a value without a name; `" + s + "`")
+		}
+		j := i + len(knownFlagPrefix(t[i:], names))
+		if j == i {
+			j++
+			for j < len(t) && isFlagLetter(t[j]) && knownFlagPrefix(t[j:], names) == "" {
+				j++
+			}
+		}
+		f := &Flag{Name: t[i:j]}
+		k := j
+		if k < len(t) && (t[k] == '-' || t[k] == '+') {
+			k++
+		}
+		for k < len(t) && isFlagDigit(t[k]) {
+			k++
+		}
+		if k > j {
+			v, err := strconv.ParseFloat(t[j:k], 64)
+			if err != nil {
+				return nil, errors.New("The given flags have an invalid value of `" + f.Name + "`; `" + s + "`")
+			}
+			f.Value, f.HasValue = v, true
+		}
+		res = append(res, f)
+		i = k
+	}
+	return res, nil
+}
+
+func knownFlagPrefix(s string, names []string) string {
+	for _, n := range names {
+		if strings.HasPrefix(s, n) {
+			return n
+		}
+	}
+	return ""
+}
+
+// String formats the Flags as a flags string.
+func (fs Flags) String() string {
+	var b strings.Builder
+	for _, f := range fs {
+		b.WriteString(f.String())
+	}
+	return b.String()
+}
+
+// Get returns the last Flag of the name, which is the one resamplers take.
+func (fs Flags) Get(name string) (*Flag, bool) {
+	for i := len(fs) - 1; i >= 0; i-- {
+		if fs[i].Name == name {
+			return fs[i], true
+		}
+	}
+	return nil, false
+}
+
+// Value returns the value of the flag, or def if the flag is absent or has no value.
+func (fs Flags) Value(name string, def float64) float64 {
+	if f, ok := fs.Get(name); ok && f.HasValue {
+		return f.Value
+	}
+	return def
+}
+
+// Has reports whether the flag is given.
+func (fs Flags) Has(name string) bool {
+	_, ok := fs.Get(name)
+	return ok
+}
+
+// MergeFlags merges flags of a note into flags of the project.
+// A flag of the note replaces the flag of the same name in place, and the other flags of the note follow the project flags.
+func MergeFlags(project Flags, note Flags) Flags {
+	res := Flags{}
+	index := map[string]int{}
+	for _, fs := range []Flags{project, note} {
+		for _, f := range fs {
+			c := *f
+			if i, ok := index[f.Name]; ok {
+				res[i] = &c
+				continue
+			}
+			index[f.Name] = len(res)
+			res = append(res, &c)
+		}
+	}
+	return res
+}
+
+// FlagSpec describes a flag a resampler understands.
+// Min, Max and Default are meaningful only when the flag takes a value.
+type FlagSpec struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	HasValue    bool    `json:"has_value"`
+	Min         float64 `json:"min"`
+	Max         float64 `json:"max"`
+	Default     float64 `json:"default"`
+}
+
+// FlagRegistry is the set of flags a resampler understands.
+type FlagRegistry struct {
+	Resampler string      `json:"resampler"`
+	Specs     []*FlagSpec `json:"specs"`
+}
+
+// FlagIssue is a problem of a flag found by FlagRegistry.Validate.
+type FlagIssue struct {
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+// Lookup returns the FlagSpec of the name.
+func (r *FlagRegistry) Lookup(name string) (*FlagSpec, bool) {
+	for _, s := range r.Specs {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// Parse parses flags preferring the longest known name,
+// so that `NeB40` is read as `N`, `e` and `B40` when `N` and `e` are switches of the resampler.
+// An unknown name ends where a known name starts.
+func (r *FlagRegistry) Parse(s string) (Flags, error) {
+	names := make([]string, len(r.Specs))
+	for i, spec := range r.Specs {
+		names[i] = spec.Name
+	}
+	sort.SliceStable(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	return parseFlags(s, names)
+}
+
+// Value returns the value of the flag, or the default of the resampler if it is absent.
+func (r *FlagRegistry) Value(fs Flags, name string) float64 {
+	def := 0.0
+	if s, ok := r.Lookup(name); ok {
+		def = s.Default
+	}
+	return fs.Value(name, def)
+}
+
+// Validate checks the flags against the registry.
+// It reports unknown flags, missing or unexpected values, values out of range and duplicated flags.
+func (r *FlagRegistry) Validate(fs Flags) []*FlagIssue {
+	res := []*FlagIssue{}
+	seen := map[string]bool{}
+	for _, f := range fs {
+		if seen[f.Name] {
+			res = append(res, &FlagIssue{Name: f.Name, Message: "The flag `" + f.Name + "` is given more than once"})
+		}
+		seen[f.Name] = true
+		s, ok := r.Lookup(f.Name)
+		switch {
+		case !ok:
+			res = append(res, &FlagIssue{Name: f.Name, Message: "The flag `" + f.Name + "` is unknown to " + r.Resampler})
+		case s.HasValue && !f.HasValue:
+			res = append(res, &FlagIssue{Name: f.Name, Message: "The flag `" + f.Name + "` needs a value"})
+		case !s.HasValue && f.HasValue:
+			res = append(res, &FlagIssue{Name: f.Name, Message: "The flag `" + f.Name + "` takes no value"})
+		case s.HasValue && (f.Value < s.Min || f.Value > s.Max):
+			res = append(res, &FlagIssue{Name: f.Name, Message: "The value of the flag `" + f.Name + "` is out of the range from " + formatFloat(s.Min) + " to " + formatFloat(s.Max)})
+		}
+	}
+	return res
+}
+
+// FlagRegistryPSOLA is the flags of the resampler created by NewPSOLAResampler.
+var FlagRegistryPSOLA = &FlagRegistry{
+	Resampler: "psola",
+	Specs: []*FlagSpec{
+		{Name: "g", Description: "Gender; shifts formants down for positive values", HasValue: true, Min: -100, Max: 100, Default: 0},
+		{Name: "B", Description: "Breathiness; adds noise over 50 and smooths under 50", HasValue: true, Min: 0, Max: 100, Default: 50},
+		{Name: "Y", Description: "Volume in percent", HasValue: true, Min: 0, Max: 200, Default: 100},
+	},
+}
+
+// FlagRegistries is the known flags of resamplers, keyed by the names used for cache files.
+var FlagRegistries = map[string]*FlagRegistry{
+	CacheResamplerUTAU: {
+		Resampler: CacheResamplerUTAU,
+		Specs: []*FlagSpec{
+			{Name: "g", Description: "Gender; shifts formants", HasValue: true, Min: -100, Max: 100, Default: 0},
+			{Name: "t", Description: "Pitch offset in cents", HasValue: true, Min: -100, Max: 100, Default: 0},
+			{Name: "B", Description: "Breathiness", HasValue: true, Min: 0, Max: 100, Default: 50},
+			{Name: "P", Description: "Peak compressor", HasValue: true, Min: 0, Max: 100, Default: 86},
+			{Name: "N", Description: "Ignores the frequency table"},
+			{Name: "e", Description: "Stretches the sample instead of looping it"},
+		},
+	},
+	CacheResamplerTnFnds: {
+		Resampler: CacheResamplerTnFnds,
+		Specs: []*FlagSpec{
+			{Name: "g", Description: "Gender; shifts formants", HasValue: true, Min: -100, Max: 100, Default: 0},
+			{Name: "t", Description: "Pitch offset in cents", HasValue: true, Min: -100, Max: 100, Default: 0},
+			{Name: "B", Description: "Breathiness", HasValue: true, Min: 0, Max: 100, Default: 50},
+			{Name: "P", Description: "Peak compressor", HasValue: true, Min: 0, Max: 100, Default: 86},
+			{Name: "Y", Description: "Volume in percent", HasValue: true, Min: 0, Max: 200, Default: 100},
+		},
+	},
+	CacheResamplerMoresampler: {
+		Resampler: CacheResamplerMoresampler,
+		Specs: []*FlagSpec{
+			{Name: "g", Description: "Gender; shifts formants", HasValue: true, Min: -100, Max: 100, Default: 0},
+			{Name: "t", Description: "Pitch offset in cents", HasValue: true, Min: -100, Max: 100, Default: 0},
+			{Name: "Mt", Description: "Tension", HasValue: true, Min: -100, Max: 100, Default: 0},
+			{Name: "Mb", Description: "Breathiness", HasValue: true, Min: -100, Max: 100, Default: 0},
+			{Name: "Mo", Description: "Opening of the mouth", HasValue: true, Min: -100, Max: 100, Default: 0},
+			{Name: "MG", Description: "Growl", HasValue: true, Min: 0, Max: 100, Default: 0},
+			{Name: "Me", Description: "Stretches the sample instead of looping it"},
+		},
+	},
+	"psola": FlagRegistryPSOLA,
+}
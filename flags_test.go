@@ -0,0 +1,128 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFlags(t *testing.T) {
+	testCases := []struct {
+		s   string
+		res Flags
+	}{
+		{"", Flags{}},
+		{"g-5B40Y80H0Mt20", Flags{
+			{Name: "g", Value: -5, HasValue: true},
+			{Name: "B", Value: 40, HasValue: true},
+			{Name: "Y", Value: 80, HasValue: true},
+			{Name: "H", Value: 0, HasValue: true},
+			{Name: "Mt", Value: 20, HasValue: true},
+		}},
+		{"N g+3.5 e", Flags{
+			{Name: "Ng", Value: 3.5, HasValue: true},
+			{Name: "e"},
+		}},
+	}
+	for i, tc := range testCases {
+		t.Logf("Test case %v.; `%v`", i+1, tc.s)
+		res, err := ParseFlags(tc.s)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, tc.res, res)
+	}
+	for _, s := range []string{"5g", "g1.2.3", "g-"} {
+		_, err := ParseFlags(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestFlagsString(t *testing.T) {
+	fs, _ := ParseFlags("g-5B40.5Ne")
+	assert.Equal(t, "g-5B40.5Ne", fs.String())
+	fs, _ = ParseFlags("g+5 B040")
+	assert.Equal(t, "g5B40", fs.String())
+}
+
+func TestFlagsValue(t *testing.T) {
+	fs, _ := ParseFlags("g-5B40g3N")
+	f, ok := fs.Get("g")
+	assert.True(t, ok)
+	assert.Equal(t, 3.0, f.Value, "The last flag of the name should be taken.")
+	assert.Equal(t, 40.0, fs.Value("B", 50))
+	assert.Equal(t, 100.0, fs.Value("Y", 100))
+	assert.Equal(t, 7.0, fs.Value("N", 7))
+	assert.True(t, fs.Has("N"))
+	assert.False(t, fs.Has("Y"))
+}
+
+func TestMergeFlags(t *testing.T) {
+	testCases := []struct {
+		project string
+		note    string
+		res     string
+	}{
+		{"g-5B40", "", "g-5B40"},
+		{"", "Y80", "Y80"},
+		{"g-5B40", "B60Y80", "g-5B60Y80"},
+		{"g-5e", "g10", "g10e"},
+	}
+	for i, tc := range testCases {
+		t.Logf("Test case %v.; `%v` and `%v`", i+1, tc.project, tc.note)
+		p, _ := ParseFlags(tc.project)
+		n, _ := ParseFlags(tc.note)
+		assert.Equal(t, tc.res, MergeFlags(p, n).String())
+	}
+	p, _ := ParseFlags("g-5")
+	n, _ := ParseFlags("g10")
+	MergeFlags(p, n)
+	assert.Equal(t, -5.0, p[0].Value, "MergeFlags should not modify the given flags.")
+}
+
+func TestFlagRegistryParse(t *testing.T) {
+	r := FlagRegistries[CacheResamplerUTAU]
+	fs, err := r.Parse("NeB40P86")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "N,e,B,P", names(fs))
+	fs, err = FlagRegistries[CacheResamplerMoresampler].Parse("MtMe5g-5")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "Mt,Me,g", names(fs))
+	assert.Equal(t, 5.0, fs[1].Value)
+	fs, _ = r.Parse("Xy10")
+	assert.Equal(t, "Xy", names(fs), "Unknown names should be read as a run of letters.")
+}
+
+func names(fs Flags) string {
+	res := ""
+	for i, f := range fs {
+		if i > 0 {
+			res += ","
+		}
+		res += f.Name
+	}
+	return res
+}
+
+func TestFlagRegistryValue(t *testing.T) {
+	fs, _ := ParseFlags("g-5")
+	assert.Equal(t, -5.0, FlagRegistryPSOLA.Value(fs, "g"))
+	assert.Equal(t, 50.0, FlagRegistryPSOLA.Value(fs, "B"))
+	assert.Equal(t, 0.0, FlagRegistryPSOLA.Value(fs, "Z"))
+}
+
+func TestFlagRegistryValidate(t *testing.T) {
+	r := FlagRegistries[CacheResamplerUTAU]
+	fs, _ := r.Parse("g-5B40Ne")
+	assert.Equal(t, []*FlagIssue{}, r.Validate(fs))
+	fs, _ = r.Parse("g-500BN3Zg1")
+	assert.Equal(t, []*FlagIssue{
+		{Name: "g", Message: "The value of the flag `g` is out of the range from -100 to 100"},
+		{Name: "B", Message: "The flag `B` needs a value"},
+		{Name: "N", Message: "The flag `N` takes no value"},
+		{Name: "Z", Message: "The flag `Z` is unknown to utau"},
+		{Name: "g", Message: "The flag `g` is given more than once"},
+	}, r.Validate(fs))
+}
@@ -0,0 +1,154 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+)
+
+const frqHeader = "FREQ0003"
+
+// DefaultFrqSamplesPerFrame is the hop size UTAU uses for .frq files.
+const DefaultFrqSamplesPerFrame = 256
+
+// FrequencyMap represents a .frq file that caches the fundamental frequency of a sample.
+type FrequencyMap struct {
+	SamplesPerFrame int       `json:"samples_per_frame"`
+	Average         float64   `json:"average"`
+	Frequencies     []float64 `json:"frequencies"`
+	Amplitudes      []float64 `json:"amplitudes"`
+}
+
+// NewFrequencyMapFromBytes decodes a .frq file.
+func NewFrequencyMapFromBytes(bs []byte) (*FrequencyMap, error) {
+	if len(bs) < 40 || string(bs[:8]) != frqHeader {
+		return nil, errors.New("The given bytes are not a frq file")
+	}
+	f := &FrequencyMap{
+		SamplesPerFrame: int(binary.LittleEndian.Uint32(bs[8:])),
+		Average:         math.Float64frombits(binary.LittleEndian.Uint64(bs[12:])),
+	}
+	n := int(binary.LittleEndian.Uint32(bs[36:]))
+	if n < 0 || len(bs) < 40+n*16 {
+		return nil, errors.New("The given frq file is truncated")
+	}
+	f.Frequencies = make([]float64, n)
+	f.Amplitudes = make([]float64, n)
+	for i := 0; i < n; i++ {
+		f.Frequencies[i] = math.Float64frombits(binary.LittleEndian.Uint64(bs[40+i*16:]))
+		f.Amplitudes[i] = math.Float64frombits(binary.LittleEndian.Uint64(bs[48+i*16:]))
+	}
+	return f, nil
+}
+
+// Bytes encodes the FrequencyMap as a .frq file.
+func (f *FrequencyMap) Bytes() []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, 40+len(f.Frequencies)*16))
+	buf.WriteString(frqHeader)
+	binary.Write(buf, binary.LittleEndian, uint32(f.SamplesPerFrame))
+	binary.Write(buf, binary.LittleEndian, f.Average)
+	buf.Write(make([]byte, 16))
+	binary.Write(buf, binary.LittleEndian, uint32(len(f.Frequencies)))
+	for i := range f.Frequencies {
+		binary.Write(buf, binary.LittleEndian, f.Frequencies[i])
+		binary.Write(buf, binary.LittleEndian, f.Amplitudes[i])
+	}
+	return buf.Bytes()
+}
+
+// AnalyzeFrequencyMap estimates the fundamental frequency of the Wave every samplesPerFrame samples.
+// Unvoiced frames have the frequency of zero.
+func AnalyzeFrequencyMap(w *Wave, samplesPerFrame int) *FrequencyMap {
+	s := w.Mono()
+	size := nextPowerOfTwo(w.SampleRate / 40)
+	f := &FrequencyMap{SamplesPerFrame: samplesPerFrame}
+	sum, voiced := 0.0, 0
+	for center := 0; center < len(s); center += samplesPerFrame {
+		from := clampInt(center-size/2, 0, len(s))
+		to := clampInt(center+size/2, 0, len(s))
+		frame := s[from:to]
+		freq := estimatePitch(frame, w.SampleRate)
+		amp := 0.0
+		for _, v := range frame {
+			amp += math.Abs(v)
+		}
+		if len(frame) > 0 {
+			amp = amp / float64(len(frame)) * 32768
+		}
+		f.Frequencies = append(f.Frequencies, freq)
+		f.Amplitudes = append(f.Amplitudes, amp)
+		if freq > 0 {
+			sum += freq
+			voiced++
+		}
+	}
+	if voiced > 0 {
+		f.Average = sum / float64(voiced)
+	}
+	return f
+}
+
+// estimatePitch estimates the fundamental frequency of a frame by YIN.
+// It returns zero when the frame is not periodic.
+func estimatePitch(frame []float64, rate int) float64 {
+	const threshold = 0.15
+	minLag := rate / 1000
+	maxLag := rate / 60
+	if len(frame) < maxLag*2 || minLag < 2 {
+		return 0
+	}
+	energy := 0.0
+	for _, v := range frame {
+		energy += v * v
+	}
+	if energy/float64(len(frame)) < 1e-7 {
+		return 0
+	}
+	n := len(frame) - maxLag
+	d := make([]float64, maxLag+1)
+	for lag := 1; lag <= maxLag; lag++ {
+		for i := 0; i < n; i++ {
+			diff := frame[i] - frame[i+lag]
+			d[lag] += diff * diff
+		}
+	}
+	sum := 0.0
+	for lag := 1; lag <= maxLag; lag++ {
+		sum += d[lag]
+		if sum == 0 {
+			d[lag] = 1
+			continue
+		}
+		d[lag] *= float64(lag) / sum
+	}
+	for lag := minLag; lag < maxLag; lag++ {
+		if d[lag] >= threshold {
+			continue
+		}
+		for lag+1 < maxLag && d[lag+1] < d[lag] {
+			lag++
+		}
+		// Refine the lag by parabolic interpolation.
+		l := float64(lag)
+		a, b, c := d[lag-1], d[lag], d[lag+1]
+		if den := a - 2*b + c; den != 0 {
+			l += (a - c) / (2 * den)
+		}
+		return float64(rate) / l
+	}
+	return 0
+}
+
+// FrqPath returns the path of the .frq file UTAU makes for the sample.
+func FrqPath(samplePath string) string {
+	if strings.HasSuffix(strings.ToLower(samplePath), ".wav") {
+		return samplePath[:len(samplePath)-4] + "_wav.frq"
+	}
+	return samplePath + ".frq"
+}
@@ -0,0 +1,46 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyzeFrequencyMapEstimatesPitch(t *testing.T) {
+	f := AnalyzeFrequencyMap(newTestWave(), DefaultFrqSamplesPerFrame)
+	assert.Equal(t, DefaultFrqSamplesPerFrame, f.SamplesPerFrame)
+	assert.Equal(t, len(f.Frequencies), len(f.Amplitudes))
+	assert.InDelta(t, 220, f.Frequencies[44100*300/1000/DefaultFrqSamplesPerFrame], 1)
+	assert.Equal(t, 0.0, f.Frequencies[10])
+	assert.InDelta(t, 220, f.Average, 5)
+}
+
+func TestFrequencyMapRoundTrip(t *testing.T) {
+	expected := &FrequencyMap{SamplesPerFrame: 256, Average: 220.5, Frequencies: []float64{0, 220, 221}, Amplitudes: []float64{0, 1000, 2000}}
+	actual, err := NewFrequencyMapFromBytes(expected.Bytes())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, expected, actual)
+	assert.Equal(t, 40+3*16, len(expected.Bytes()))
+}
+
+func TestFailedCasesOfNewFrequencyMapFromBytes(t *testing.T) {
+	for i, tc := range [][]byte{
+		[]byte("This is not a frq file at all, isn't it?!"),
+		append([]byte("FREQ0003"), make([]byte, 28)...),
+		append(append([]byte("FREQ0003"), make([]byte, 28)...), 1, 0, 0, 0),
+	} {
+		t.Logf("Test case %v.; `%v` cannot be interpreted as FrequencyMap.", i+1, tc)
+		_, err := NewFrequencyMapFromBytes(tc)
+		assert.Error(t, err)
+	}
+}
+
+func TestFrqPath(t *testing.T) {
+	assert.Equal(t, "C4/_ああ_wav.frq", FrqPath("C4/_ああ.wav"))
+	assert.Equal(t, "C4/_ああ_wav.frq", FrqPath("C4/_ああ.WAV"))
+	assert.Equal(t, "C4/noext.frq", FrqPath("C4/noext"))
+}
@@ -0,0 +1,324 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+)
+
+// PhonemeImageOptions configures how a Phoneme is rendered into an image.
+type PhonemeImageOptions struct {
+	Width       int  `json:"width"`
+	Height      int  `json:"height"`
+	Waveform    bool `json:"waveform"`
+	Spectrogram bool `json:"spectrogram"`
+	// FFTSize is the number of samples of a single spectrogram frame.
+	FFTSize int `json:"fft_size"`
+	// MaxFrequency is the highest frequency in Hz shown on the spectrogram.
+	MaxFrequency float64 `json:"max_frequency"`
+	// ThumbnailWidth and ThumbnailHeight is the size of a single cell on contact sheets.
+	ThumbnailWidth  int `json:"thumbnail_width"`
+	ThumbnailHeight int `json:"thumbnail_height"`
+	// Columns is the number of cells in a row of contact sheets.
+	Columns int `json:"columns"`
+}
+
+// DefaultPhonemeImageOptions returns PhonemeImageOptions that render both waveform and spectrogram.
+func DefaultPhonemeImageOptions() PhonemeImageOptions {
+	return PhonemeImageOptions{
+		Width:           800,
+		Height:          300,
+		Waveform:        true,
+		Spectrogram:     true,
+		FFTSize:         1024,
+		MaxFrequency:    8000,
+		ThumbnailWidth:  200,
+		ThumbnailHeight: 75,
+		Columns:         5,
+	}
+}
+
+var (
+	imageBackground     = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	imageWaveform       = color.RGBA{0x20, 0x20, 0x20, 0xff}
+	imageLeftBlank      = color.RGBA{0x40, 0x60, 0xc0, 0x50}
+	imageConsonant      = color.RGBA{0xe0, 0x60, 0xa0, 0x50}
+	imageRightBlank     = color.RGBA{0x40, 0x60, 0xc0, 0x50}
+	imagePreUtterance   = color.RGBA{0xe0, 0x20, 0x20, 0xff}
+	imageOverlap        = color.RGBA{0x20, 0xa0, 0x20, 0xff}
+	imageSpectrogramMin = -90.0
+)
+
+// RenderPhonemeImage renders the whole Wave with the regions of the Phoneme.
+// LeftBlank, Consonant and RightBlank are filled with translucent colours,
+// and PreUtterance and Overlap are drawn as vertical lines.
+func RenderPhonemeImage(p *Phoneme, w *Wave, o PhonemeImageOptions) (*image.RGBA, error) {
+	if o.Width <= 0 || o.Height <= 0 {
+		return nil, errors.New("The size of the image must be positive")
+	}
+	if !o.Waveform && !o.Spectrogram {
+		return nil, errors.New("Either waveform or spectrogram must be rendered")
+	}
+	img := image.NewRGBA(image.Rect(0, 0, o.Width, o.Height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{imageBackground}, image.Point{}, draw.Src)
+	s := w.Mono()
+	if o.Waveform && o.Spectrogram {
+		renderSpectrogram(img, image.Rect(0, o.Height/2, o.Width, o.Height), s, w.SampleRate, o)
+		renderWaveform(img, image.Rect(0, 0, o.Width, o.Height/2), s)
+	} else if o.Spectrogram {
+		renderSpectrogram(img, img.Bounds(), s, w.SampleRate, o)
+	} else {
+		renderWaveform(img, img.Bounds(), s)
+	}
+
+	duration := w.Duration()
+	x := func(ms float64) int {
+		if duration <= 0 {
+			return 0
+		}
+		return clampInt(int(math.Round(ms/duration*float64(o.Width))), 0, o.Width)
+	}
+	start, end := p.Region(duration)
+	fillColumns(img, x(0), x(start), 0, o.Height, imageLeftBlank)
+	fillColumns(img, x(start), x(start+p.Consonant), 0, o.Height, imageConsonant)
+	fillColumns(img, x(end), o.Width, 0, o.Height, imageRightBlank)
+	drawVerticalLine(img, x(start+p.Overlap), 0, o.Height, imageOverlap)
+	drawVerticalLine(img, x(start+p.PreUtterance), 0, o.Height, imagePreUtterance)
+	return img, nil
+}
+
+// WritePhonemePNG renders the Phoneme by RenderPhonemeImage and encodes it as PNG.
+func WritePhonemePNG(wr io.Writer, p *Phoneme, w *Wave, o PhonemeImageOptions) error {
+	img, err := RenderPhonemeImage(p, w, o)
+	if err != nil {
+		return err
+	}
+	return png.Encode(wr, img)
+}
+
+func renderWaveform(img *image.RGBA, r image.Rectangle, s []float64) {
+	mid := (r.Min.Y + r.Max.Y) / 2
+	half := float64(r.Dy()) / 2
+	for x := r.Min.X; x < r.Max.X; x++ {
+		from := len(s) * (x - r.Min.X) / r.Dx()
+		to := len(s) * (x - r.Min.X + 1) / r.Dx()
+		if to <= from {
+			to = from + 1
+		}
+		lo, hi := 0.0, 0.0
+		for i := from; i < to && i < len(s); i++ {
+			lo = math.Min(lo, s[i])
+			hi = math.Max(hi, s[i])
+		}
+		y0 := clampInt(mid-int(hi*half), r.Min.Y, r.Max.Y-1)
+		y1 := clampInt(mid-int(lo*half), r.Min.Y, r.Max.Y-1)
+		for y := y0; y <= y1; y++ {
+			img.SetRGBA(x, y, imageWaveform)
+		}
+	}
+}
+
+func renderSpectrogram(img *image.RGBA, r image.Rectangle, s []float64, rate int, o PhonemeImageOptions) {
+	size := nextPowerOfTwo(o.FFTSize)
+	if size < 2 {
+		size = 2
+	}
+	window := hann(size)
+	maxBin := size / 2
+	if o.MaxFrequency > 0 && rate > 0 {
+		maxBin = clampInt(int(o.MaxFrequency*float64(size)/float64(rate)), 1, size/2)
+	}
+	for x := r.Min.X; x < r.Max.X; x++ {
+		center := len(s) * (2*(x-r.Min.X) + 1) / (2 * r.Dx())
+		spec := magnitudeSpectrum(s, center, size, window)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			bin := (r.Max.Y - 1 - y) * maxBin / r.Dy()
+			db := 20 * math.Log10(spec[bin]*2/float64(size)+1e-12)
+			v := clampInt(int(255*(db-imageSpectrogramMin)/-imageSpectrogramMin), 0, 255)
+			img.SetRGBA(x, y, heatColor(uint8(v)))
+		}
+	}
+}
+
+func heatColor(v uint8) color.RGBA {
+	switch {
+	case v < 85:
+		return color.RGBA{0, 0, v * 3, 0xff}
+	case v < 170:
+		return color.RGBA{(v - 85) * 3, 0, 0xff - (v-85)*3, 0xff}
+	default:
+		return color.RGBA{0xff, (v - 170) * 3, 0, 0xff}
+	}
+}
+
+func fillColumns(img *image.RGBA, x0 int, x1 int, y0 int, y1 int, c color.RGBA) {
+	if x1 <= x0 {
+		return
+	}
+	draw.Draw(img, image.Rect(x0, y0, x1, y1), &image.Uniform{c}, image.Point{}, draw.Over)
+}
+
+func drawVerticalLine(img *image.RGBA, x int, y0 int, y1 int, c color.RGBA) {
+	x = clampInt(x, 0, img.Bounds().Dx()-1)
+	for y := y0; y < y1; y++ {
+		img.SetRGBA(x, y, c)
+	}
+}
+
+// NewContactSheet arranges the images into a grid, shrinking each into a cell of the given size.
+func NewContactSheet(imgs []image.Image, columns int, cellWidth int, cellHeight int) *image.RGBA {
+	if columns <= 0 {
+		columns = 1
+	}
+	rows := (len(imgs) + columns - 1) / columns
+	sheet := image.NewRGBA(image.Rect(0, 0, columns*cellWidth, rows*cellHeight))
+	draw.Draw(sheet, sheet.Bounds(), &image.Uniform{imageBackground}, image.Point{}, draw.Src)
+	for i, img := range imgs {
+		ox, oy := (i%columns)*cellWidth, (i/columns)*cellHeight
+		drawScaled(sheet, image.Rect(ox, oy, ox+cellWidth, oy+cellHeight), img)
+	}
+	return sheet
+}
+
+// newThumbnail shrinks img into an image of the given size.
+func newThumbnail(img image.Image, width int, height int) *image.RGBA {
+	res := image.NewRGBA(image.Rect(0, 0, width, height))
+	drawScaled(res, res.Bounds(), img)
+	return res
+}
+
+// drawScaled draws img onto r of dst with nearest neighbour sampling.
+func drawScaled(dst *image.RGBA, r image.Rectangle, img image.Image) {
+	b := img.Bounds()
+	for y := 0; y < r.Dy(); y++ {
+		for x := 0; x < r.Dx(); x++ {
+			sx := b.Min.X + x*b.Dx()/r.Dx()
+			sy := b.Min.Y + y*b.Dy()/r.Dy()
+			dst.Set(r.Min.X+x, r.Min.Y+y, img.At(sx, sy))
+		}
+	}
+}
+
+// GalleryEntry is a single rendered Phoneme on a gallery.
+type GalleryEntry struct {
+	Subfolder string   `json:"subfolder"`
+	Phoneme   *Phoneme `json:"phoneme"`
+	Image     string   `json:"image"`
+}
+
+// GalleryRenderer renders all Phonemes of a Voicebank into a directory
+// as PNG files, a contact sheet and an HTML gallery.
+type GalleryRenderer interface {
+	Render(vb *Voicebank, dir string) ([]*GalleryEntry, error)
+}
+
+type galleryRendererDefault struct {
+	wr WaveReader
+	fw fileWriter
+	o  PhonemeImageOptions
+}
+
+// NewGalleryRenderer creates a GalleryRenderer that reads samples from and writes images to filesystem.
+func NewGalleryRenderer(o PhonemeImageOptions) GalleryRenderer {
+	return galleryRendererDefault{wr: NewWaveReader(), fw: fileWriterDefault{}, o: o}
+}
+
+// Render writes one PNG per Phoneme, `contact.png` and `index.html` into dir.
+// Phonemes whose samples cannot be read are skipped.
+// Only the thumbnails for the contact sheet are kept in memory while rendering.
+func (gr galleryRendererDefault) Render(vb *Voicebank, dir string) ([]*GalleryEntry, error) {
+	res := []*GalleryEntry{}
+	thumbs := []image.Image{}
+	thumbnail := gr.o.ThumbnailWidth > 0 && gr.o.ThumbnailHeight > 0
+	lastPath, lastWave := "", (*Wave)(nil)
+	for _, sub := range sortedSubfolders(vb) {
+		for _, p := range *vb.PhonemesMap[sub] {
+			path := vb.SamplePath(sub, p)
+			if path != lastPath {
+				w, err := gr.wr.Read(path)
+				if err != nil {
+					w = nil
+				}
+				lastPath, lastWave = path, w
+			}
+			w := lastWave
+			if w == nil {
+				continue
+			}
+			img, err := RenderPhonemeImage(p, w, gr.o)
+			if err != nil {
+				return nil, err
+			}
+			e := &GalleryEntry{Subfolder: sub, Phoneme: p, Image: strconv.Itoa(len(res)+1) + ".png"}
+			if err := gr.writePNG(resolvePath(dir, e.Image), img); err != nil {
+				return nil, err
+			}
+			res = append(res, e)
+			if thumbnail {
+				thumbs = append(thumbs, newThumbnail(img, gr.o.ThumbnailWidth, gr.o.ThumbnailHeight))
+			}
+		}
+	}
+	if thumbnail {
+		sheet := NewContactSheet(thumbs, gr.o.Columns, gr.o.ThumbnailWidth, gr.o.ThumbnailHeight)
+		if err := gr.writePNG(resolvePath(dir, "contact.png"), sheet); err != nil {
+			return nil, err
+		}
+	}
+	var buf bytes.Buffer
+	if err := galleryTemplate.Execute(&buf, res); err != nil {
+		return nil, err
+	}
+	if err := gr.fw.Write(resolvePath(dir, "index.html"), buf.String()); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (gr galleryRendererDefault) writePNG(filename string, img image.Image) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	return gr.fw.Write(filename, buf.String())
+}
+
+var galleryTemplate = template.Must(template.New("gallery").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>oto.ini gallery</title>
+<style>
+body { font-family: sans-serif; }
+figure { display: inline-block; margin: 8px; }
+figcaption { font-size: small; }
+.left-blank { color: #4060c0; } .consonant { color: #e060a0; }
+.pre-utterance { color: #e02020; } .overlap { color: #20a020; }
+</style>
+</head>
+<body>
+<p>
+<span class="left-blank">&#9632; LeftBlank / RightBlank</span>
+<span class="consonant">&#9632; Consonant</span>
+<span class="pre-utterance">| PreUtterance</span>
+<span class="overlap">| Overlap</span>
+</p>
+{{range .}}<figure>
+<img src="{{.Image}}" alt="{{.Phoneme.Alias}}">
+<figcaption><b>{{.Phoneme.Alias}}</b> {{if .Subfolder}}{{.Subfolder}}/{{end}}{{.Phoneme.Filename}}<br>
+{{.Phoneme.LeftBlank}}, {{.Phoneme.Consonant}}, {{.Phoneme.RightBlank}}, {{.Phoneme.PreUtterance}}, {{.Phoneme.Overlap}}</figcaption>
+</figure>
+{{end}}</body>
+</html>
+`))
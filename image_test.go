@@ -0,0 +1,89 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var testImagePhoneme = &Phoneme{Filename: "ka.wav", Alias: "か", LeftBlank: 100, Consonant: 80, RightBlank: -350, PreUtterance: 50, Overlap: 20}
+
+func TestRenderPhonemeImageDrawsRegions(t *testing.T) {
+	o := DefaultPhonemeImageOptions()
+	o.Width, o.Height = 550, 100
+	img, err := RenderPhonemeImage(testImagePhoneme, newTestWave(), o)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, image.Rect(0, 0, 550, 100), img.Bounds())
+	assert.Equal(t, imagePreUtterance, img.RGBAAt(150, 10))
+	assert.Equal(t, imageOverlap, img.RGBAAt(120, 10))
+	assert.NotEqual(t, img.RGBAAt(50, 10), img.RGBAAt(300, 10), "LeftBlank should be coloured.")
+}
+
+func TestFailedCasesOfRenderPhonemeImage(t *testing.T) {
+	for i, o := range []PhonemeImageOptions{
+		{Width: 0, Height: 100, Waveform: true},
+		{Width: 100, Height: 100},
+	} {
+		t.Logf("Test case %v.; `%v` is not a valid option.", i+1, o)
+		_, err := RenderPhonemeImage(testImagePhoneme, newTestWave(), o)
+		assert.Error(t, err)
+	}
+}
+
+func TestWritePhonemePNGWritesPNG(t *testing.T) {
+	o := DefaultPhonemeImageOptions()
+	o.Width, o.Height, o.Spectrogram = 100, 40, false
+	var buf bytes.Buffer
+	assert.Equal(t, nil, WritePhonemePNG(&buf, testImagePhoneme, newTestWave(), o))
+	img, err := png.Decode(&buf)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, image.Rect(0, 0, 100, 40), img.Bounds())
+}
+
+func TestNewContactSheetArrangesImages(t *testing.T) {
+	imgs := []image.Image{image.NewRGBA(image.Rect(0, 0, 10, 10)), image.NewRGBA(image.Rect(0, 0, 10, 10)), image.NewRGBA(image.Rect(0, 0, 10, 10))}
+	sheet := NewContactSheet(imgs, 2, 5, 4)
+	assert.Equal(t, image.Rect(0, 0, 10, 8), sheet.Bounds())
+}
+
+func TestNewThumbnailShrinksImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	img.Set(9, 9, color.RGBA{0xff, 0, 0, 0xff})
+	thumb := newThumbnail(img, 5, 4)
+	assert.Equal(t, image.Rect(0, 0, 5, 4), thumb.Bounds())
+	assert.Equal(t, color.RGBA{0, 0, 0, 0}, thumb.RGBAAt(0, 0))
+}
+
+func TestGalleryRendererWritesImagesAndHTML(t *testing.T) {
+	mockedWaveReader := new(waveReaderMock)
+	mockedFileWriter := new(fileWriterMock)
+	vb := &Voicebank{Path: "vb", PhonemesMap: map[string]*Phonemes{
+		"":   {testImagePhoneme},
+		"C4": {&Phoneme{Filename: "missing.wav", Alias: "<missing>"}},
+	}}
+	o := DefaultPhonemeImageOptions()
+	o.Width, o.Height = 50, 20
+	sut := galleryRendererDefault{wr: mockedWaveReader, fw: mockedFileWriter, o: o}
+	mockedWaveReader.On("Read", resolvePath("vb", "ka.wav")).Return(newTestWave(), nil)
+	mockedWaveReader.On("Read", resolvePath(resolvePath("vb", "C4"), "missing.wav")).Return((*Wave)(nil), errors.New("FAILED"))
+	mockedFileWriter.On("Write", resolvePath("out", "1.png"), mock.Anything).Return(nil)
+	mockedFileWriter.On("Write", resolvePath("out", "contact.png"), mock.Anything).Return(nil)
+	mockedFileWriter.On("Write", resolvePath("out", "index.html"), mock.MatchedBy(func(s string) bool {
+		return strings.Contains(s, `<img src="1.png" alt="か">`) && !strings.Contains(s, "&lt;missing&gt;")
+	})).Return(nil)
+	es, err := sut.Render(vb, "out")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []*GalleryEntry{{Subfolder: "", Phoneme: testImagePhoneme, Image: "1.png"}}, es)
+	mockedFileWriter.AssertExpectations(t)
+}
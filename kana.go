@@ -0,0 +1,351 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Styles of romaji.
+const (
+	// RomajiUTAU is the romaji most romaji banks use, which writes `を` as `wo`.
+	RomajiUTAU = "utau"
+	// RomajiHepburn is the Hepburn romanization, which writes `を` as `o` and `ん` before vowels as `n'`.
+	RomajiHepburn = "hepburn"
+)
+
+// romajiStyles overrides kanaRomaji for each style.
+var romajiStyles = map[string]map[string]string{
+	RomajiUTAU:    {"を": "wo"},
+	RomajiHepburn: {"ゐ": "i", "ゑ": "e"},
+}
+
+// kanaRomaji maps a hiragana syllable into the romaji UTAU banks use for aliases.
+var kanaRomaji = map[string]string{
+	"あ": "a", "い": "i", "う": "u", "え": "e", "お": "o",
+	"か": "ka", "き": "ki", "く": "ku", "け": "ke", "こ": "ko",
+	"が": "ga", "ぎ": "gi", "ぐ": "gu", "げ": "ge", "ご": "go",
+	"さ": "sa", "し": "shi", "す": "su", "せ": "se", "そ": "so",
+	"ざ": "za", "じ": "ji", "ず": "zu", "ぜ": "ze", "ぞ": "zo",
+	"た": "ta", "ち": "chi", "つ": "tsu", "て": "te", "と": "to",
+	"だ": "da", "ぢ": "ji", "づ": "zu", "で": "de", "ど": "do",
+	"な": "na", "に": "ni", "ぬ": "nu", "ね": "ne", "の": "no",
+	"は": "ha", "ひ": "hi", "ふ": "fu", "へ": "he", "ほ": "ho",
+	"ば": "ba", "び": "bi", "ぶ": "bu", "べ": "be", "ぼ": "bo",
+	"ぱ": "pa", "ぴ": "pi", "ぷ": "pu", "ぺ": "pe", "ぽ": "po",
+	"ま": "ma", "み": "mi", "む": "mu", "め": "me", "も": "mo",
+	"や": "ya", "ゆ": "yu", "よ": "yo",
+	"ら": "ra", "り": "ri", "る": "ru", "れ": "re", "ろ": "ro",
+	"わ": "wa", "を": "o", "ん": "n", "ゐ": "wi", "ゑ": "we",
+	"ぁ": "xa", "ぃ": "xi", "ぅ": "xu", "ぇ": "xe", "ぉ": "xo",
+	"ゃ": "xya", "ゅ": "xyu", "ょ": "xyo", "ゎ": "xwa", "っ": "xtsu",
+	"きゃ": "kya", "きゅ": "kyu", "きぇ": "kye", "きょ": "kyo",
+	"ぎゃ": "gya", "ぎゅ": "gyu", "ぎぇ": "gye", "ぎょ": "gyo",
+	"しゃ": "sha", "しゅ": "shu", "しぇ": "she", "しょ": "sho",
+	"じゃ": "ja", "じゅ": "ju", "じぇ": "je", "じょ": "jo",
+	"ちゃ": "cha", "ちゅ": "chu", "ちぇ": "che", "ちょ": "cho",
+	"にゃ": "nya", "にゅ": "nyu", "にぇ": "nye", "にょ": "nyo",
+	"ひゃ": "hya", "ひゅ": "hyu", "ひぇ": "hye", "ひょ": "hyo",
+	"びゃ": "bya", "びゅ": "byu", "びぇ": "bye", "びょ": "byo",
+	"ぴゃ": "pya", "ぴゅ": "pyu", "ぴぇ": "pye", "ぴょ": "pyo",
+	"みゃ": "mya", "みゅ": "myu", "みぇ": "mye", "みょ": "myo",
+	"りゃ": "rya", "りゅ": "ryu", "りぇ": "rye", "りょ": "ryo",
+	"すぃ": "si", "ずぃ": "zi", "てぃ": "ti", "でぃ": "di", "とぅ": "tu", "どぅ": "du",
+	"つぁ": "tsa", "つぃ": "tsi", "つぇ": "tse", "つぉ": "tso",
+	"ふぁ": "fa", "ふぃ": "fi", "ふぇ": "fe", "ふぉ": "fo",
+	"うぃ": "wi", "うぇ": "we", "うぉ": "wo", "いぇ": "ye",
+	"ゔ": "vu", "ゔぁ": "va", "ゔぃ": "vi", "ゔぇ": "ve", "ゔぉ": "vo",
+}
+
+// romajiParts splits romaji of a syllable into the consonant and the vowel.
+// `n` is a vowel without a consonant, as it ends a syllable by itself.
+func romajiParts(r string) (string, string) {
+	if r == "n" {
+		return "", "n"
+	}
+	if r != "" && strings.ContainsAny(r[len(r)-1:], "aiueo") {
+		return r[:len(r)-1], r[len(r)-1:]
+	}
+	return r, ""
+}
+
+// isRomaji reports whether the string is a syllable in romaji romajiKana knows.
+func isRomaji(s string) bool {
+	_, ok := romajiKana[s]
+	return ok
+}
+
+// isLowerASCII reports whether the string consists of lowercase ASCII letters.
+func isLowerASCII(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+// syllableRomaji returns romaji of a syllable written in kana or romaji.
+func syllableRomaji(s string) (string, bool) {
+	if r, ok := kanaRomaji[FoldKana(s)]; ok {
+		return r, true
+	}
+	if isRomaji(s) {
+		return s, true
+	}
+	return "", false
+}
+
+// romajiKana maps romaji into hiragana, preferring the common kana such as `じ` over `ぢ`.
+var romajiKana = func() map[string]string {
+	ks := make([]string, 0, len(kanaRomaji))
+	for k := range kanaRomaji {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	res := map[string]string{"wo": "を"}
+	for _, k := range ks {
+		if _, ok := res[kanaRomaji[k]]; !ok {
+			res[kanaRomaji[k]] = k
+		}
+	}
+	return res
+}()
+
+// romajiConsonants is the set of consonants of the syllables in romajiKana, such as `k` and `sh`.
+var romajiConsonants = func() map[string]bool {
+	res := map[string]bool{}
+	for r := range romajiKana {
+		if c, _ := romajiParts(r); c != "" {
+			res[c] = true
+		}
+	}
+	return res
+}()
+
+// FoldKana converts katakana into hiragana, leaving the other characters.
+// `ー` is kept as there is no hiragana for it.
+func FoldKana(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'ァ' && r <= 'ヶ' {
+			return r - 'ァ' + 'ぁ'
+		}
+		return r
+	}, s)
+}
+
+func isVowel(c byte) bool {
+	return strings.IndexByte("aiueo", c) >= 0
+}
+
+// KanaToRomaji converts hiragana and katakana into romaji of the style.
+// `っ` doubles the following consonant, `ー` repeats the previous vowel,
+// small kana standing alone are written with `x` as `ぁ` is `xa`, and characters other than kana are kept.
+func KanaToRomaji(s string, style string) string {
+	s = FoldKana(s)
+	type syllable struct {
+		kana   string
+		romaji string
+	}
+	ss := []syllable{}
+	for i := 0; i < len(s); {
+		_, size := utf8.DecodeRuneInString(s[i:])
+		if i+size < len(s) {
+			_, size2 := utf8.DecodeRuneInString(s[i+size:])
+			if r, ok := kanaRomaji[s[i:i+size+size2]]; ok {
+				ss = append(ss, syllable{s[i : i+size+size2], r})
+				i += size + size2
+				continue
+			}
+		}
+		k := s[i : i+size]
+		r, ok := romajiStyles[style][k]
+		if !ok {
+			r, ok = kanaRomaji[k]
+		}
+		if !ok {
+			r = k
+		}
+		ss = append(ss, syllable{k, r})
+		i += size
+	}
+	var b strings.Builder
+	for i, sy := range ss {
+		next := ""
+		if i+1 < len(ss) {
+			next = ss[i+1].romaji
+		}
+		switch {
+		case sy.kana == "っ" && next != "" && !isVowel(next[0]) && isLowerASCII(next) && next != "n":
+			if style == RomajiHepburn && strings.HasPrefix(next, "ch") {
+				b.WriteString("t")
+			} else {
+				b.WriteByte(next[0])
+			}
+		case sy.kana == "ん" && style == RomajiHepburn && next != "" && (isVowel(next[0]) || next[0] == 'y'):
+			b.WriteString("n'")
+		case sy.kana == "ー" && i > 0 && isLowerASCII(ss[i-1].romaji):
+			if _, v := romajiParts(ss[i-1].romaji); v != "" && v != "n" {
+				b.WriteString(v)
+			} else {
+				b.WriteString(sy.romaji)
+			}
+		default:
+			b.WriteString(sy.romaji)
+		}
+	}
+	return b.String()
+}
+
+// RomajiToKana converts romaji of either style into hiragana.
+// `n'` is `ん` and so is `nn` unless the second `n` starts a syllable, and a doubled consonant is `っ`. Characters other than lowercase letters are kept.
+// It reports false when a part of the romaji is not a syllable, which is kept as it is.
+func RomajiToKana(s string) (string, bool) {
+	var b strings.Builder
+	ok := true
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < 'a' || c > 'z' {
+			if c != '\'' || i == 0 || s[i-1] != 'n' {
+				b.WriteByte(c)
+			}
+			i++
+			continue
+		}
+		if c == 'n' {
+			if i+1 < len(s) && s[i+1] == 'n' {
+				b.WriteString("ん")
+				i++
+				if i+1 == len(s) || (!isVowel(s[i+1]) && s[i+1] != 'y') {
+					i++
+				}
+				continue
+			}
+			if i+1 == len(s) || s[i+1] == '\'' || (!isVowel(s[i+1]) && s[i+1] != 'y') {
+				b.WriteString("ん")
+				i++
+				continue
+			}
+		}
+		if i+1 < len(s) && !isVowel(c) && (s[i+1] == c || (c == 't' && strings.HasPrefix(s[i+1:], "ch"))) {
+			b.WriteString("っ")
+			i++
+			continue
+		}
+		found := false
+		for l := 4; l > 0; l-- {
+			if i+l > len(s) {
+				continue
+			}
+			if k, exists := romajiKana[s[i:i+l]]; exists {
+				b.WriteString(k)
+				i += l
+				found = true
+				break
+			}
+		}
+		if !found {
+			ok = false
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String(), ok
+}
+
+// AliasForms returns the alias followed by its other forms; hiragana folded from katakana,
+// romaji of both styles and hiragana of romaji. Only the last part of an alias separated by spaces
+// is converted, since the vowel of VCV such as `a` in `a か` is written in romaji in either form.
+func AliasForms(alias string) []string {
+	head, last := "", alias
+	if i := strings.LastIndex(alias, " "); i >= 0 {
+		head, last = alias[:i+1], alias[i+1:]
+	}
+	res := []string{alias}
+	add := func(f string) {
+		for _, r := range res {
+			if r == head+f {
+				return
+			}
+		}
+		res = append(res, head+f)
+	}
+	add(FoldKana(last))
+	if k, ok := RomajiToKana(last); ok && isLowerASCII(last) {
+		add(k)
+	} else if !isLowerASCII(last) {
+		for _, style := range []string{RomajiUTAU, RomajiHepburn} {
+			if r := KanaToRomaji(last, style); isLowerASCII(r) {
+				add(r)
+			}
+		}
+	}
+	return res
+}
+
+// convertAliases converts the last part of the alias of each Phoneme by f, where an empty alias is the filename.
+// The converted Phonemes replace the aliases, or are appended as copies when add is true
+// unless the set already has the alias. It returns the number of aliases converted.
+func (ps *Phonemes) convertAliases(add bool, f func(string) (string, bool)) int {
+	aliases := map[string]bool{}
+	for _, p := range *ps {
+		aliases[p.Alias] = true
+	}
+	count := 0
+	for _, p := range append(Phonemes{}, *ps...) {
+		alias := p.Alias
+		if alias == "" {
+			alias = strings.TrimSuffix(p.Filename, ".wav")
+		}
+		head, last := "", alias
+		if i := strings.LastIndex(alias, " "); i >= 0 {
+			head, last = alias[:i+1], alias[i+1:]
+		}
+		c, ok := f(last)
+		if !ok || head+c == alias {
+			continue
+		}
+		if !add {
+			p.Alias = head + c
+			count++
+			continue
+		}
+		if aliases[head+c] {
+			continue
+		}
+		q := *p
+		q.Alias = head + c
+		*ps = append(*ps, &q)
+		aliases[q.Alias] = true
+		count++
+	}
+	return count
+}
+
+// RomanizeAliases converts aliases in kana into romaji of the style.
+// See convertAliases for add and the result.
+func (ps *Phonemes) RomanizeAliases(style string, add bool) int {
+	return ps.convertAliases(add, func(s string) (string, bool) {
+		r := KanaToRomaji(s, style)
+		return r, r != s && isLowerASCII(strings.ReplaceAll(r, "'", ""))
+	})
+}
+
+// KanaizeAliases converts aliases in romaji into hiragana, and ones in katakana into hiragana.
+// See convertAliases for add and the result.
+func (ps *Phonemes) KanaizeAliases(add bool) int {
+	return ps.convertAliases(add, func(s string) (string, bool) {
+		if isLowerASCII(strings.ReplaceAll(s, "'", "")) {
+			return RomajiToKana(s)
+		}
+		k := FoldKana(s)
+		return k, k != s
+	})
+}
@@ -0,0 +1,174 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRomajiParts(t *testing.T) {
+	testCases := []struct {
+		r         string
+		consonant string
+		vowel     string
+	}{
+		{"ka", "k", "a"},
+		{"shi", "sh", "i"},
+		{"a", "", "a"},
+		{"n", "", "n"},
+		{"k", "k", ""},
+	}
+	for i, tc := range testCases {
+		t.Logf("Test case %v.; %v", i+1, tc.r)
+		c, v := romajiParts(tc.r)
+		assert.Equal(t, tc.consonant, c)
+		assert.Equal(t, tc.vowel, v)
+	}
+}
+
+func TestSyllableRomaji(t *testing.T) {
+	testCases := []struct {
+		s   string
+		res string
+		ok  bool
+	}{
+		{"か", "ka", true},
+		{"しゃ", "sha", true},
+		{"ゔぁ", "va", true},
+		{"tsu", "tsu", true},
+		{"a k", "", false},
+		{"Ka", "", false},
+		{"k", "", false},
+		{"hello", "", false},
+		{"", "", false},
+	}
+	for i, tc := range testCases {
+		t.Logf("Test case %v.; %v", i+1, tc.s)
+		res, ok := syllableRomaji(tc.s)
+		assert.Equal(t, tc.res, res)
+		assert.Equal(t, tc.ok, ok)
+	}
+}
+
+func TestFoldKana(t *testing.T) {
+	assert.Equal(t, "かきゃゔっんa ー", FoldKana("カキャヴッンa ー"))
+}
+
+func TestKanaToRomaji(t *testing.T) {
+	testCases := []struct {
+		s       string
+		utau    string
+		hepburn string
+	}{
+		{"か", "ka", "ka"},
+		{"キャ", "kya", "kya"},
+		{"を", "wo", "o"},
+		{"ゐ", "wi", "i"},
+		{"ヴァ", "va", "va"},
+		{"きって", "kitte", "kitte"},
+		{"まっちゃ", "maccha", "matcha"},
+		{"きんえん", "kinen", "kin'en"},
+		{"ほんや", "honya", "hon'ya"},
+		{"ぁ", "xa", "xa"},
+		{"あっ", "axtsu", "axtsu"},
+		{"カー", "kaa", "kaa"},
+		{"a か", "a ka", "a ka"},
+		{"息", "息", "息"},
+	}
+	for i, tc := range testCases {
+		t.Logf("Test case %v.; %v", i+1, tc.s)
+		assert.Equal(t, tc.utau, KanaToRomaji(tc.s, RomajiUTAU))
+		assert.Equal(t, tc.hepburn, KanaToRomaji(tc.s, RomajiHepburn))
+	}
+}
+
+func TestRomajiToKana(t *testing.T) {
+	testCases := []struct {
+		r   string
+		res string
+		ok  bool
+	}{
+		{"ka", "か", true},
+		{"shi", "し", true},
+		{"si", "すぃ", true},
+		{"ji", "じ", true},
+		{"wo", "を", true},
+		{"kitte", "きって", true},
+		{"matcha", "まっちゃ", true},
+		{"kin'en", "きんえん", true},
+		{"kinnen", "きんねん", true},
+		{"hon", "ほん", true},
+		{"xa", "ぁ", true},
+		{"konnichiwa", "こんにちわ", true},
+		{"honn", "ほん", true},
+		{"a ka", "あ か", true},
+		{"q", "q", false},
+	}
+	for i, tc := range testCases {
+		t.Logf("Test case %v.; %v", i+1, tc.r)
+		res, ok := RomajiToKana(tc.r)
+		assert.Equal(t, tc.ok, ok)
+		if tc.ok {
+			assert.Equal(t, tc.res, res)
+		}
+	}
+}
+
+func TestAliasForms(t *testing.T) {
+	testCases := []struct {
+		alias string
+		res   []string
+	}{
+		{"か", []string{"か", "ka"}},
+		{"カ", []string{"カ", "か", "ka"}},
+		{"を", []string{"を", "wo", "o"}},
+		{"a か", []string{"a か", "a ka"}},
+		{"- sa", []string{"- sa", "- さ"}},
+		{"a k", []string{"a k"}},
+		{"息", []string{"息"}},
+	}
+	for i, tc := range testCases {
+		t.Logf("Test case %v.; %v", i+1, tc.alias)
+		assert.Equal(t, tc.res, AliasForms(tc.alias))
+	}
+}
+
+func TestPhonemesConvertAliases(t *testing.T) {
+	newPhonemes := func() *Phonemes {
+		return &Phonemes{
+			&Phoneme{Filename: "ka.wav", Alias: "- か"},
+			&Phoneme{Filename: "ki.wav", Alias: "a キ"},
+			&Phoneme{Filename: "く.wav"},
+			&Phoneme{Filename: "breath.wav", Alias: "息"},
+			&Phoneme{Filename: "ka2.wav", Alias: "- ka"},
+		}
+	}
+	aliases := func(ps *Phonemes) []string {
+		res := []string{}
+		for _, p := range *ps {
+			res = append(res, p.Alias)
+		}
+		return res
+	}
+
+	ps := newPhonemes()
+	assert.Equal(t, 3, ps.RomanizeAliases(RomajiUTAU, false))
+	assert.Equal(t, []string{"- ka", "a ki", "ku", "息", "- ka"}, aliases(ps))
+
+	ps = newPhonemes()
+	assert.Equal(t, 2, ps.RomanizeAliases(RomajiUTAU, true))
+	assert.Equal(t, []string{"- か", "a キ", "", "息", "- ka", "a ki", "ku"}, aliases(ps))
+	assert.Equal(t, "ki.wav", (*ps)[5].Filename)
+
+	ps = newPhonemes()
+	assert.Equal(t, 2, ps.KanaizeAliases(false))
+	assert.Equal(t, []string{"- か", "a き", "", "息", "- か"}, aliases(ps))
+
+	ps = newPhonemes()
+	assert.Equal(t, 1, ps.KanaizeAliases(true))
+	assert.Equal(t, []string{"- か", "a キ", "", "息", "- ka", "a き"}, aliases(ps))
+}
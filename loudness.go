@@ -0,0 +1,119 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import "math"
+
+// biquad is a second order IIR filter in the direct form I.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// kWeighting returns the K-weighting filters of ITU-R BS.1770 for the sample rate.
+// The coefficients are derived for any sample rate the same way as libebur128 does.
+func kWeighting(rate int) (*biquad, *biquad) {
+	f0, g, q := 1681.974450955533, 3.999843853973347, 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / float64(rate))
+	vh := math.Pow(10, g/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := &biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	f0, q = 38.13547087602444, 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / float64(rate))
+	a0 = 1 + k/q + k*k
+	highpass := &biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	return shelf, highpass
+}
+
+// loudnessBlocks returns the mean square of K-weighted signal summed over channels
+// for each 400ms gating block overlapping by 75%.
+// A signal shorter than a block is measured as a single block.
+func loudnessBlocks(w *Wave) []float64 {
+	frames := w.Len()
+	if frames == 0 || w.SampleRate == 0 {
+		return nil
+	}
+	weighted := make([][]float64, w.NumChannels())
+	for c, s := range w.Channels {
+		shelf, highpass := kWeighting(w.SampleRate)
+		weighted[c] = make([]float64, len(s))
+		for i, v := range s {
+			y := highpass.process(shelf.process(v))
+			weighted[c][i] = y * y
+		}
+	}
+	size := w.SampleRate * 400 / 1000
+	hop := size / 4
+	if size > frames {
+		size, hop = frames, frames
+	}
+	res := []float64{}
+	for start := 0; start+size <= frames; start += hop {
+		sum := 0.0
+		for c := range weighted {
+			for _, v := range weighted[c][start : start+size] {
+				sum += v
+			}
+		}
+		res = append(res, sum/float64(size))
+	}
+	return res
+}
+
+func blockLoudness(z float64) float64 {
+	return -0.691 + 10*math.Log10(z)
+}
+
+// gatedLoudness applies the absolute and relative gates of ITU-R BS.1770 to blocks.
+func gatedLoudness(blocks []float64) float64 {
+	mean := func(threshold float64) float64 {
+		sum, n := 0.0, 0
+		for _, z := range blocks {
+			if z > 0 && blockLoudness(z) > threshold {
+				sum += z
+				n++
+			}
+		}
+		if n == 0 {
+			return 0
+		}
+		return sum / float64(n)
+	}
+	abs := mean(-70)
+	if abs == 0 {
+		return math.Inf(-1)
+	}
+	rel := mean(blockLoudness(abs) - 10)
+	if rel == 0 {
+		return math.Inf(-1)
+	}
+	return blockLoudness(rel)
+}
+
+// IntegratedLoudness measures the integrated loudness of the Wave in LUFS by ITU-R BS.1770.
+// It returns negative infinity when the Wave is silent.
+func IntegratedLoudness(w *Wave) float64 {
+	return gatedLoudness(loudnessBlocks(w))
+}
@@ -0,0 +1,39 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newSineWave(rate int, frequency float64, amplitude float64, ms int) *Wave {
+	w := NewWave(rate, 16, 1, rate*ms/1000)
+	for i := range w.Channels[0] {
+		w.Channels[0][i] = amplitude * math.Sin(2*math.Pi*frequency*float64(i)/float64(rate))
+	}
+	return w
+}
+
+func TestIntegratedLoudnessOfReferenceTone(t *testing.T) {
+	// A 997Hz sine of -20dBFS peak has the mean square of -23dB, which reads -23.0 LUFS.
+	for i, rate := range []int{44100, 48000} {
+		t.Logf("Test case %v.; %vHz reference tone should be measured correctly.", i+1, rate)
+		w := newSineWave(rate, 997, math.Pow(10, -20.0/20), 2000)
+		assert.InDelta(t, -23.0, IntegratedLoudness(w), 0.1)
+	}
+}
+
+func TestIntegratedLoudnessOfSilence(t *testing.T) {
+	assert.True(t, math.IsInf(IntegratedLoudness(NewWave(44100, 16, 1, 44100)), -1))
+	assert.True(t, math.IsInf(IntegratedLoudness(NewWave(44100, 16, 1, 0)), -1))
+}
+
+func TestIntegratedLoudnessOfShortSample(t *testing.T) {
+	w := newSineWave(44100, 997, math.Pow(10, -20.0/20), 200)
+	assert.InDelta(t, -23.0, IntegratedLoudness(w), 0.2)
+}
@@ -0,0 +1,335 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// MergeConflict is a change to a Phoneme both sides made differently.
+// Field is the timing field both sides changed, or empty when a side removed the Phoneme the other changed,
+// in which case Ours or Theirs is nil. Base is nil for a Phoneme both sides added.
+type MergeConflict struct {
+	Filename string   `json:"filename"`
+	Alias    string   `json:"alias"`
+	Field    string   `json:"field"`
+	Base     *Phoneme `json:"base"`
+	Ours     *Phoneme `json:"ours"`
+	Theirs   *Phoneme `json:"theirs"`
+}
+
+// mergeSides are the versions of a conflicting Phoneme of the result to write between conflict markers.
+type mergeSides struct {
+	ours   *Phoneme
+	theirs *Phoneme
+}
+
+// MergeResult is the result of a three-way merge of oto.ini.
+// Phonemes take the values of ours for the conflicting fields.
+type MergeResult struct {
+	Phonemes  *Phonemes        `json:"phonemes"`
+	Conflicts []*MergeConflict `json:"conflicts"`
+
+	lines []*otoLine
+	sides map[*Phoneme]*mergeSides
+}
+
+// otoLine is a line of oto.ini, which is either a Phoneme or a text that is not a Phoneme
+// such as a blank line, a comment or a broken entry.
+type otoLine struct {
+	phoneme *Phoneme
+	text    string
+}
+
+// newOtoLines splits oto.ini into lines, keeping the ones that are not Phonemes verbatim.
+func newOtoLines(t string) []*otoLine {
+	res := []*otoLine{}
+	t = strings.TrimSuffix(t, "\n")
+	if t == "" {
+		return res
+	}
+	for _, l := range strings.Split(t, "\n") {
+		p, err := NewPhonemeFromLine(l)
+		if err != nil {
+			res = append(res, &otoLine{text: l})
+			continue
+		}
+		res = append(res, &otoLine{phoneme: p})
+	}
+	return res
+}
+
+func phonemesOtoLines(ps *Phonemes) []*otoLine {
+	res := []*otoLine{}
+	if ps == nil {
+		return res
+	}
+	for _, p := range *ps {
+		res = append(res, &otoLine{phoneme: p})
+	}
+	return res
+}
+
+// mergeKey identifies a Phoneme by its filename and alias, or a line that is not a Phoneme by its text,
+// and the order among the ones sharing them.
+type mergeKey struct {
+	text     bool
+	filename string
+	alias    string
+	n        int
+}
+
+func mergeKeys(ls []*otoLine) ([]mergeKey, map[mergeKey]*otoLine) {
+	keys := []mergeKey{}
+	index := map[mergeKey]*otoLine{}
+	counts := map[mergeKey]int{}
+	for _, l := range ls {
+		k := mergeKey{text: true, alias: l.text}
+		if l.phoneme != nil {
+			k = mergeKey{filename: l.phoneme.Filename, alias: l.phoneme.Alias}
+		}
+		id := k
+		k.n = counts[id]
+		counts[id]++
+		keys = append(keys, k)
+		index[k] = l
+	}
+	return keys, index
+}
+
+// MergePhonemes merges the changes ours and theirs made to base, keyed by the filename and the alias.
+// A field changed by one side takes the change, and a field both sides changed differently conflicts.
+// A Phoneme removed by a side is removed unless the other side changed it, which conflicts and keeps it.
+// The Phonemes of the result are copies. Phonemes come in the order of ours, and the ones only theirs added follow their preceding Phonemes in theirs.
+func MergePhonemes(base *Phonemes, ours *Phonemes, theirs *Phonemes) *MergeResult {
+	return mergeOtoLines(phonemesOtoLines(base), phonemesOtoLines(ours), phonemesOtoLines(theirs))
+}
+
+// MergeOtoText merges the changes ours and theirs made to base as MergePhonemes does, given the texts of oto.ini.
+// Lines that are not Phonemes, such as blank lines, comments and broken entries, are kept verbatim in Text;
+// such a line is removed when a side removed it, and added when a side added it.
+func MergeOtoText(base string, ours string, theirs string) *MergeResult {
+	return mergeOtoLines(newOtoLines(base), newOtoLines(ours), newOtoLines(theirs))
+}
+
+func mergeOtoLines(base []*otoLine, ours []*otoLine, theirs []*otoLine) *MergeResult {
+	res := &MergeResult{Phonemes: &Phonemes{}, Conflicts: []*MergeConflict{}, lines: []*otoLine{}, sides: map[*Phoneme]*mergeSides{}}
+	_, bi := mergeKeys(base)
+	oks, oi := mergeKeys(ours)
+	tks, ti := mergeKeys(theirs)
+
+	merged := []mergeKey{}
+	values := map[mergeKey]*otoLine{}
+	conflict := func(k mergeKey, field string, b, o, t *Phoneme) {
+		res.Conflicts = append(res.Conflicts, &MergeConflict{Filename: k.filename, Alias: k.alias, Field: field, Base: b, Ours: o, Theirs: t})
+	}
+	for _, k := range oks {
+		if k.text {
+			if _, ok := ti[k]; !ok && bi[k] != nil {
+				continue
+			}
+			values[k] = oi[k]
+			merged = append(merged, k)
+			continue
+		}
+		var b, t *Phoneme
+		if l := bi[k]; l != nil {
+			b = l.phoneme
+		}
+		if l := ti[k]; l != nil {
+			t = l.phoneme
+		}
+		o := oi[k].phoneme
+		m := *o
+		switch {
+		case t == nil && b == nil:
+		case t == nil && *o == *b:
+			continue
+		case t == nil:
+			conflict(k, "", b, o, nil)
+			res.sides[&m] = &mergeSides{ours: &m}
+		default:
+			conflicts := []string{}
+			for _, f := range phonemeFields {
+				vo, vt := *phonemeField(o, f), *phonemeField(t, f)
+				switch {
+				case vo == vt:
+				case b != nil && vo == *phonemeField(b, f):
+					*phonemeField(&m, f) = vt
+				case b != nil && vt == *phonemeField(b, f):
+				default:
+					conflict(k, f, b, o, t)
+					conflicts = append(conflicts, f)
+				}
+			}
+			if len(conflicts) > 0 {
+				s := m
+				for _, f := range conflicts {
+					*phonemeField(&s, f) = *phonemeField(t, f)
+				}
+				res.sides[&m] = &mergeSides{ours: &m, theirs: &s}
+			}
+		}
+		values[k] = &otoLine{phoneme: &m}
+		merged = append(merged, k)
+	}
+
+	for i, k := range tks {
+		if _, ok := oi[k]; ok {
+			continue
+		}
+		b, t := bi[k], ti[k]
+		switch {
+		case k.text && b != nil:
+			continue
+		case k.text:
+			values[k] = t
+		case b != nil && *t.phoneme == *b.phoneme:
+			continue
+		default:
+			m := *t.phoneme
+			values[k] = &otoLine{phoneme: &m}
+			if b != nil {
+				conflict(k, "", b.phoneme, nil, t.phoneme)
+				res.sides[&m] = &mergeSides{theirs: &m}
+			}
+		}
+		at := 0
+		for j := i - 1; j >= 0; j-- {
+			if p := indexOfMergeKey(merged, tks[j]); p >= 0 {
+				at = p + 1
+				break
+			}
+		}
+		merged = append(merged[:at], append([]mergeKey{k}, merged[at:]...)...)
+	}
+
+	for _, k := range merged {
+		l := values[k]
+		res.lines = append(res.lines, l)
+		if l.phoneme != nil {
+			*res.Phonemes = append(*res.Phonemes, l.phoneme)
+		}
+	}
+	return res
+}
+
+func indexOfMergeKey(ks []mergeKey, k mergeKey) int {
+	for i, x := range ks {
+		if x == k {
+			return i
+		}
+	}
+	return -1
+}
+
+// Text formats the merged oto.ini, where conflicting Phonemes are written between conflict markers as git does;
+// the line of ours and the line with the values of theirs for the conflicting fields.
+func (r *MergeResult) Text() string {
+	var b strings.Builder
+	for _, l := range r.lines {
+		if l.phoneme == nil {
+			b.WriteString(l.text + "\n")
+			continue
+		}
+		p := l.phoneme
+		s, ok := r.sides[p]
+		if !ok {
+			b.WriteString(p.Line() + "\n")
+			continue
+		}
+		b.WriteString("<<<<<<< ours\n")
+		if s.ours != nil {
+			b.WriteString(s.ours.Line() + "\n")
+		}
+		b.WriteString("=======\n")
+		if s.theirs != nil {
+			b.WriteString(s.theirs.Line() + "\n")
+		}
+		b.WriteString(">>>>>>> theirs\n")
+	}
+	return b.String()
+}
+
+// RunMergeDriver runs as a git merge driver for oto.ini with the arguments `%O %A %B`,
+// the paths of the base, ours and theirs. It writes the merge to the path of ours in the line endings of ours,
+// and fails when the merge has conflicts as git expects. The merge is written in Shift_JIS when any of the files is,
+// since a file of only ASCII is taken as UTF-8, and in UTF-8 otherwise, with the byte order mark if ours has one.
+// The command cmd/otomerge runs it, which is installed by
+//
+//	go install utau/cmd/otomerge
+//	git config merge.oto.driver "otomerge %O %A %B"
+//	echo "oto.ini merge=oto" >> .gitattributes
+//
+// Lines of oto.ini that are not Phonemes, such as blank lines and comments, are kept verbatim.
+// It fails without writing when a side has conflict markers left.
+func RunMergeDriver(args []string) error {
+	return runMergeDriver(fileReaderDefault{}, fileWriterDefault{}, args)
+}
+
+func runMergeDriver(fr fileReader, fw fileWriter, args []string) error {
+	if len(args) != 3 {
+		return errors.New("The paths of base, ours and theirs are not given")
+	}
+	enc := UTF8
+	read := func(filename string) (string, []byte, error) {
+		t, err := fr.Read(filename)
+		if err != nil {
+			return "", nil, err
+		}
+		e := DetectEncoding([]byte(t))
+		if e == ShiftJIS {
+			enc = ShiftJIS
+		}
+		text, err := e.Decode([]byte(t))
+		if err != nil {
+			return "", nil, err
+		}
+		for _, l := range strings.Split(text, "\n") {
+			if strings.HasPrefix(l, "<<<<<<<") || strings.HasPrefix(l, ">>>>>>>") {
+				return "", nil, errors.New("The given file has conflict markers left; `" + filename + "`")
+			}
+		}
+		return text, []byte(t), nil
+	}
+	base, _, err := read(args[0])
+	if err != nil {
+		return err
+	}
+	ours, raw, err := read(args[1])
+	if err != nil {
+		return err
+	}
+	theirs, _, err := read(args[2])
+	if err != nil {
+		return err
+	}
+	crlf := strings.Contains(ours, "\r\n")
+	normalize := func(t string) string {
+		return strings.ReplaceAll(t, "\r\n", "\n")
+	}
+	r := MergeOtoText(normalize(base), normalize(ours), normalize(theirs))
+	t := r.Text()
+	if crlf {
+		t = strings.ReplaceAll(t, "\n", "\r\n")
+	}
+	bs, err := enc.Encode(t)
+	if err != nil {
+		return err
+	}
+	if enc == UTF8 && bytes.HasPrefix(raw, utf8BOM) {
+		bs = append(append([]byte{}, utf8BOM...), bs...)
+	}
+	if err := fw.Write(args[1], string(bs)); err != nil {
+		return err
+	}
+	if len(r.Conflicts) > 0 {
+		return errors.New("The merge has conflicts; `" + r.Conflicts[0].Filename + "=" + r.Conflicts[0].Alias + "` and " + strconv.Itoa(len(r.Conflicts)) + " in total")
+	}
+	return nil
+}
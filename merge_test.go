@@ -0,0 +1,150 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestMergePhonemes(t *testing.T) {
+	testCases := []struct {
+		base      string
+		ours      string
+		theirs    string
+		res       string
+		conflicts []string
+	}{
+		{
+			"a.wav=あ,10,20,-30,40,50\n",
+			"a.wav=あ,15,20,-30,40,50\n",
+			"a.wav=あ,10,20,-30,40,55\n",
+			"a.wav=あ,15,20,-30,40,55\n",
+			[]string{},
+		},
+		{
+			"a.wav=あ,10,20,-30,40,50\n",
+			"a.wav=あ,15,20,-30,40,50\n",
+			"a.wav=あ,12,20,-30,40,55\n",
+			"<<<<<<< ours\na.wav=あ,15,20,-30,40,55\n=======\na.wav=あ,12,20,-30,40,55\n>>>>>>> theirs\n",
+			[]string{"a.wav=あ " + PhonemeFieldLeftBlank},
+		},
+		{
+			"a.wav=あ,1,1,1,1,1\ni.wav=い,1,1,1,1,1\nu.wav=う,1,1,1,1,1\n",
+			"a.wav=あ,1,1,1,1,1\nu.wav=う,1,1,1,1,1\nn.wav=ん,1,1,1,1,1\n",
+			"a.wav=あ,1,1,1,1,1\ne.wav=え,1,1,1,1,1\ni.wav=い,1,1,1,1,1\nu.wav=う,2,1,1,1,1\n",
+			"a.wav=あ,1,1,1,1,1\ne.wav=え,1,1,1,1,1\nu.wav=う,2,1,1,1,1\nn.wav=ん,1,1,1,1,1\n",
+			[]string{},
+		},
+		{
+			"a.wav=あ,1,1,1,1,1\ni.wav=い,1,1,1,1,1\n",
+			"a.wav=あ,2,1,1,1,1\n",
+			"i.wav=い,2,1,1,1,1\n",
+			"<<<<<<< ours\n=======\ni.wav=い,2,1,1,1,1\n>>>>>>> theirs\n<<<<<<< ours\na.wav=あ,2,1,1,1,1\n=======\n>>>>>>> theirs\n",
+			[]string{"a.wav=あ ", "i.wav=い "},
+		},
+		{
+			"",
+			"a.wav=あ,1,1,1,1,1\na.wav=- あ,1,1,1,1,1\n",
+			"a.wav=あ,1,1,1,1,1\na.wav=- あ,3,1,1,1,1\n",
+			"a.wav=あ,1,1,1,1,1\n<<<<<<< ours\na.wav=- あ,1,1,1,1,1\n=======\na.wav=- あ,3,1,1,1,1\n>>>>>>> theirs\n",
+			[]string{"a.wav=- あ " + PhonemeFieldLeftBlank},
+		},
+	}
+	for i, tc := range testCases {
+		t.Logf("Test case %v.", i+1)
+		ours := newTestPhonemes(tc.ours)
+		oursText := ours.Text()
+		r := MergePhonemes(newTestPhonemes(tc.base), ours, newTestPhonemes(tc.theirs))
+		assert.Equal(t, tc.res, r.Text())
+		conflicts := []string{}
+		for _, c := range r.Conflicts {
+			conflicts = append(conflicts, c.Filename+"="+c.Alias+" "+c.Field)
+		}
+		assert.Equal(t, tc.conflicts, conflicts)
+		assert.Equal(t, oursText, ours.Text(), "MergePhonemes should not modify the given Phonemes.")
+	}
+}
+
+func TestMergeOtoTextKeepsOtherLines(t *testing.T) {
+	testCases := []struct {
+		base   string
+		ours   string
+		theirs string
+		res    string
+	}{
+		{
+			"; main\na.wav=あ,1,1,1,1,1\n\ni.wav=い,1,1,1,1,1\n",
+			"; main\na.wav=あ,2,1,1,1,1\n\ni.wav=い,1,1,1,1,1\n",
+			"; main\na.wav=あ,1,1,1,1,1\n\ni.wav=い,1,1,1,1,3\n",
+			"; main\na.wav=あ,2,1,1,1,1\n\ni.wav=い,1,1,1,1,3\n",
+		},
+		{
+			"a.wav=あ,1,1,1,1,1\n",
+			"a.wav=あ,1,1,1,1,1\nb.wav=a,b,1,1,1,1,1\n",
+			"; added\na.wav=あ,1,1,1,1,1\n",
+			"; added\na.wav=あ,1,1,1,1,1\nb.wav=a,b,1,1,1,1,1\n",
+		},
+		{
+			"; old\na.wav=あ,1,1,1,1,1\n",
+			"a.wav=あ,1,1,1,1,1\n",
+			"; old\na.wav=あ,1,1,1,1,1\n\n",
+			"a.wav=あ,1,1,1,1,1\n\n",
+		},
+	}
+	for i, tc := range testCases {
+		t.Logf("Test case %v.", i+1)
+		r := MergeOtoText(tc.base, tc.ours, tc.theirs)
+		assert.Equal(t, tc.res, r.Text())
+		assert.Equal(t, 0, len(r.Conflicts))
+	}
+}
+
+func TestRunMergeDriver(t *testing.T) {
+	encode := func(s string) string {
+		bs, err := ShiftJIS.Encode(s)
+		assert.Equal(t, nil, err)
+		return string(bs)
+	}
+	mockedFileReader := new(fileReaderMock)
+	mockedFileReader.On("Read", "base").Return(encode("a.wav=あ,10,20,-30,40,50\r\n"), nil)
+	mockedFileReader.On("Read", "ours").Return(encode("a.wav=あ,15,20,-30,40,50\r\n"), nil)
+	mockedFileReader.On("Read", "theirs").Return(encode("a.wav=あ,10,20,-30,40,55\r\n"), nil)
+	mockedFileReader.On("Read", "conflict").Return(encode("a.wav=あ,12,20,-30,40,50\r\n"), nil)
+	mockedFileWriter := new(fileWriterMock)
+	mockedFileWriter.On("Write", "ours", mock.Anything).Return(nil)
+
+	err := runMergeDriver(mockedFileReader, mockedFileWriter, []string{"base", "ours", "theirs"})
+	assert.Equal(t, nil, err)
+	mockedFileWriter.AssertCalled(t, "Write", "ours", encode("a.wav=あ,15,20,-30,40,55\r\n"))
+
+	err = runMergeDriver(mockedFileReader, mockedFileWriter, []string{"base", "ours", "conflict"})
+	assert.NotEqual(t, nil, err)
+	mockedFileWriter.AssertCalled(t, "Write", "ours", encode("<<<<<<< ours\r\na.wav=あ,15,20,-30,40,50\r\n=======\r\na.wav=あ,12,20,-30,40,50\r\n>>>>>>> theirs\r\n"))
+
+	err = runMergeDriver(mockedFileReader, mockedFileWriter, []string{"base", "ours"})
+	assert.NotEqual(t, nil, err)
+
+	mockedFileReader.On("Read", "ascii").Return("a.wav=a,1,1,1,1,1\n", nil)
+	mockedFileReader.On("Read", "kana").Return(encode("a.wav=a,1,1,1,1,1\nka.wav=か,1,1,1,1,1\n"), nil)
+	mockedFileWriter.On("Write", "ascii", mock.Anything).Return(nil)
+	err = runMergeDriver(mockedFileReader, mockedFileWriter, []string{"ascii", "ascii", "kana"})
+	assert.Equal(t, nil, err)
+	mockedFileWriter.AssertCalled(t, "Write", "ascii", encode("a.wav=a,1,1,1,1,1\nka.wav=か,1,1,1,1,1\n"))
+
+	mockedFileReader.On("Read", "bom").Return("\xEF\xBB\xBFa.wav=あ,1,1,1,1,1\n", nil)
+	mockedFileReader.On("Read", "bom-theirs").Return("a.wav=あ,2,1,1,1,1\n", nil)
+	mockedFileWriter.On("Write", "bom", mock.Anything).Return(nil)
+	err = runMergeDriver(mockedFileReader, mockedFileWriter, []string{"bom", "bom", "bom-theirs"})
+	assert.Equal(t, nil, err)
+	mockedFileWriter.AssertCalled(t, "Write", "bom", "\xEF\xBB\xBFa.wav=あ,2,1,1,1,1\n")
+
+	mockedFileReader.On("Read", "markers").Return("<<<<<<< ours\na.wav=あ,1,1,1,1,1\n=======\n>>>>>>> theirs\n", nil)
+	err = runMergeDriver(mockedFileReader, mockedFileWriter, []string{"base", "markers", "theirs"})
+	assert.NotEqual(t, nil, err)
+	mockedFileWriter.AssertNotCalled(t, "Write", "markers", mock.Anything)
+}
@@ -0,0 +1,265 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"math"
+	"os"
+)
+
+// NormalizeOptions configures how samples of a Voicebank are fixed in bulk.
+type NormalizeOptions struct {
+	// Loudness enables the loudness normalization to TargetLoudness in LUFS.
+	Loudness       bool    `json:"loudness"`
+	TargetLoudness float64 `json:"target_loudness"`
+	// PerFolder applies one gain to the whole subfolder instead of each sample,
+	// which keeps the balance between samples of the same pitch.
+	PerFolder bool `json:"per_folder"`
+	// MaxPeak is the highest absolute sample value allowed after the gain.
+	MaxPeak float64 `json:"max_peak"`
+	// RemoveDC subtracts the mean from each channel.
+	RemoveDC bool `json:"remove_dc"`
+	// Convert converts samples into 44.1kHz 16bit mono.
+	Convert bool `json:"convert"`
+	// BackupSuffix is appended to the path of original samples to back them up.
+	// BackupDir takes precedence and keeps the layout of subfolders.
+	// No backup is written when both are empty. An existing backup is left alone,
+	// so that normalizing again does not replace the original recording.
+	BackupSuffix string `json:"backup_suffix"`
+	BackupDir    string `json:"backup_dir"`
+	// RegenerateFrq writes a new .frq file instead of just removing the stale one.
+	RegenerateFrq bool `json:"regenerate_frq"`
+	// DryRun only reports what would be done.
+	DryRun bool `json:"dry_run"`
+}
+
+// DefaultNormalizeOptions returns NormalizeOptions that normalize each pitch folder to -18 LUFS
+// and convert samples into 44.1kHz 16bit mono, writing backups next to samples.
+func DefaultNormalizeOptions() NormalizeOptions {
+	return NormalizeOptions{
+		Loudness:       true,
+		TargetLoudness: -18,
+		PerFolder:      true,
+		MaxPeak:        math.Pow(10, -1.0/20),
+		RemoveDC:       true,
+		Convert:        true,
+		BackupSuffix:   ".bak",
+		RegenerateFrq:  true,
+	}
+}
+
+// NormalizeResult reports what is done to a single sample.
+type NormalizeResult struct {
+	Subfolder string `json:"subfolder"`
+	Filename  string `json:"filename"`
+	Path      string `json:"path"`
+	// Gain is the applied gain in dB.
+	Gain      float64 `json:"gain"`
+	DCOffset  float64 `json:"dc_offset"`
+	Converted bool    `json:"converted"`
+	Backup    string  `json:"backup,omitempty"`
+	Frq       string  `json:"frq"`
+	// Unchanged is true when the normalized sample equals the original one, which is left alone with its .frq file.
+	Unchanged bool   `json:"unchanged"`
+	Error     string `json:"error,omitempty"`
+}
+
+// NormalizeWave removes DC offset and converts the format of the Wave in place as the options say.
+// It returns the removed DC offset of the first channel.
+func NormalizeWave(w *Wave, o NormalizeOptions) float64 {
+	dc := 0.0
+	if o.RemoveDC {
+		for c, s := range w.Channels {
+			mean := 0.0
+			for _, v := range s {
+				mean += v
+			}
+			if len(s) > 0 {
+				mean /= float64(len(s))
+			}
+			for i := range s {
+				s[i] -= mean
+			}
+			if c == 0 {
+				dc = mean
+			}
+		}
+	}
+	if o.Convert {
+		mono := append([]float64{}, w.Mono()...)
+		w.Channels = [][]float64{resample(mono, w.SampleRate, 44100)}
+		w.SampleRate, w.BitsPerSample, w.Float = 44100, 16, false
+	}
+	return dc
+}
+
+func needsConversion(w *Wave) bool {
+	return w.SampleRate != 44100 || w.BitsPerSample != 16 || w.Float || w.NumChannels() != 1
+}
+
+func applyGain(w *Wave, db float64) {
+	g := math.Pow(10, db/20)
+	for _, s := range w.Channels {
+		for i := range s {
+			s[i] *= g
+		}
+	}
+}
+
+func peak(w *Wave) float64 {
+	res := 0.0
+	for _, s := range w.Channels {
+		for _, v := range s {
+			res = math.Max(res, math.Abs(v))
+		}
+	}
+	return res
+}
+
+// VoicebankNormalizer fixes the samples referenced by a Voicebank in bulk.
+type VoicebankNormalizer interface {
+	Normalize(*Voicebank) ([]*NormalizeResult, error)
+}
+
+type voicebankNormalizerDefault struct {
+	wr WaveReader
+	fr fileReader
+	fw fileWriter
+	rm fileRemover
+	st fileStater
+	o  NormalizeOptions
+}
+
+// NewVoicebankNormalizer creates a VoicebankNormalizer that rewrites samples on filesystem.
+func NewVoicebankNormalizer(o NormalizeOptions) VoicebankNormalizer {
+	return voicebankNormalizerDefault{
+		wr: NewWaveReader(),
+		fr: fileReaderDefault{},
+		fw: fileWriterDefault{},
+		rm: fileRemoverDefault{},
+		st: fileStaterDefault{},
+		o:  o,
+	}
+}
+
+// Normalize rewrites each wave file referenced by Voicebank.PhonemesMap once.
+// The .frq file of a rewritten sample is removed or regenerated so that resamplers do not use stale data.
+func (vn voicebankNormalizerDefault) Normalize(vb *Voicebank) ([]*NormalizeResult, error) {
+	res := []*NormalizeResult{}
+	for _, sub := range sortedSubfolders(vb) {
+		results := []*NormalizeResult{}
+		waves := []*Wave{}
+		seen := map[string]bool{}
+		for _, p := range *vb.PhonemesMap[sub] {
+			path := vb.SamplePath(sub, p)
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			r := &NormalizeResult{Subfolder: sub, Filename: p.Filename, Path: path, Frq: FrqPath(path)}
+			res = append(res, r)
+			w, err := vn.wr.Read(path)
+			if err != nil {
+				r.Error = err.Error()
+				continue
+			}
+			r.Converted = vn.o.Convert && needsConversion(w)
+			r.DCOffset = NormalizeWave(w, vn.o)
+			results = append(results, r)
+			waves = append(waves, w)
+		}
+		if vn.o.Loudness {
+			vn.gains(results, waves)
+		}
+		if vn.o.DryRun {
+			continue
+		}
+		for i, r := range results {
+			if err := vn.write(r, waves[i]); err != nil {
+				return res, err
+			}
+		}
+	}
+	return res, nil
+}
+
+func (vn voicebankNormalizerDefault) gains(results []*NormalizeResult, waves []*Wave) {
+	if vn.o.PerFolder {
+		blocks := []float64{}
+		maxPeak := 0.0
+		for _, w := range waves {
+			blocks = append(blocks, loudnessBlocks(w)...)
+			maxPeak = math.Max(maxPeak, peak(w))
+		}
+		g := vn.gain(gatedLoudness(blocks), maxPeak)
+		for i, r := range results {
+			r.Gain = g
+			applyGain(waves[i], g)
+		}
+		return
+	}
+	for i, r := range results {
+		r.Gain = vn.gain(IntegratedLoudness(waves[i]), peak(waves[i]))
+		applyGain(waves[i], r.Gain)
+	}
+}
+
+func (vn voicebankNormalizerDefault) gain(loudness float64, maxPeak float64) float64 {
+	if math.IsInf(loudness, -1) {
+		return 0
+	}
+	g := vn.o.TargetLoudness - loudness
+	if vn.o.MaxPeak > 0 && maxPeak > 0 {
+		g = math.Min(g, 20*math.Log10(vn.o.MaxPeak/maxPeak))
+	}
+	return g
+}
+
+func (vn voicebankNormalizerDefault) write(r *NormalizeResult, w *Wave) error {
+	bs, err := w.Bytes()
+	if err != nil {
+		return err
+	}
+	t, err := vn.fr.Read(r.Path)
+	if err != nil {
+		return err
+	}
+	if t == string(bs) {
+		r.Unchanged = true
+		return nil
+	}
+	if backup := vn.backupPath(r); backup != "" {
+		if _, err := vn.st.Stat(backup); err != nil {
+			if !os.IsNotExist(err) {
+				return err
+			}
+			if err := vn.fw.Write(backup, t); err != nil {
+				return err
+			}
+		}
+		r.Backup = backup
+	}
+	if err := vn.fw.Write(r.Path, string(bs)); err != nil {
+		return err
+	}
+	if vn.o.RegenerateFrq {
+		return vn.fw.Write(r.Frq, string(AnalyzeFrequencyMap(w, DefaultFrqSamplesPerFrame).Bytes()))
+	}
+	if err := vn.rm.Remove(r.Frq); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (vn voicebankNormalizerDefault) backupPath(r *NormalizeResult) string {
+	if vn.o.BackupDir != "" {
+		p := &Phoneme{Filename: r.Filename}
+		return (&Voicebank{Path: vn.o.BackupDir}).SamplePath(r.Subfolder, p)
+	}
+	if vn.o.BackupSuffix != "" {
+		return r.Path + vn.o.BackupSuffix
+	}
+	return ""
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"math"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNormalizeWaveRemovesDCAndConverts(t *testing.T) {
+	w := newSineWave(22050, 440, 0.5, 100)
+	w.BitsPerSample = 24
+	for i := range w.Channels[0] {
+		w.Channels[0][i] += 0.1
+	}
+	w.Channels = append(w.Channels, w.Channels[0])
+	dc := NormalizeWave(w, NormalizeOptions{RemoveDC: true, Convert: true})
+	assert.InDelta(t, 0.1, dc, 0.001)
+	assert.Equal(t, 44100, w.SampleRate)
+	assert.Equal(t, 16, w.BitsPerSample)
+	assert.Equal(t, 1, w.NumChannels())
+	assert.Equal(t, 4410, w.Len())
+	assert.False(t, needsConversion(w))
+}
+
+func TestVoicebankNormalizerNormalizesEachFolder(t *testing.T) {
+	mockedWaveReader := new(waveReaderMock)
+	mockedFileReader := new(fileReaderMock)
+	mockedFileWriter := new(fileWriterMock)
+	mockedFileRemover := new(fileRemoverMock)
+	mockedFileStater := new(fileStaterMock)
+	vb := &Voicebank{Path: "vb", PhonemesMap: map[string]*Phonemes{
+		"C4": {
+			&Phoneme{Filename: "a.wav", Alias: "あ"},
+			&Phoneme{Filename: "a.wav", Alias: "- あ"},
+			&Phoneme{Filename: "i.wav", Alias: "い"},
+		},
+	}}
+	a := resolvePath(resolvePath("vb", "C4"), "a.wav")
+	i := resolvePath(resolvePath("vb", "C4"), "i.wav")
+	mockedWaveReader.On("Read", a).Return(newSineWave(44100, 440, 0.1, 1000), nil).Once()
+	mockedWaveReader.On("Read", i).Return(newSineWave(44100, 440, 0.05, 1000), nil).Once()
+	mockedFileReader.On("Read", a).Return("original a", nil)
+	mockedFileReader.On("Read", i).Return("original i", nil)
+	mockedFileStater.On("Stat", resolvePath(resolvePath("backup", "C4"), "a.wav")).Return(nil, os.ErrNotExist)
+	mockedFileStater.On("Stat", resolvePath(resolvePath("backup", "C4"), "i.wav")).Return(nil, os.ErrNotExist)
+	mockedFileWriter.On("Write", resolvePath(resolvePath("backup", "C4"), "a.wav"), "original a").Return(nil)
+	mockedFileWriter.On("Write", resolvePath(resolvePath("backup", "C4"), "i.wav"), "original i").Return(nil)
+	mockedFileWriter.On("Write", a, mock.Anything).Return(nil)
+	mockedFileWriter.On("Write", i, mock.Anything).Return(nil)
+	mockedFileRemover.On("Remove", FrqPath(a)).Return(nil)
+	mockedFileRemover.On("Remove", FrqPath(i)).Return(os.ErrNotExist)
+	o := DefaultNormalizeOptions()
+	o.BackupDir = "backup"
+	o.RegenerateFrq = false
+	sut := voicebankNormalizerDefault{wr: mockedWaveReader, fr: mockedFileReader, fw: mockedFileWriter, rm: mockedFileRemover, st: mockedFileStater, o: o}
+	rs, err := sut.Normalize(vb)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(rs))
+	assert.InDelta(t, rs[0].Gain, rs[1].Gain, 1e-9, "Samples in a folder should share the gain.")
+	assert.False(t, rs[0].Converted)
+	assert.Equal(t, resolvePath(resolvePath("backup", "C4"), "a.wav"), rs[0].Backup)
+	mockedFileWriter.AssertExpectations(t)
+	mockedFileRemover.AssertExpectations(t)
+}
+
+func TestVoicebankNormalizerGainsPerSample(t *testing.T) {
+	mockedWaveReader := new(waveReaderMock)
+	vb := &Voicebank{Path: "vb", PhonemesMap: map[string]*Phonemes{
+		"": {&Phoneme{Filename: "a.wav", Alias: "あ"}, &Phoneme{Filename: "i.wav", Alias: "い"}},
+	}}
+	mockedWaveReader.On("Read", resolvePath("vb", "a.wav")).Return(newSineWave(44100, 997, 0.1, 1000), nil)
+	mockedWaveReader.On("Read", resolvePath("vb", "i.wav")).Return(newSineWave(44100, 997, 0.05, 1000), nil)
+	o := DefaultNormalizeOptions()
+	o.PerFolder = false
+	o.DryRun = true
+	sut := voicebankNormalizerDefault{wr: mockedWaveReader, o: o}
+	rs, err := sut.Normalize(vb)
+	assert.Equal(t, nil, err)
+	assert.InDelta(t, 5, rs[0].Gain, 0.1)
+	assert.InDelta(t, 20*math.Log10(2)+5, rs[1].Gain, 0.1)
+}
+
+type memoryFiles map[string]string
+
+func (m memoryFiles) Read(fn string) (string, error) {
+	t, ok := m[fn]
+	if !ok {
+		return "", os.ErrNotExist
+	}
+	return t, nil
+}
+
+func (m memoryFiles) Write(fn string, text string) error {
+	m[fn] = text
+	return nil
+}
+
+func (m memoryFiles) Stat(fn string) (os.FileInfo, error) {
+	t, ok := m[fn]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return dummyFileInfo{n: fn, s: int64(len(t))}, nil
+}
+
+func (m memoryFiles) Remove(fn string) error {
+	if _, ok := m[fn]; !ok {
+		return os.ErrNotExist
+	}
+	delete(m, fn)
+	return nil
+}
+
+func TestVoicebankNormalizerKeepsExistingBackup(t *testing.T) {
+	w := newSineWave(22050, 440, 0.1, 1000)
+	w.BitsPerSample = 24
+	bs, err := w.Bytes()
+	assert.Equal(t, nil, err)
+	a := resolvePath("vb", "a.wav")
+	files := memoryFiles{a: string(bs)}
+	vb := &Voicebank{Path: "vb", PhonemesMap: map[string]*Phonemes{"": {&Phoneme{Filename: "a.wav", Alias: "あ"}}}}
+	sut := voicebankNormalizerDefault{
+		wr: waveReaderDefault{fr: files, wf: waveFactoryDefault{}},
+		fr: files,
+		fw: files,
+		rm: files,
+		st: files,
+		o:  DefaultNormalizeOptions(),
+	}
+	rs, err := sut.Normalize(vb)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, a+".bak", rs[0].Backup)
+	assert.Equal(t, string(bs), files[a+".bak"], "The backup should hold the original audio.")
+	assert.NotEqual(t, string(bs), files[a])
+
+	normalized := files[a]
+	rs, err = sut.Normalize(vb)
+	assert.Equal(t, nil, err)
+	assert.True(t, rs[0].Unchanged, "A normalized sample should be left alone.")
+	assert.Equal(t, normalized, files[a])
+
+	edited, _ := newSineWave(22050, 220, 0.2, 1000).Bytes()
+	files[a] = string(edited)
+	rs, err = sut.Normalize(vb)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, a+".bak", rs[0].Backup)
+	assert.Equal(t, string(bs), files[a+".bak"], "An existing backup should be left alone.")
+	assert.NotEqual(t, normalized, files[a])
+}
@@ -66,6 +66,41 @@ func NewPhonemeFromLine(l string) (*Phoneme, error) {
 	}, nil
 }
 
+// Line formats the Phoneme as a single line of oto.ini.
+func (p *Phoneme) Line() string {
+	return p.Filename + "=" + p.Alias + "," + strings.Join([]string{
+		formatFloat(p.LeftBlank),
+		formatFloat(p.Consonant),
+		formatFloat(p.RightBlank),
+		formatFloat(p.PreUtterance),
+		formatFloat(p.Overlap),
+	}, ",")
+}
+
+// Text formats Phonemes as a whole oto.ini.
+func (ps Phonemes) Text() string {
+	var b strings.Builder
+	for _, p := range ps {
+		b.WriteString(p.Line())
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// Region returns the absolute start and end of the Phoneme in milliseconds.
+// The duration of the wave file is required because a positive RightBlank
+// is measured from the end of the file.
+func (p *Phoneme) Region(duration float64) (float64, float64) {
+	if p.RightBlank < 0 {
+		return p.LeftBlank, p.LeftBlank - p.RightBlank
+	}
+	return p.LeftBlank, duration - p.RightBlank
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
 func parse(es []string) (float64, float64, float64, float64, float64, error) {
 	r1, err := strconv.ParseFloat(es[1], 64)
 	if err != nil {
@@ -121,3 +121,22 @@ func TestPhonemesReaderReadsFileInFailWhenParsingTextFails(t *testing.T) {
 	mockedFileReader.AssertExpectations(t)
 	mockedPhonemesFactory.AssertExpectations(t)
 }
+
+func TestPhonemeFormatsLine(t *testing.T) {
+	for i, tc := range *testPhonemes {
+		t.Logf("Test case %v.; `%v` should be formatted back into oto.ini.", i+1, tc)
+		actual, err := NewPhonemeFromLine(tc.Line())
+		assert.Equal(t, nil, err)
+		assert.EqualValues(t, tc, actual)
+	}
+	assert.Equal(t, "ファイル名=エイリアス,0,1,2,4,8\n_ああいあうえあ.wav=- あ,500,125,-500,500,250\n", testPhonemes.Text())
+}
+
+func TestPhonemeRegion(t *testing.T) {
+	start, end := (&Phoneme{LeftBlank: 100, RightBlank: -300}).Region(1000)
+	assert.Equal(t, 100.0, start)
+	assert.Equal(t, 400.0, end)
+	start, end = (&Phoneme{LeftBlank: 100, RightBlank: 300}).Region(1000)
+	assert.Equal(t, 100.0, start)
+	assert.Equal(t, 700.0, end)
+}
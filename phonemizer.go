@@ -0,0 +1,179 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"errors"
+	"math"
+	"strings"
+)
+
+// Types of voicebanks by the aliases they have.
+const (
+	// BankTypeCV has a sample for each syllable such as `か`.
+	BankTypeCV = "cv"
+	// BankTypeVCV has samples from the vowel of the previous syllable such as `a か`.
+	BankTypeVCV = "vcv"
+	// BankTypeCVVC has syllables and transitions from vowels into consonants such as `a k`.
+	BankTypeCVVC = "cvvc"
+)
+
+// DetectBankType detects the type of the Voicebank from its aliases.
+// Aliases of a vowel followed by a syllable count for VCV, and ones followed by a consonant count for CVVC.
+// Aliases starting with `-` are ignored since both types have them for the heads of phrases.
+func DetectBankType(vb *Voicebank) string {
+	_, vcv, cvvc := countBankTypes(voicebankAliases(vb))
+	switch {
+	case vcv > cvvc:
+		return BankTypeVCV
+	case cvvc > 0:
+		return BankTypeCVVC
+	}
+	return BankTypeCV
+}
+
+// voicebankAliases returns the aliases of all the Phonemes of the Voicebank.
+func voicebankAliases(vb *Voicebank) []string {
+	res := []string{}
+	for _, sub := range sortedSubfolders(vb) {
+		for _, p := range *vb.PhonemesMap[sub] {
+			res = append(res, p.Alias)
+		}
+	}
+	return res
+}
+
+// countBankTypes counts the aliases of a single syllable, the ones of a vowel followed by a syllable
+// and the ones of a vowel followed by a consonant.
+func countBankTypes(aliases []string) (int, int, int) {
+	cv, vcv, cvvc := 0, 0, 0
+	for _, a := range aliases {
+		es := strings.Fields(a)
+		if len(es) == 1 {
+			if _, ok := syllableRomaji(es[0]); ok {
+				cv++
+			}
+			continue
+		}
+		if len(es) != 2 {
+			continue
+		}
+		if _, v := romajiParts(es[0]); v == "" || es[0] != v {
+			continue
+		}
+		r, ok := syllableRomaji(es[1])
+		if !ok && romajiConsonants[es[1]] {
+			r, ok = es[1], true
+		}
+		if !ok {
+			continue
+		}
+		if _, v := romajiParts(r); v == "" {
+			cvvc++
+		} else {
+			vcv++
+		}
+	}
+	return cv, vcv, cvvc
+}
+
+// Phonemizer converts lyrics of notes into aliases of a Voicebank.
+type Phonemizer interface {
+	Phonemize(notes []*Note, tempo float64) ([]*Note, error)
+}
+
+type phonemizerDefault struct {
+	vb       *Voicebank
+	bankType string
+}
+
+// NewPhonemizer creates a Phonemizer for the Voicebank of the type DetectBankType tells.
+func NewPhonemizer(vb *Voicebank) Phonemizer {
+	return NewPhonemizerOfType(vb, DetectBankType(vb))
+}
+
+// NewPhonemizerOfType creates a Phonemizer treating the Voicebank as the type.
+func NewPhonemizerOfType(vb *Voicebank, bankType string) Phonemizer {
+	return phonemizerDefault{vb: vb, bankType: bankType}
+}
+
+// Phonemize returns copies of the notes with lyrics replaced by aliases.
+// Each lyric tries the aliases for the type of the bank in order and falls back to the lyric itself;
+// a VCV bank tries `a か`, `- か` and `か`, and the others try `- か` at the head of a phrase and `か`.
+// For a CVVC bank, a transition such as `a k` is split off the end of the previous note
+// for PreUtterance of the following syllable, up to the half of the previous note.
+// Aliases are matched in either kana or romaji by Voicebank.LookupAnyForm.
+// Rests and lyrics that are not syllables, such as aliases already, are kept as they are and start a new phrase.
+func (ph phonemizerDefault) Phonemize(notes []*Note, tempo float64) ([]*Note, error) {
+	if tempo <= 0 {
+		return nil, errors.New("The tempo must be positive")
+	}
+	res := []*Note{}
+	vowel := "-"
+	for _, src := range notes {
+		n := copyNote(src)
+		if n.Tempo != nil && *n.Tempo > 0 {
+			tempo = *n.Tempo
+		}
+		lyric := strings.TrimSpace(n.Lyric)
+		r, ok := syllableRomaji(lyric)
+		if n.IsRest() || !ok {
+			vowel = "-"
+			res = append(res, n)
+			continue
+		}
+		consonant, v := romajiParts(r)
+		candidates := []string{}
+		switch ph.bankType {
+		case BankTypeVCV:
+			candidates = append(candidates, vowel+" "+lyric, "- "+lyric, lyric)
+		default:
+			if vowel == "-" {
+				candidates = append(candidates, "- "+lyric)
+			}
+			candidates = append(candidates, lyric)
+		}
+		alias, p := ph.find(candidates, n.NoteNum)
+		if alias != "" {
+			n.Lyric = alias
+		}
+		if ph.bankType == BankTypeCVVC && vowel != "-" && consonant != "" && len(res) > 0 && p != nil {
+			res = ph.splitTransition(res, vowel+" "+consonant, p.PreUtterance, tempo)
+		}
+		res = append(res, n)
+		if v == "" {
+			v = "-"
+		}
+		vowel = v
+	}
+	return res, nil
+}
+
+func (ph phonemizerDefault) find(candidates []string, noteNum int) (string, *Phoneme) {
+	for _, c := range candidates {
+		if a, _, p, ok := ph.vb.LookupAnyForm(c, noteNum); ok {
+			return a, p
+		}
+	}
+	return "", nil
+}
+
+// splitTransition inserts the transition alias at the end of the last note of res when the Voicebank has it.
+func (ph phonemizerDefault) splitTransition(res []*Note, alias string, preUtterance float64, tempo float64) []*Note {
+	prev := res[len(res)-1]
+	alias, _, _, ok := ph.vb.LookupAnyForm(alias, prev.NoteNum)
+	if !ok {
+		return res
+	}
+	ticks := int(math.Round(preUtterance * tempo * TicksPerBeat / 60000))
+	if ticks > prev.Length/2 {
+		ticks = prev.Length / 2
+	}
+	if ticks <= 0 {
+		return res
+	}
+	prev.Length -= ticks
+	return append(res, &Note{Length: ticks, Lyric: alias, NoteNum: prev.NoteNum, Intensity: prev.Intensity, Extra: []*UstEntry{}})
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testCVBank = newTestAliasVoicebank("さ", "く", "ら", "あ")
+var testVCVBank = newTestAliasVoicebank("- さ", "a く", "u ら", "- ら", "a あ", "a さ", "u さ", "- あ")
+var testCVVCBank = newTestAliasVoicebank("- さ", "さ", "く", "ら", "a k", "u r", "a s", "u s", "あ", "a a")
+
+func TestDetectBankType(t *testing.T) {
+	assert.Equal(t, BankTypeCV, DetectBankType(testCVBank))
+	assert.Equal(t, BankTypeVCV, DetectBankType(testVCVBank))
+	assert.Equal(t, BankTypeCVVC, DetectBankType(testCVVCBank))
+	assert.Equal(t, BankTypeVCV, DetectBankType(newTestAliasVoicebank("- sa", "a ku", "u ra")))
+	assert.Equal(t, BankTypeCVVC, DetectBankType(newTestAliasVoicebank("sa", "a k", "- sa")))
+	assert.Equal(t, BankTypeCV, DetectBankType(newTestAliasVoicebank("- さ", "- く", "息 吸")))
+}
+
+func noteLyrics(ns []*Note) string {
+	res := []string{}
+	for _, n := range ns {
+		res = append(res, n.Lyric)
+	}
+	return strings.Join(res, ",")
+}
+
+func TestPhonemizerPhonemizes(t *testing.T) {
+	testCases := []struct {
+		vb     *Voicebank
+		lyrics string
+		res    string
+	}{
+		{testCVBank, "さ く ら", "さ,く,ら"},
+		{testVCVBank, "さ く ら", "- さ,a く,u ら"},
+		{testVCVBank, "さ く R ら", "- さ,a く,R,- ら"},
+		{testVCVBank, "あ さ", "- あ,a さ"},
+		{testVCVBank, "さ く さ", "- さ,a く,u さ"},
+		{testVCVBank, "さ ら", "- さ,- ら"},
+		{testVCVBank, "さ ぬ", "- さ,ぬ"},
+		{testCVVCBank, "さ く ら", "- さ,a k,く,u r,ら"},
+		{testCVVCBank, "さ あ", "- さ,あ"},
+		{testCVVCBank, "さ R さ", "- さ,R,- さ"},
+		{testCVVCBank, "ら さ", "ら,a s,さ"},
+		{newTestAliasVoicebank("- sa", "a ku", "u ra"), "さ ク ra", "- sa,a ku,u ra"},
+		{newTestAliasVoicebank("sa", "a k", "ku"), "サ く", "sa,a k,ku"},
+	}
+	for i, tc := range testCases {
+		t.Logf("Test case %v.; %v", i+1, tc.lyrics)
+		res, err := NewPhonemizer(tc.vb).Phonemize(newLyricNotes(tc.lyrics), 120)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, tc.res, noteLyrics(res))
+	}
+}
+
+func TestPhonemizerSplitsTransitions(t *testing.T) {
+	notes := newLyricNotes("さ く")
+	res, err := NewPhonemizer(testCVVCBank).Phonemize(notes, 150)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(res))
+	// PreUtterance of 100ms is 120 ticks at 150 BPM.
+	assert.Equal(t, 360, res[0].Length)
+	assert.Equal(t, 120, res[1].Length)
+	assert.Equal(t, 60, res[1].NoteNum)
+	assert.Equal(t, 480, res[2].Length)
+	assert.Equal(t, 480, notes[0].Length, "Phonemize should not modify the given notes.")
+	assert.Equal(t, "さ", notes[0].Lyric)
+
+	notes = newLyricNotes("さ く")
+	notes[0].Length = 60
+	res, _ = NewPhonemizer(testCVVCBank).Phonemize(notes, 150)
+	assert.Equal(t, 30, res[0].Length, "The transition should take at most the half of the previous note.")
+	assert.Equal(t, 30, res[1].Length)
+
+	notes = newLyricNotes("さ く")
+	notes[1].Tempo = float64Pointer(75)
+	res, _ = NewPhonemizer(testCVVCBank).Phonemize(notes, 150)
+	assert.Equal(t, 60, res[1].Length)
+}
+
+func TestFailedCasesOfPhonemizer(t *testing.T) {
+	_, err := NewPhonemizer(testCVBank).Phonemize(newLyricNotes("さ"), 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,167 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"math"
+)
+
+// PitchBendTicks is the interval in ticks UTAU samples pitch bend at for resamplers.
+const PitchBendTicks = 5
+
+// Shapes of the curve between Mode2 pitch points, which are the values of PBM.
+const (
+	PitchModeSine   = ""
+	PitchModeLinear = "s"
+	PitchModeR      = "r"
+	PitchModeJ      = "j"
+)
+
+// PitchPoint is a control point of the Mode2 pitch bend.
+// X is in milliseconds from the beginning of the note, and Y is in cents from the pitch of the note.
+// Mode is the shape of the curve from the point to the next one.
+type PitchPoint struct {
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"`
+	Mode string  `json:"mode"`
+}
+
+// Points returns the control points of the PitchBend.
+// Each point is placed the width after the previous one, and the last point
+// goes back to the pitch of the note when PBY lacks its value as UTAU writes.
+func (pb *PitchBend) Points() []PitchPoint {
+	res := []PitchPoint{{X: pb.StartX, Y: pb.StartY * 10}}
+	x := pb.StartX
+	for i, w := range pb.Widths {
+		x += w
+		y := 0.0
+		if i < len(pb.Ys) {
+			y = pb.Ys[i] * 10
+		}
+		if i < len(pb.Modes) {
+			res[i].Mode = pb.Modes[i]
+		}
+		res = append(res, PitchPoint{X: x, Y: y})
+	}
+	return res
+}
+
+// Cents returns the pitch bend in cents at the time in milliseconds from the beginning of the note.
+// It holds the first value before the first point and the last value after the last point.
+func (pb *PitchBend) Cents(ms float64) float64 {
+	ps := pb.Points()
+	if ms <= ps[0].X {
+		return ps[0].Y
+	}
+	for i := 1; i < len(ps); i++ {
+		a, b := ps[i-1], ps[i]
+		if ms >= b.X {
+			continue
+		}
+		return a.Y + (b.Y-a.Y)*pitchShape(a.Mode, (ms-a.X)/(b.X-a.X))
+	}
+	return ps[len(ps)-1].Y
+}
+
+// pitchShape maps the position t from 0 to 1 between two points to the ratio of the transition.
+func pitchShape(mode string, t float64) float64 {
+	switch mode {
+	case PitchModeLinear:
+		return t
+	case PitchModeR:
+		return math.Sin(t * math.Pi / 2)
+	case PitchModeJ:
+		return 1 - math.Cos(t*math.Pi/2)
+	default:
+		return (1 - math.Cos(t*math.Pi)) / 2
+	}
+}
+
+// Cents returns the vibrato in cents at the time in milliseconds from the beginning of a note of noteLength.
+// The vibrato covers the last Length percent of the note. It fades in and out over FadeIn and FadeOut
+// percent of the vibrato, starts Phase percent into the cycle and is shifted by Height percent of Depth.
+func (v *Vibrato) Cents(ms float64, noteLength float64) float64 {
+	length := noteLength * v.Length / 100
+	t := ms - (noteLength - length)
+	if length <= 0 || v.Cycle <= 0 || t < 0 || t > length {
+		return 0
+	}
+	ramp := 1.0
+	if fadeIn := length * v.FadeIn / 100; fadeIn > 0 && t < fadeIn {
+		ramp = t / fadeIn
+	}
+	if fadeOut := length * v.FadeOut / 100; fadeOut > 0 && length-t < fadeOut {
+		ramp = math.Min(ramp, (length-t)/fadeOut)
+	}
+	return v.Depth * ramp * (math.Sin(2*math.Pi*(t/v.Cycle+v.Phase/100)) + v.Height/100)
+}
+
+// NoteCents returns the pitch of the note in cents from NoteNum at the time in milliseconds
+// from the beginning of the note, which is the sum of the pitch bend and the vibrato.
+func NoteCents(n *Note, tempo float64, ms float64) float64 {
+	res := 0.0
+	if n.PitchBend != nil {
+		res += n.PitchBend.Cents(ms)
+	}
+	if n.Vibrato != nil {
+		res += n.Vibrato.Cents(ms, TickToMs(n.Length, tempo))
+	}
+	return res
+}
+
+// PitchCurve samples the pitch of the note in cents every interval ticks
+// for length milliseconds from the time from, which is relative to the beginning of the note.
+func PitchCurve(n *Note, tempo float64, from float64, length float64, interval int) []int {
+	return samplePitch(tempo, from, length, interval, func(ms float64) float64 {
+		return NoteCents(n, tempo, ms)
+	})
+}
+
+func samplePitch(tempo float64, from float64, length float64, interval int, cents func(float64) float64) []int {
+	step := TickToMs(interval, tempo)
+	if step <= 0 || length <= 0 {
+		return []int{}
+	}
+	res := make([]int, int(math.Ceil(length/step)))
+	for i := range res {
+		res[i] = int(math.Round(cents(from + float64(i)*step)))
+	}
+	return res
+}
+
+// SegmentCents returns the pitch in cents from NoteNum of the note of the Segment at the time in milliseconds
+// from the beginning of the note, where the pitch bends of the neighbouring notes take over as UTAU does.
+// The next note sings from its first pitch point, or from the boundary when the point is after it,
+// and the previous note sings before the first pitch point of the note when the point is after the time.
+// Notes without pitch bend and rests do not take over or give up their time.
+func SegmentCents(s *Segment, ms float64) float64 {
+	n := s.Note
+	at := s.NoteStart + ms
+	neighbour := func(o *Segment) float64 {
+		return float64(o.Note.NoteNum-n.NoteNum)*100 + NoteCents(o.Note, o.Tempo, at-o.NoteStart)
+	}
+	if o := s.Next; o != nil && o.Note != nil && o.Note.PitchBend != nil && !o.Note.IsRest() {
+		if at-o.NoteStart >= math.Min(0, o.Note.PitchBend.StartX) {
+			return neighbour(o)
+		}
+	}
+	if o := s.Prev; o != nil && o.Note != nil && o.Note.PitchBend != nil && !o.Note.IsRest() && n.PitchBend != nil {
+		if ms < math.Min(0, n.PitchBend.StartX) {
+			return neighbour(o)
+		}
+	}
+	return NoteCents(n, s.Tempo, ms)
+}
+
+// SegmentPitchCurve samples the pitch of the planned Segment for its resampler request by SegmentCents.
+// The request starts PreUtterance before the note and includes StartPoint, which wavtool skips.
+func SegmentPitchCurve(s *Segment) []int {
+	if s.Note == nil {
+		return []int{}
+	}
+	return samplePitch(s.Tempo, -s.PreUtterance-s.StartPoint, s.RequestLength, PitchBendTicks, func(ms float64) float64 {
+		return SegmentCents(s, ms)
+	})
+}
@@ -0,0 +1,112 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPitchBendPoints(t *testing.T) {
+	pb := &PitchBend{StartX: -40, StartY: -20, Widths: []float64{40, 100, 50}, Ys: []float64{0, 5}, Modes: []string{"", "s", "r"}}
+	assert.Equal(t, []PitchPoint{
+		{X: -40, Y: -200, Mode: ""},
+		{X: 0, Y: 0, Mode: "s"},
+		{X: 100, Y: 50, Mode: "r"},
+		{X: 150, Y: 0, Mode: ""},
+	}, pb.Points())
+}
+
+func TestPitchBendCents(t *testing.T) {
+	testCases := []struct {
+		mode string
+		ms   float64
+		res  float64
+	}{
+		{PitchModeSine, -100, -100},
+		{PitchModeSine, 0, -100},
+		{PitchModeSine, 50, -50},
+		{PitchModeSine, 25, -50 - 50*0.7071067811865476},
+		{PitchModeLinear, 25, -75},
+		{PitchModeR, 50, -100 + 100*0.7071067811865476},
+		{PitchModeJ, 50, -100 + 100*(1-0.7071067811865476)},
+		{PitchModeJ, 100, 0},
+		{PitchModeJ, 1000, 0},
+	}
+	for i, tc := range testCases {
+		t.Logf("Test case %v.; mode `%v` at %v", i+1, tc.mode, tc.ms)
+		pb := &PitchBend{StartX: 0, StartY: -10, Widths: []float64{100}, Ys: []float64{}, Modes: []string{tc.mode}}
+		assert.InDelta(t, tc.res, pb.Cents(tc.ms), 1e-9)
+	}
+}
+
+func TestVibratoCents(t *testing.T) {
+	v := &Vibrato{Length: 50, Cycle: 100, Depth: 40, FadeIn: 20, FadeOut: 20, Phase: 0, Height: 0}
+	assert.Equal(t, 0.0, v.Cents(400, 1000), "The vibrato should start in the last half of the note.")
+	assert.InDelta(t, 40*0.25, v.Cents(525, 1000), 1e-9, "The fade in should last 100ms.")
+	assert.InDelta(t, 40, v.Cents(725, 1000), 1e-9)
+	assert.InDelta(t, -40, v.Cents(775, 1000), 1e-9)
+	assert.InDelta(t, -40*0.25, v.Cents(975, 1000), 1e-9)
+	assert.Equal(t, 0.0, v.Cents(1100, 1000))
+
+	v = &Vibrato{Length: 100, Cycle: 100, Depth: 40, Phase: 25, Height: 50}
+	assert.InDelta(t, 40*1.5, v.Cents(0, 1000), 1e-9)
+	assert.InDelta(t, 40*-0.5, v.Cents(50, 1000), 1e-9)
+	assert.Equal(t, 0.0, (&Vibrato{Length: 100, Depth: 40}).Cents(50, 1000))
+}
+
+func TestPitchCurve(t *testing.T) {
+	n := &Note{Length: 480, NoteNum: 60, PitchBend: &PitchBend{StartX: -50, StartY: -10, Widths: []float64{50}, Ys: []float64{}, Modes: []string{"s"}}}
+	// 5 ticks at 125 BPM is 5ms.
+	curve := PitchCurve(n, 125, -60, 80, PitchBendTicks)
+	assert.Equal(t, 16, len(curve))
+	assert.Equal(t, []int{-100, -100, -100, -90, -80, -70, -60, -50, -40, -30, -20, -10, 0, 0, 0, 0}, curve)
+	decoded, err := DecodePitchBend(EncodePitchBend(curve))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, curve, decoded)
+
+	n.Vibrato = &Vibrato{Length: 100, Cycle: 20, Depth: 10}
+	assert.InDelta(t, 10, NoteCents(n, 125, 5), 1e-9)
+	assert.Equal(t, []int{}, PitchCurve(&Note{Length: 480}, 125, 0, 0, PitchBendTicks))
+}
+
+func TestSegmentPitchCurve(t *testing.T) {
+	n := &Note{Length: 480, NoteNum: 60, PitchBend: &PitchBend{StartX: -20, StartY: -10, Widths: []float64{20}, Ys: []float64{}, Modes: []string{"s"}}}
+	s := &Segment{Note: n, Tempo: 125, PreUtterance: 40, StartPoint: 10, RequestLength: 100}
+	curve := SegmentPitchCurve(s)
+	assert.Equal(t, 20, len(curve))
+	assert.Equal(t, -100, curve[0])
+	assert.Equal(t, -50, curve[8])
+	assert.Equal(t, 0, curve[10])
+	assert.Equal(t, []int{}, SegmentPitchCurve(&Segment{}))
+}
+
+func TestSegmentPitchCurveWithNeighbours(t *testing.T) {
+	n1 := &Note{Length: 480, Lyric: "あ", NoteNum: 60, PitchBend: &PitchBend{Widths: []float64{}, Ys: []float64{}, Modes: []string{}}}
+	n2 := &Note{Length: 480, Lyric: "か", NoteNum: 62, PitchBend: &PitchBend{StartX: -20, StartY: -20, Widths: []float64{20}, Ys: []float64{}, Modes: []string{"s"}}}
+	// 480 ticks at 125 BPM is 480ms, and 5 ticks is 5ms.
+	s1 := &Segment{Note: n1, Tempo: 125, NoteStart: 0, NoteLength: 480, RequestLength: 500}
+	s2 := &Segment{Note: n2, Tempo: 125, NoteStart: 480, NoteLength: 480, PreUtterance: 30, RequestLength: 100}
+	s1.Next, s2.Prev = s2, s1
+
+	curve := SegmentPitchCurve(s1)
+	assert.Equal(t, 100, len(curve))
+	assert.Equal(t, 0, curve[90])
+	assert.Equal(t, 0, curve[92], "The portamento of the next note starts 20ms before the boundary.")
+	assert.Equal(t, 100, curve[94])
+	assert.Equal(t, 200, curve[96])
+	assert.Equal(t, 200, curve[99])
+
+	curve = SegmentPitchCurve(s2)
+	assert.Equal(t, []int{-200, -200, -200, -150, -100, -50, 0, 0}, curve[:8])
+	n2.PitchBend.StartX = 0
+	assert.Equal(t, -200, SegmentPitchCurve(s2)[5], "The previous note should sing before the boundary.")
+
+	n2.Lyric = "R"
+	assert.Equal(t, 0, SegmentPitchCurve(s1)[99], "Rests should not take over.")
+	n2.Lyric, n1.PitchBend = "か", nil
+	assert.Equal(t, PitchCurve(n2, 125, -30, 100, PitchBendTicks), SegmentPitchCurve(s2), "Notes without pitch bend should not take over.")
+}
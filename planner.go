@@ -0,0 +1,148 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"errors"
+	"math"
+)
+
+// Segment is a Note resolved into a region of a sample placed on the timeline.
+// All times are in milliseconds.
+type Segment struct {
+	Index int   `json:"index"`
+	Note  *Note `json:"note"`
+	// Subfolder, Phoneme and SamplePath are empty for rests and lyrics the Voicebank lacks.
+	Subfolder  string   `json:"subfolder"`
+	Phoneme    *Phoneme `json:"phoneme"`
+	SamplePath string   `json:"sample_path"`
+	Tempo      float64  `json:"tempo"`
+	NoteStart  float64  `json:"note_start"`
+	NoteLength float64  `json:"note_length"`
+	// Start is the absolute time the sample starts, which is PreUtterance before the note.
+	Start        float64 `json:"start"`
+	PreUtterance float64 `json:"pre_utterance"`
+	Overlap      float64 `json:"overlap"`
+	StartPoint   float64 `json:"start_point"`
+	// Velocity is the consonant velocity in percent, and VelocityScale is the factor it stretches the consonant by.
+	Velocity      float64 `json:"velocity"`
+	VelocityScale float64 `json:"velocity_scale"`
+	Consonant     float64 `json:"consonant"`
+	// Length is the length the sample is stretched into, which ends where the next sample takes over.
+	Length float64 `json:"length"`
+	// RequestLength is Length with StartPoint rounded up to 50ms as UTAU requests to resamplers.
+	RequestLength float64 `json:"request_length"`
+	// Crossfade is the length overlapping with the previous segment.
+	Crossfade float64 `json:"crossfade"`
+	// Prev and Next are the segments of the neighbouring notes, whose pitch the segment may sing.
+	Prev *Segment `json:"-"`
+	Next *Segment `json:"-"`
+}
+
+// IsSilent reports whether the segment has no sample to sing.
+func (s *Segment) IsSilent() bool {
+	return s.Phoneme == nil
+}
+
+// Plan is the timeline of a sequence of notes.
+type Plan struct {
+	Segments []*Segment `json:"segments"`
+	// Duration is the end of the last note.
+	Duration float64 `json:"duration"`
+}
+
+// VelocityScale returns the factor a consonant velocity stretches the consonant by.
+// 100 keeps the length, 200 halves it and 0 doubles it.
+func VelocityScale(velocity float64) float64 {
+	return math.Pow(2, (100-velocity)/100)
+}
+
+// Planner places notes on the timeline by the timing of a Voicebank.
+type Planner interface {
+	Plan(notes []*Note, tempo float64) (*Plan, error)
+}
+
+type plannerDefault struct {
+	vb *Voicebank
+}
+
+// NewPlanner creates a Planner that resolves lyrics by the Voicebank.
+func NewPlanner(vb *Voicebank) Planner {
+	return plannerDefault{vb: vb}
+}
+
+// Plan resolves the notes the same way as UTAU does.
+// Each note starts PreUtterance before the note and overlaps the previous note by Overlap,
+// both scaled by the consonant velocity. When the previous note is too short to contain
+// PreUtterance minus Overlap in its half, both are shrunk to fit unless the note overrides them.
+// A Tempo of a note applies to the note and the following ones.
+func (pl plannerDefault) Plan(notes []*Note, tempo float64) (*Plan, error) {
+	if tempo <= 0 {
+		return nil, errors.New("The tempo must be positive")
+	}
+	res := &Plan{Segments: []*Segment{}}
+	t := 0.0
+	for i, n := range notes {
+		if n.Tempo != nil && *n.Tempo > 0 {
+			tempo = *n.Tempo
+		}
+		s := &Segment{Index: i, Note: n, Tempo: tempo, NoteStart: t, NoteLength: TickToMs(n.Length, tempo), Velocity: 100}
+		t += s.NoteLength
+		if n.Velocity != nil {
+			s.Velocity = *n.Velocity
+		}
+		s.VelocityScale = VelocityScale(s.Velocity)
+		if n.StartPoint != nil {
+			s.StartPoint = *n.StartPoint
+		}
+		if !n.IsRest() {
+			if sub, p, ok := pl.vb.Lookup(n.Lyric, n.NoteNum); ok {
+				s.Subfolder, s.Phoneme, s.SamplePath = sub, p, pl.vb.SamplePath(sub, p)
+			}
+		}
+		if s.Phoneme != nil {
+			pl.time(s, res.Segments)
+		}
+		res.Segments = append(res.Segments, s)
+	}
+	for i, s := range res.Segments {
+		s.Start = s.NoteStart - s.PreUtterance
+		s.Crossfade = s.Overlap
+		s.Length = s.NoteLength + s.PreUtterance
+		if i > 0 {
+			s.Prev = res.Segments[i-1]
+		}
+		if i+1 < len(res.Segments) {
+			next := res.Segments[i+1]
+			s.Next = next
+			s.Length += next.Overlap - next.PreUtterance
+		}
+		s.Length = math.Max(s.Length, 0)
+		s.RequestLength = math.Ceil((s.Length+s.StartPoint)/50) * 50
+	}
+	res.Duration = t
+	return res, nil
+}
+
+func (pl plannerDefault) time(s *Segment, previous []*Segment) {
+	p, n := s.Phoneme, s.Note
+	s.PreUtterance = p.PreUtterance * s.VelocityScale
+	s.Overlap = p.Overlap * s.VelocityScale
+	s.Consonant = p.Consonant * s.VelocityScale
+	if len(previous) > 0 && !previous[len(previous)-1].IsSilent() {
+		half := previous[len(previous)-1].NoteLength / 2
+		if d := s.PreUtterance - s.Overlap; d > half && d > 0 {
+			k := half / d
+			s.PreUtterance *= k
+			s.Overlap *= k
+		}
+	}
+	if n.PreUtterance != nil {
+		s.PreUtterance = *n.PreUtterance
+	}
+	if n.VoiceOverlap != nil {
+		s.Overlap = *n.VoiceOverlap
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testPlannerVoicebank = &Voicebank{
+	Path: "vb",
+	PhonemesMap: map[string]*Phonemes{
+		"": {
+			&Phoneme{Filename: "a.wav", Alias: "あ", LeftBlank: 100, Consonant: 60, RightBlank: -500, PreUtterance: 60, Overlap: 20},
+			&Phoneme{Filename: "ka.wav", Alias: "か", LeftBlank: 100, Consonant: 150, RightBlank: -500, PreUtterance: 120, Overlap: 40},
+		},
+	},
+	Affixes: &Affixes{},
+}
+
+func TestVelocityScale(t *testing.T) {
+	assert.Equal(t, 1.0, VelocityScale(100))
+	assert.Equal(t, 0.5, VelocityScale(200))
+	assert.Equal(t, 2.0, VelocityScale(0))
+}
+
+func TestPlannerPlacesNotes(t *testing.T) {
+	notes := []*Note{
+		{Length: 480, Lyric: "R", NoteNum: 60},
+		{Length: 480, Lyric: "あ", NoteNum: 60},
+		{Length: 960, Lyric: "か", NoteNum: 62, Velocity: float64Pointer(200)},
+	}
+	plan, err := NewPlanner(testPlannerVoicebank).Plan(notes, 120)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2000.0, plan.Duration)
+	ss := plan.Segments
+	assert.Equal(t, 3, len(ss))
+	assert.True(t, ss[0].IsSilent())
+	assert.Equal(t, 0.0, ss[0].Start)
+
+	assert.Equal(t, resolvePath("vb", "a.wav"), ss[1].SamplePath)
+	assert.Equal(t, 500.0, ss[1].NoteStart)
+	assert.Equal(t, 440.0, ss[1].Start)
+	assert.Equal(t, 20.0, ss[1].Crossfade)
+	assert.Equal(t, 60.0, ss[1].Consonant)
+	assert.Equal(t, 500.0+60-60+20, ss[1].Length)
+	assert.Equal(t, 550.0, ss[1].RequestLength)
+
+	assert.Equal(t, 0.5, ss[2].VelocityScale)
+	assert.Equal(t, 60.0, ss[2].PreUtterance)
+	assert.Equal(t, 20.0, ss[2].Overlap)
+	assert.Equal(t, 75.0, ss[2].Consonant)
+	assert.Equal(t, 940.0, ss[2].Start)
+	assert.Equal(t, 1060.0, ss[2].Length)
+
+	assert.Nil(t, ss[0].Prev)
+	assert.Equal(t, ss[0], ss[1].Prev)
+	assert.Equal(t, ss[2], ss[1].Next)
+	assert.Nil(t, ss[2].Next)
+}
+
+func TestPlannerAutoAdjustsAfterShortNote(t *testing.T) {
+	notes := []*Note{
+		{Length: 60, Lyric: "あ", NoteNum: 60},
+		{Length: 480, Lyric: "か", NoteNum: 60},
+		{Length: 60, Lyric: "あ", NoteNum: 60},
+		{Length: 480, Lyric: "か", NoteNum: 60, PreUtterance: float64Pointer(100), VoiceOverlap: float64Pointer(30)},
+	}
+	plan, err := NewPlanner(testPlannerVoicebank).Plan(notes, 120)
+	assert.Equal(t, nil, err)
+	// The previous note is 62.5ms, so PreUtterance - Overlap must fit in 31.25ms.
+	assert.InDelta(t, 120*31.25/80, plan.Segments[1].PreUtterance, 1e-9)
+	assert.InDelta(t, 40*31.25/80, plan.Segments[1].Overlap, 1e-9)
+	assert.Equal(t, 100.0, plan.Segments[3].PreUtterance)
+	assert.Equal(t, 30.0, plan.Segments[3].Overlap)
+}
+
+func TestPlannerFollowsTempoChanges(t *testing.T) {
+	notes := []*Note{
+		{Length: 480, Lyric: "R"},
+		{Length: 480, Lyric: "R", Tempo: float64Pointer(60)},
+		{Length: 480, Lyric: "R"},
+	}
+	plan, err := NewPlanner(testPlannerVoicebank).Plan(notes, 120)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 500.0, plan.Segments[1].NoteStart)
+	assert.Equal(t, 1500.0, plan.Segments[2].NoteStart)
+	assert.Equal(t, 2500.0, plan.Duration)
+	_, err = NewPlanner(testPlannerVoicebank).Plan(notes, 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,249 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of PluginEdit.
+const (
+	PluginEditModify = "modify"
+	PluginEditDelete = "delete"
+	PluginEditInsert = "insert"
+)
+
+// PluginSelection is the range of notes given to a plugin, Project.Notes[Start:End].
+type PluginSelection struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+func (s PluginSelection) validate(p *Project) error {
+	if s.Start < 0 || s.End > len(p.Notes) || s.Start >= s.End {
+		return errors.New("The given selection is out of the notes; `" + strconv.Itoa(s.Start) + ":" + strconv.Itoa(s.End) + "`")
+	}
+	return nil
+}
+
+// PluginEdit is a change a plugin made to the selected notes.
+// Index is the index in Project.Notes of the note modified or deleted, and -1 for an inserted note.
+// Entries are the keys the plugin wrote, which replace the keys of the note.
+type PluginEdit struct {
+	Kind    string      `json:"kind"`
+	Index   int         `json:"index"`
+	Entries []*UstEntry `json:"entries"`
+}
+
+// NewPluginInput creates the text of the temporary UST UTAU gives to a plugin.
+// The selected notes keep their indexes as section names, and the notes around them are in [#PREV] and [#NEXT].
+// VoiceDir is the path of the Voicebank if given, and a relative or empty CacheDir is resolved in the Voicebank
+// as `temp.cache` for the empty one, so that the plugin finds the samples and their caches.
+func NewPluginInput(p *Project, sel PluginSelection, vb *Voicebank) (string, error) {
+	if err := sel.validate(p); err != nil {
+		return "", err
+	}
+	q := *p
+	if vb != nil {
+		q.VoiceDir = vb.Path
+		if q.CacheDir == "" {
+			q.CacheDir = "temp.cache"
+		}
+		if !filepath.IsAbs(q.CacheDir) {
+			q.CacheDir = resolvePath(vb.Path, q.CacheDir)
+		}
+	}
+	var b strings.Builder
+	b.WriteString("[#SETTING]\r\n")
+	writeUstEntries(&b, q.settingEntries())
+	if sel.Start > 0 {
+		b.WriteString("[#PREV]\r\n")
+		writeUstEntries(&b, p.Notes[sel.Start-1].Entries())
+	}
+	for i := sel.Start; i < sel.End; i++ {
+		b.WriteString(fmt.Sprintf("[#%04d]\r\n", i))
+		writeUstEntries(&b, p.Notes[i].Entries())
+	}
+	if sel.End < len(p.Notes) {
+		b.WriteString("[#NEXT]\r\n")
+		writeUstEntries(&b, p.Notes[sel.End].Entries())
+	}
+	return b.String(), nil
+}
+
+// ParsePluginOutput reads the temporary UST a plugin wrote back.
+// A numbered section modifies the note, [#DELETE] deletes the note in its place
+// and [#INSERT] inserts a note there. [#SETTING], [#PREV] and [#NEXT] are ignored as UTAU does.
+func ParsePluginOutput(t string) ([]*PluginEdit, error) {
+	res := []*PluginEdit{}
+	for _, s := range parseUstSections(t) {
+		switch {
+		case isNoteSection(s.name):
+			i, err := strconv.Atoi(s.name[1:])
+			if err != nil {
+				return nil, errors.New("The section `" + s.name + "` has an invalid number")
+			}
+			res = append(res, &PluginEdit{Kind: PluginEditModify, Index: i, Entries: s.entries})
+		case s.name == "#DELETE":
+			res = append(res, &PluginEdit{Kind: PluginEditDelete, Index: -1, Entries: s.entries})
+		case s.name == "#INSERT":
+			res = append(res, &PluginEdit{Kind: PluginEditInsert, Index: -1, Entries: s.entries})
+		}
+	}
+	return res, nil
+}
+
+// ApplyPluginEdits applies the edits to the selected notes of the Project.
+// Edits are in the order of the notes; [#DELETE] deletes the note following the last one handled,
+// and selected notes the plugin did not write are kept as they are.
+// It fills Index of deleted notes, and nothing changes when it fails.
+func ApplyPluginEdits(p *Project, sel PluginSelection, edits []*PluginEdit) error {
+	if err := sel.validate(p); err != nil {
+		return err
+	}
+	notes := []*Note{}
+	next := sel.Start
+	for _, e := range edits {
+		switch e.Kind {
+		case PluginEditModify:
+			if e.Index < next || e.Index >= sel.End {
+				return errors.New("The plugin wrote a note out of the selection or out of order; `" + strconv.Itoa(e.Index) + "`")
+			}
+			notes = append(notes, p.Notes[next:e.Index]...)
+			n, err := NewNoteFromEntries(mergeUstEntries(p.Notes[e.Index].Entries(), e.Entries))
+			if err != nil {
+				return errors.New("The plugin wrote an invalid note `" + strconv.Itoa(e.Index) + "`; " + err.Error())
+			}
+			n.Section = p.Notes[e.Index].Section
+			notes = append(notes, n)
+			next = e.Index + 1
+		case PluginEditDelete:
+			if next >= sel.End {
+				return errors.New("The plugin deleted more notes than selected")
+			}
+			e.Index = next
+			next++
+		case PluginEditInsert:
+			n, err := NewNoteFromEntries(e.Entries)
+			if err != nil {
+				return errors.New("The plugin inserted an invalid note; " + err.Error())
+			}
+			n.Section = "#INSERT"
+			notes = append(notes, n)
+		default:
+			return errors.New("The given edit has an unknown kind; `" + e.Kind + "`")
+		}
+	}
+	notes = append(notes, p.Notes[next:sel.End]...)
+	rest := append([]*Note{}, p.Notes[sel.End:]...)
+	p.Notes = append(append(p.Notes[:sel.Start:sel.Start], notes...), rest...)
+	return nil
+}
+
+// mergeUstEntries replaces the values of the keys in es by the ones in overrides and appends the new keys.
+func mergeUstEntries(es []*UstEntry, overrides []*UstEntry) []*UstEntry {
+	res := []*UstEntry{}
+	index := map[string]int{}
+	for _, e := range es {
+		index[e.Key] = len(res)
+		res = append(res, &UstEntry{Key: e.Key, Value: e.Value})
+	}
+	for _, e := range overrides {
+		if i, ok := index[e.Key]; ok {
+			res[i].Value = e.Value
+			continue
+		}
+		index[e.Key] = len(res)
+		res = append(res, &UstEntry{Key: e.Key, Value: e.Value})
+	}
+	return res
+}
+
+// PluginHost runs UTAU plugins on a Project.
+type PluginHost interface {
+	Run(ctx context.Context, p *Project, sel PluginSelection) ([]*PluginEdit, error)
+}
+
+type pluginHostDefault struct {
+	command string
+	args    []string
+	timeout time.Duration
+	vb      *Voicebank
+	fr      fileReader
+	fw      fileWriter
+}
+
+// NewPluginHost creates a PluginHost that runs the plugin executable with the path of the temporary UST.
+// args are put before the path, which allows running a Windows plugin by `wine plugin.exe`.
+// vb is the loaded Voicebank given to the plugin as VoiceDir, and may be nil.
+// A timeout of zero means no timeout other than the context.
+func NewPluginHost(command string, vb *Voicebank, timeout time.Duration, args ...string) PluginHost {
+	return pluginHostDefault{command: command, args: args, timeout: timeout, vb: vb, fr: fileReaderDefault{}, fw: fileWriterDefault{}}
+}
+
+// Run writes the temporary UST in the Encoding of the Project, runs the plugin,
+// and applies the edits it wrote back to the Project.
+// It returns the edits, and the Project is unchanged when the plugin fails.
+func (ph pluginHostDefault) Run(ctx context.Context, p *Project, sel PluginSelection) ([]*PluginEdit, error) {
+	t, err := NewPluginInput(p, sel, ph.vb)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := p.Encoding().Encode(t)
+	if err != nil {
+		return nil, err
+	}
+	f, err := ioutil.TempFile("", "utau-plugin-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	if err := ph.fw.Write(f.Name(), string(bs)); err != nil {
+		return nil, err
+	}
+
+	if ph.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ph.timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, ph.command, append(append([]string{}, ph.args...), f.Name())...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, errors.New("The plugin failed; " + err.Error() + "; " + strings.TrimSpace(stderr.String()))
+	}
+
+	out, err := ph.fr.Read(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	text, err := DetectEncoding([]byte(out)).Decode([]byte(out))
+	if err != nil {
+		return nil, err
+	}
+	edits, err := ParsePluginOutput(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := ApplyPluginEdits(p, sel, edits); err != nil {
+		return nil, err
+	}
+	return edits, nil
+}
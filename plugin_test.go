@@ -0,0 +1,198 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newTestPluginProject() *Project {
+	return &Project{Tempo: 120, VoiceDir: "%VOICE%uta", Settings: []*UstEntry{}, Notes: []*Note{
+		{Section: "#0000", Length: 480, Lyric: "R", NoteNum: 60, Extra: []*UstEntry{}},
+		{Section: "#0001", Length: 480, Lyric: "あ", NoteNum: 60, Extra: []*UstEntry{}},
+		{Section: "#0002", Length: 480, Lyric: "か", NoteNum: 62, Flags: "B40", Extra: []*UstEntry{}},
+		{Section: "#0003", Length: 960, Lyric: "あ", NoteNum: 64, Extra: []*UstEntry{}},
+	}}
+}
+
+func lyrics(p *Project) string {
+	res := []string{}
+	for _, n := range p.Notes {
+		res = append(res, n.Lyric)
+	}
+	return strings.Join(res, ",")
+}
+
+func TestNewPluginInput(t *testing.T) {
+	p := newTestPluginProject()
+	actual, err := NewPluginInput(p, PluginSelection{Start: 1, End: 3}, &Voicebank{Path: "/voice/uta"})
+	assert.Equal(t, nil, err)
+	assert.True(t, strings.HasPrefix(actual, "[#SETTING]\r\nTempo=120.00\r\n"))
+	assert.Contains(t, actual, "VoiceDir=/voice/uta\r\n")
+	assert.Contains(t, actual, "CacheDir="+resolvePath("/voice/uta", "temp.cache")+"\r\n")
+	assert.Equal(t, "%VOICE%uta", p.VoiceDir, "NewPluginInput should not modify the Project.")
+	assert.Equal(t, "", p.CacheDir, "NewPluginInput should not modify the Project.")
+	assert.Contains(t, actual, "[#PREV]\r\nLength=480\r\nLyric=R\r\nNoteNum=60\r\n[#0001]\r\nLength=480\r\nLyric=あ\r\n")
+	assert.Contains(t, actual, "[#0002]\r\nLength=480\r\nLyric=か\r\nNoteNum=62\r\nFlags=B40\r\n[#NEXT]\r\nLength=960\r\n")
+	assert.NotContains(t, actual, "[#0000]")
+	assert.NotContains(t, actual, "[#0003]")
+
+	p.CacheDir = "uta.cache"
+	actual, _ = NewPluginInput(p, PluginSelection{Start: 1, End: 3}, &Voicebank{Path: "/voice/uta"})
+	assert.Contains(t, actual, "CacheDir="+resolvePath("/voice/uta", "uta.cache")+"\r\n")
+	p.CacheDir = "/tmp/uta.cache"
+	actual, _ = NewPluginInput(p, PluginSelection{Start: 1, End: 3}, &Voicebank{Path: "/voice/uta"})
+	assert.Contains(t, actual, "CacheDir=/tmp/uta.cache\r\n")
+
+	actual, _ = NewPluginInput(p, PluginSelection{Start: 0, End: 4}, nil)
+	assert.Contains(t, actual, "VoiceDir=%VOICE%uta\r\n")
+	assert.Contains(t, actual, "CacheDir=/tmp/uta.cache\r\n")
+	assert.NotContains(t, actual, "[#PREV]")
+	assert.NotContains(t, actual, "[#NEXT]")
+
+	for _, sel := range []PluginSelection{{Start: -1, End: 2}, {Start: 2, End: 2}, {Start: 0, End: 5}} {
+		_, err = NewPluginInput(p, sel, nil)
+		assert.Error(t, err)
+	}
+}
+
+func TestParsePluginOutput(t *testing.T) {
+	edits, err := ParsePluginOutput("[#SETTING]\r\nTempo=120\r\n[#PREV]\r\nLyric=R\r\n[#0001]\r\nLyric=い\r\n[#DELETE]\r\n[#INSERT]\r\nLength=240\r\nLyric=う\r\nNoteNum=60\r\n[#NEXT]\r\nLyric=あ\r\n")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []*PluginEdit{
+		{Kind: PluginEditModify, Index: 1, Entries: []*UstEntry{{Key: "Lyric", Value: "い"}}},
+		{Kind: PluginEditDelete, Index: -1},
+		{Kind: PluginEditInsert, Index: -1, Entries: []*UstEntry{{Key: "Length", Value: "240"}, {Key: "Lyric", Value: "う"}, {Key: "NoteNum", Value: "60"}}},
+	}, edits)
+}
+
+func TestApplyPluginEdits(t *testing.T) {
+	testCases := []struct {
+		sel    PluginSelection
+		output string
+		lyrics string
+	}{
+		{PluginSelection{Start: 1, End: 3}, "[#0001]\r\nLyric=い\r\n[#0002]\r\nLyric=き\r\n", "R,い,き,あ"},
+		{PluginSelection{Start: 1, End: 3}, "[#0002]\r\nLyric=き\r\n", "R,あ,き,あ"},
+		{PluginSelection{Start: 1, End: 3}, "[#DELETE]\r\n[#0002]\r\nLyric=き\r\n", "R,き,あ"},
+		{PluginSelection{Start: 1, End: 3}, "[#0001]\r\n[#INSERT]\r\nLength=240\r\nLyric=う\r\nNoteNum=60\r\n[#DELETE]\r\n", "R,あ,う,あ"},
+		{PluginSelection{Start: 0, End: 4}, "[#INSERT]\r\nLength=240\r\nLyric=う\r\nNoteNum=60\r\n", "う,R,あ,か,あ"},
+		{PluginSelection{Start: 3, End: 4}, "[#DELETE]\r\n", "R,あ,か"},
+	}
+	for i, tc := range testCases {
+		t.Logf("Test case %v.; %v", i+1, tc.output)
+		p := newTestPluginProject()
+		edits, _ := ParsePluginOutput(tc.output)
+		assert.Equal(t, nil, ApplyPluginEdits(p, tc.sel, edits))
+		assert.Equal(t, tc.lyrics, lyrics(p))
+	}
+
+	p := newTestPluginProject()
+	edits, _ := ParsePluginOutput("[#0002]\r\nVelocity=150\r\nPreUtterance=\r\nFoo=bar\r\n")
+	assert.Equal(t, nil, ApplyPluginEdits(p, PluginSelection{Start: 1, End: 3}, edits))
+	n := p.Notes[2]
+	assert.Equal(t, "#0002", n.Section)
+	assert.Equal(t, "か", n.Lyric)
+	assert.Equal(t, "B40", n.Flags, "Keys the plugin did not write should be kept.")
+	assert.Equal(t, 150.0, *n.Velocity)
+	assert.Equal(t, []*UstEntry{{Key: "Foo", Value: "bar"}}, n.Extra)
+
+	for _, output := range []string{
+		"[#0003]\r\nLyric=い\r\n",
+		"[#0002]\r\n[#0001]\r\n",
+		"[#DELETE]\r\n[#DELETE]\r\n[#DELETE]\r\n",
+		"[#0001]\r\nLength=a\r\n",
+		"[#INSERT]\r\nNoteNum=a\r\n",
+	} {
+		p := newTestPluginProject()
+		edits, _ := ParsePluginOutput(output)
+		assert.Error(t, ApplyPluginEdits(p, PluginSelection{Start: 1, End: 3}, edits), output)
+		assert.Equal(t, "R,あ,か,あ", lyrics(p), "The Project should not change when applying fails.")
+	}
+	assert.Error(t, ApplyPluginEdits(newTestPluginProject(), PluginSelection{Start: 1, End: 3}, []*PluginEdit{{Kind: "unknown"}}))
+}
+
+func TestMergeUstEntries(t *testing.T) {
+	es := []*UstEntry{{Key: "A", Value: "1"}, {Key: "B", Value: "2"}}
+	res := mergeUstEntries(es, []*UstEntry{{Key: "B", Value: "3"}, {Key: "C", Value: "4"}})
+	assert.Equal(t, []*UstEntry{{Key: "A", Value: "1"}, {Key: "B", Value: "3"}, {Key: "C", Value: "4"}}, res)
+	assert.Equal(t, "2", es[1].Value)
+}
+
+// TestHelperPlugin is not a real test but a fake plugin run by the tests of PluginHost.
+// It renames the selected notes to `い` and inserts `う` after them in Shift_JIS.
+func TestHelperPlugin(t *testing.T) {
+	mode := os.Getenv("UTAU_TEST_HELPER_PLUGIN")
+	if mode == "" {
+		return
+	}
+	defer os.Exit(0)
+	filename := os.Args[len(os.Args)-1]
+	switch mode {
+	case "fail":
+		fmt.Fprint(os.Stderr, "failed as requested")
+		os.Exit(1)
+	case "sleep":
+		time.Sleep(10 * time.Second)
+	}
+	bs, _ := ioutil.ReadFile(filename)
+	text, _ := ShiftJIS.Decode(bs)
+	var b strings.Builder
+	for _, s := range parseUstSections(text) {
+		if isNoteSection(s.name) {
+			b.WriteString("[" + s.name + "]\r\nLyric=い\r\n")
+		}
+	}
+	b.WriteString("[#INSERT]\r\nLength=240\r\nLyric=う\r\nNoteNum=60\r\n")
+	out, _ := ShiftJIS.Encode(b.String())
+	ioutil.WriteFile(filename, out, 0644)
+}
+
+func newHelperPluginHost(timeout time.Duration) PluginHost {
+	return NewPluginHost(os.Args[0], &Voicebank{Path: "/voice/uta"}, timeout, "-test.run=TestHelperPlugin", "--")
+}
+
+func TestPluginHostRunsExecutable(t *testing.T) {
+	os.Setenv("UTAU_TEST_HELPER_PLUGIN", "edit")
+	defer os.Unsetenv("UTAU_TEST_HELPER_PLUGIN")
+	p := newTestPluginProject()
+	edits, err := newHelperPluginHost(10*time.Second).Run(context.Background(), p, PluginSelection{Start: 1, End: 3})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(edits))
+	assert.Equal(t, "R,い,い,う,あ", lyrics(p))
+}
+
+func TestPluginHostFails(t *testing.T) {
+	p := newTestPluginProject()
+	os.Setenv("UTAU_TEST_HELPER_PLUGIN", "fail")
+	defer os.Unsetenv("UTAU_TEST_HELPER_PLUGIN")
+	_, err := newHelperPluginHost(10*time.Second).Run(context.Background(), p, PluginSelection{Start: 1, End: 3})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed as requested")
+
+	os.Setenv("UTAU_TEST_HELPER_PLUGIN", "sleep")
+	_, err = newHelperPluginHost(100*time.Millisecond).Run(context.Background(), p, PluginSelection{Start: 1, End: 3})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, "R,あ,か,あ", lyrics(p))
+
+	_, err = newHelperPluginHost(time.Second).Run(context.Background(), p, PluginSelection{Start: 3, End: 1})
+	assert.Error(t, err)
+
+	mockedFileWriter := new(fileWriterMock)
+	mockedFileWriter.On("Write", mock.Anything, mock.Anything).Return(errors.New("FAILED"))
+	sut := pluginHostDefault{command: os.Args[0], fr: fileReaderDefault{}, fw: mockedFileWriter}
+	_, err = sut.Run(context.Background(), p, PluginSelection{Start: 1, End: 3})
+	assert.Error(t, err)
+}
@@ -0,0 +1,206 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// PluginContext is the temporary UST UTAU gives to a plugin.
+// A plugin modifies Notes in place, removes notes from it to delete them,
+// and puts new notes into it to insert them. Prev and Next are for reference and never written back.
+type PluginContext struct {
+	// Project holds the settings, and Notes of it are the selected notes.
+	Project *Project
+	Prev    *Note
+	Next    *Note
+	// Encoding is the encoding of the input, which the output is written in.
+	Encoding Encoding
+
+	originals []*Note
+	entries   map[*Note][]*UstEntry
+}
+
+// NewPluginContextFromBytes creates PluginContext from the content of the temporary UST, detecting its encoding.
+func NewPluginContextFromBytes(bs []byte) (*PluginContext, error) {
+	enc := DetectEncoding(bs)
+	t, err := enc.Decode(bs)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewPluginContextFromText(t)
+	if err != nil {
+		return nil, err
+	}
+	c.Encoding = enc
+	return c, nil
+}
+
+// NewPluginContextFromText creates PluginContext from the text of the temporary UST.
+func NewPluginContextFromText(t string) (*PluginContext, error) {
+	p, err := NewProjectFromText(t)
+	if err != nil {
+		return nil, err
+	}
+	c := &PluginContext{Project: p, Encoding: ShiftJIS, originals: append([]*Note{}, p.Notes...), entries: map[*Note][]*UstEntry{}}
+	for _, n := range p.Notes {
+		c.entries[n] = n.Entries()
+	}
+	for _, s := range parseUstSections(t) {
+		if s.name != "#PREV" && s.name != "#NEXT" {
+			continue
+		}
+		n, err := NewNoteFromEntries(s.entries)
+		if err != nil {
+			return nil, errors.New("The section `" + s.name + "` is not a valid note; " + err.Error())
+		}
+		n.Section = s.name
+		if s.name == "#PREV" {
+			c.Prev = n
+		} else {
+			c.Next = n
+		}
+	}
+	return c, nil
+}
+
+// Voicebank reads the Voicebank VoiceDir points to, where `%VOICE%` is replaced by voiceRoot.
+func (c *PluginContext) Voicebank(vr VoicebankReader, voiceRoot string) (*Voicebank, error) {
+	return c.Project.Voicebank(vr, voiceRoot)
+}
+
+// Modified reports whether the note has been changed since it was read.
+// Notes not read from the input are always reported as modified.
+func (c *PluginContext) Modified(n *Note) bool {
+	es, ok := c.entries[n]
+	if !ok {
+		return true
+	}
+	return len(changedUstEntries(es, n.Entries())) > 0
+}
+
+// changedUstEntries returns the entries of after whose values differ from before.
+// Keys removed from before are written with empty values, which clears them.
+func changedUstEntries(before []*UstEntry, after []*UstEntry) []*UstEntry {
+	values := map[string]string{}
+	for _, e := range before {
+		values[e.Key] = e.Value
+	}
+	res := []*UstEntry{}
+	for _, e := range after {
+		if v, ok := values[e.Key]; !ok || v != e.Value {
+			res = append(res, e)
+		}
+		delete(values, e.Key)
+	}
+	for _, e := range before {
+		if _, ok := values[e.Key]; ok {
+			res = append(res, &UstEntry{Key: e.Key, Value: ""})
+		}
+	}
+	return res
+}
+
+// Text formats the result of the plugin.
+// Each note read from the input is written in its section with the keys changed, or as [#DELETE] if removed,
+// and notes put by the plugin are written as [#INSERT] in their places.
+// Notes read from the input must stay in their order.
+func (c *PluginContext) Text() (string, error) {
+	index := map[*Note]int{}
+	for i, n := range c.originals {
+		index[n] = i
+	}
+	var b strings.Builder
+	next := 0
+	for _, n := range c.Project.Notes {
+		i, ok := index[n]
+		if !ok {
+			b.WriteString("[#INSERT]\r\n")
+			writeUstEntries(&b, n.Entries())
+			continue
+		}
+		if i < next {
+			return "", errors.New("The notes of the input have been reordered or duplicated; `" + n.Section + "`")
+		}
+		for ; next < i; next++ {
+			b.WriteString("[#DELETE]\r\n")
+		}
+		b.WriteString(fmt.Sprintf("[%v]\r\n", c.originals[i].Section))
+		writeUstEntries(&b, changedUstEntries(c.entries[n], n.Entries()))
+		next = i + 1
+	}
+	for ; next < len(c.originals); next++ {
+		b.WriteString("[#DELETE]\r\n")
+	}
+	return b.String(), nil
+}
+
+// Bytes formats the result of the plugin in the Encoding of the input.
+func (c *PluginContext) Bytes() ([]byte, error) {
+	t, err := c.Text()
+	if err != nil {
+		return nil, err
+	}
+	return c.Encoding.Encode(t)
+}
+
+// PluginIO reads and writes the temporary UST of a plugin.
+type PluginIO interface {
+	Read(string) (*PluginContext, error)
+	Write(string, *PluginContext) error
+}
+
+type pluginIODefault struct {
+	fr fileReader
+	fw fileWriter
+}
+
+// NewPluginIO creates a default PluginIO that works on filesystem.
+func NewPluginIO() PluginIO {
+	return pluginIODefault{fr: fileReaderDefault{}, fw: fileWriterDefault{}}
+}
+
+// Read PluginContext from the file specified by filename.
+func (pio pluginIODefault) Read(filename string) (*PluginContext, error) {
+	t, err := pio.fr.Read(filename)
+	if err != nil {
+		return nil, err
+	}
+	return NewPluginContextFromBytes([]byte(t))
+}
+
+// Write the result of the plugin to the file specified by filename.
+func (pio pluginIODefault) Write(filename string, c *PluginContext) error {
+	bs, err := c.Bytes()
+	if err != nil {
+		return err
+	}
+	return pio.fw.Write(filename, string(bs))
+}
+
+// RunPlugin runs the callback as a UTAU plugin.
+// It reads the temporary UST of the path in the last command line argument,
+// and writes the result back unless the callback fails.
+func RunPlugin(f func(*PluginContext) error) error {
+	return runPlugin(NewPluginIO(), os.Args, f)
+}
+
+func runPlugin(pio PluginIO, args []string, f func(*PluginContext) error) error {
+	if len(args) < 2 {
+		return errors.New("The path of the temporary UST is not given")
+	}
+	filename := args[len(args)-1]
+	c, err := pio.Read(filename)
+	if err != nil {
+		return err
+	}
+	if err := f(c); err != nil {
+		return err
+	}
+	return pio.Write(filename, c)
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newTestPluginContext(t *testing.T) *PluginContext {
+	in, _ := NewPluginInput(newTestPluginProject(), PluginSelection{Start: 1, End: 3}, &Voicebank{Path: "/voice/uta"})
+	c, err := NewPluginContextFromText(in)
+	assert.Equal(t, nil, err)
+	return c
+}
+
+func TestNewPluginContextFromText(t *testing.T) {
+	c := newTestPluginContext(t)
+	assert.Equal(t, 120.0, c.Project.Tempo)
+	assert.Equal(t, "/voice/uta", c.Project.VoiceDir)
+	assert.Equal(t, "あ,か", lyrics(c.Project))
+	assert.Equal(t, "#0001", c.Project.Notes[0].Section)
+	assert.Equal(t, "R", c.Prev.Lyric)
+	assert.Equal(t, "#NEXT", c.Next.Section)
+	assert.Equal(t, 960, c.Next.Length)
+	assert.False(t, c.Modified(c.Project.Notes[0]))
+	assert.True(t, c.Modified(&Note{}))
+
+	mockedVoicebankReader := new(voicebankReaderMock)
+	mockedVoicebankReader.On("Read", resolvePath("/voice", "uta")).Return(testPlannerVoicebank, nil)
+	vb, err := c.Voicebank(mockedVoicebankReader, "")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, testPlannerVoicebank, vb)
+
+	_, err = NewPluginContextFromText("[#SETTING]\r\n[#PREV]\r\nLength=a\r\n")
+	assert.Error(t, err)
+	_, err = NewPluginContextFromText("[#0001]\r\nLength=480\r\n")
+	assert.Error(t, err)
+}
+
+func TestNewPluginContextFromBytes(t *testing.T) {
+	in, _ := NewPluginInput(newTestPluginProject(), PluginSelection{Start: 1, End: 3}, nil)
+	bs, _ := ShiftJIS.Encode(in)
+	c, err := NewPluginContextFromBytes(bs)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ShiftJIS, c.Encoding)
+	assert.Equal(t, "あ,か", lyrics(c.Project))
+	c, err = NewPluginContextFromBytes([]byte(in))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, UTF8, c.Encoding)
+}
+
+func TestPluginContextText(t *testing.T) {
+	c := newTestPluginContext(t)
+	actual, err := c.Text()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "[#0001]\r\n[#0002]\r\n", actual)
+
+	c.Project.Notes[1].Lyric = "き"
+	c.Project.Notes[1].Flags = ""
+	assert.True(t, c.Modified(c.Project.Notes[1]))
+	c.Project.Notes = append(c.Project.Notes[1:], &Note{Length: 240, Lyric: "う", NoteNum: 60})
+	actual, err = c.Text()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "[#DELETE]\r\n[#0002]\r\nLyric=き\r\nFlags=\r\n[#INSERT]\r\nLength=240\r\nLyric=う\r\nNoteNum=60\r\n", actual)
+
+	c = newTestPluginContext(t)
+	c.Project.Notes = []*Note{}
+	actual, _ = c.Text()
+	assert.Equal(t, "[#DELETE]\r\n[#DELETE]\r\n", actual)
+
+	c = newTestPluginContext(t)
+	c.Project.Notes[0], c.Project.Notes[1] = c.Project.Notes[1], c.Project.Notes[0]
+	_, err = c.Text()
+	assert.Error(t, err)
+}
+
+func TestPluginContextRoundTripsWithHost(t *testing.T) {
+	p := newTestPluginProject()
+	sel := PluginSelection{Start: 1, End: 3}
+	in, _ := NewPluginInput(p, sel, nil)
+	c, _ := NewPluginContextFromText(in)
+	c.Project.Notes[0].Velocity = float64Pointer(150)
+	c.Project.Notes = append(c.Project.Notes[:1], &Note{Length: 240, Lyric: "う", NoteNum: 60})
+	out, _ := c.Text()
+	edits, _ := ParsePluginOutput(out)
+	assert.Equal(t, nil, ApplyPluginEdits(p, sel, edits))
+	assert.Equal(t, "R,あ,う,あ", lyrics(p))
+	assert.Equal(t, 150.0, *p.Notes[1].Velocity)
+}
+
+func TestPluginContextRemovesPitchBendWithHost(t *testing.T) {
+	p := newTestPluginProject()
+	p.Notes[1].PitchBend = &PitchBend{StartX: -40, StartY: -20, Widths: []float64{80, 40}, Ys: []float64{0, 0}, Modes: []string{"", ""}}
+	sel := PluginSelection{Start: 1, End: 3}
+	in, _ := NewPluginInput(p, sel, nil)
+	c, _ := NewPluginContextFromText(in)
+	assert.NotNil(t, c.Project.Notes[0].PitchBend)
+	c.Project.Notes[0].PitchBend = nil
+	out, _ := c.Text()
+	edits, _ := ParsePluginOutput(out)
+	assert.Equal(t, nil, ApplyPluginEdits(p, sel, edits))
+	assert.Nil(t, p.Notes[1].PitchBend)
+	n, err := NewNoteFromEntries([]*UstEntry{{Key: "PBS", Value: ""}, {Key: "PBW", Value: ""}})
+	assert.Equal(t, nil, err)
+	assert.Nil(t, n.PitchBend)
+}
+
+func TestRunPlugin(t *testing.T) {
+	in, _ := NewPluginInput(newTestPluginProject(), PluginSelection{Start: 1, End: 3}, nil)
+	bs, _ := ShiftJIS.Encode(in)
+	mockedFileReader := new(fileReaderMock)
+	mockedFileWriter := new(fileWriterMock)
+	mockedFileReader.On("Read", "tmp").Return(string(bs), nil)
+	mockedFileReader.On("Read", "missing").Return("", errors.New("FAILED"))
+	mockedFileWriter.On("Write", mock.Anything, mock.Anything).Return(nil)
+	pio := pluginIODefault{fr: mockedFileReader, fw: mockedFileWriter}
+
+	err := runPlugin(pio, []string{"plugin.exe", "tmp"}, func(c *PluginContext) error {
+		c.Project.Notes[0].Lyric = "い"
+		return nil
+	})
+	assert.Equal(t, nil, err)
+	out, _ := ShiftJIS.Encode("[#0001]\r\nLyric=い\r\n[#0002]\r\n")
+	mockedFileWriter.AssertCalled(t, "Write", "tmp", string(out))
+
+	err = runPlugin(pio, []string{"plugin.exe", "tmp"}, func(c *PluginContext) error {
+		return errors.New("FAILED")
+	})
+	assert.Error(t, err)
+	mockedFileWriter.AssertNumberOfCalls(t, "Write", 1)
+	assert.Error(t, runPlugin(pio, []string{"plugin.exe"}, func(c *PluginContext) error { return nil }))
+	assert.Error(t, runPlugin(pio, []string{"plugin.exe", "missing"}, func(c *PluginContext) error { return nil }))
+}
@@ -0,0 +1,196 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Languages of voicebanks.
+const (
+	LanguageJapanese = "japanese"
+	LanguageEnglish  = "english"
+)
+
+// pitchFolderPattern matches names of subfolders for pitches such as `C4`, `A#3` and `high`.
+var pitchFolderPattern = regexp.MustCompile(`^(?i:[A-G]#?-?[0-9]|high|mid|low|hi|lo)$`)
+
+// VoicebankProfile describes how a Voicebank is recorded.
+// Style is one of BankType* for Japanese and EnglishConvention* for English, and empty when unknown
+// as is Language. Confidence is from 0 to 1, and Evidence tells what the profile is based on.
+type VoicebankProfile struct {
+	Style            string   `json:"style"`
+	Language         string   `json:"language"`
+	PitchLayers      int      `json:"pitch_layers"`
+	ExpressionLayers int      `json:"expression_layers"`
+	Confidence       float64  `json:"confidence"`
+	Evidence         []string `json:"evidence"`
+}
+
+// isJapaneseSyllable reports whether the string is a syllable in kana or Japanese romaji.
+func isJapaneseSyllable(s string) bool {
+	if _, ok := kanaRomaji[FoldKana(s)]; ok {
+		return true
+	}
+	_, ok := romajiKana[s]
+	return ok
+}
+
+// isJapaneseAlias reports whether the alias ends with a Japanese syllable or is a transition such as `a k`.
+func isJapaneseAlias(alias string) bool {
+	es := strings.Fields(alias)
+	switch {
+	case len(es) == 0:
+		return false
+	case isJapaneseSyllable(es[len(es)-1]):
+		return true
+	case len(es) == 2 && strings.Contains("aiueon", es[0]) && len(es[0]) == 1 && isLowerASCII(es[1]):
+		_, v := romajiParts(es[1])
+		return v == ""
+	}
+	return false
+}
+
+// stripAffixes removes the longest prefix and suffix of prefix.map from the alias.
+func stripAffixes(alias string, prefixes []string, suffixes []string) string {
+	for _, p := range prefixes {
+		if strings.HasPrefix(alias, p) {
+			alias = strings.TrimPrefix(alias, p)
+			break
+		}
+	}
+	for _, s := range suffixes {
+		if strings.HasSuffix(alias, s) {
+			alias = strings.TrimSuffix(alias, s)
+			break
+		}
+	}
+	return alias
+}
+
+// Profile classifies the Voicebank by its aliases, subfolders and prefix.map.
+// Aliases are classified without the affixes of prefix.map. Pitch layers are the distinct affixes
+// of prefix.map or the subfolders named as pitches, whichever are more, and expression layers are
+// the other subfolders besides the default one.
+func (vb *Voicebank) Profile() *VoicebankProfile {
+	res := &VoicebankProfile{Evidence: []string{}}
+	evidence := func(format string, a ...interface{}) {
+		res.Evidence = append(res.Evidence, fmt.Sprintf(format, a...))
+	}
+
+	pairs := map[string]bool{}
+	prefixes, suffixes := []string{}, []string{}
+	if vb.Affixes != nil {
+		for _, a := range *vb.Affixes {
+			pairs[a.Prefix+"\t"+a.Suffix] = true
+			if a.Prefix != "" {
+				prefixes = append(prefixes, a.Prefix)
+			}
+			if a.Suffix != "" {
+				suffixes = append(suffixes, a.Suffix)
+			}
+		}
+	}
+	longestFirst := func(ss []string) {
+		sort.Slice(ss, func(i, j int) bool {
+			if len(ss[i]) != len(ss[j]) {
+				return len(ss[i]) > len(ss[j])
+			}
+			return ss[i] < ss[j]
+		})
+	}
+	longestFirst(prefixes)
+	longestFirst(suffixes)
+	if len(pairs) > 1 {
+		evidence("prefix.map has %d distinct affixes", len(pairs))
+	}
+
+	affixes := map[string]bool{}
+	for _, a := range append(append([]string{}, prefixes...), suffixes...) {
+		affixes[a] = true
+	}
+	pitches, expressions, base := 0, 0, false
+	for _, sub := range sortedSubfolders(vb) {
+		switch {
+		case sub == "":
+			base = base || len(*vb.PhonemesMap[sub]) > 0
+		case pitchFolderPattern.MatchString(sub) || affixes[sub]:
+			pitches++
+			base = true
+			evidence("subfolder `%v` is a pitch layer", sub)
+		default:
+			expressions++
+			evidence("subfolder `%v` is an expression layer", sub)
+		}
+	}
+	res.PitchLayers = len(pairs)
+	if pitches > res.PitchLayers {
+		res.PitchLayers = pitches
+	}
+	if res.PitchLayers == 0 {
+		res.PitchLayers = 1
+	}
+	res.ExpressionLayers = expressions
+	if base || expressions == 0 {
+		res.ExpressionLayers++
+	}
+
+	japanese, others := []string{}, []string{}
+	for _, a := range voicebankAliases(vb) {
+		a = stripAffixes(a, prefixes, suffixes)
+		if isJapaneseAlias(a) {
+			japanese = append(japanese, a)
+		} else {
+			others = append(others, a)
+		}
+	}
+	arpasing, vccv := countEnglishConventions(others)
+	english := arpasing + vccv
+	total := len(japanese) + english
+	if total == 0 {
+		evidence("no aliases are Japanese syllables or English phones")
+		return res
+	}
+	evidence("%d aliases are Japanese syllables or transitions", len(japanese))
+	evidence("%d aliases are English phones", english)
+
+	if len(japanese) >= english {
+		res.Language = LanguageJapanese
+		cv, vcv, cvvc := countBankTypes(japanese)
+		evidence("%d aliases are single syllables such as `か`", cv)
+		evidence("%d aliases are vowels followed by syllables such as `a か`", vcv)
+		evidence("%d aliases are vowels followed by consonants such as `a k`", cvvc)
+		support := cv
+		switch {
+		case vcv > cvvc:
+			res.Style = BankTypeVCV
+			support = vcv
+		case cvvc > 0:
+			res.Style = BankTypeCVVC
+			support = cv + cvvc
+		default:
+			res.Style = BankTypeCV
+		}
+		if n := cv + vcv + cvvc; n > 0 {
+			res.Confidence = float64(len(japanese)) / float64(total) * float64(support) / float64(n)
+		}
+		return res
+	}
+
+	res.Language = LanguageEnglish
+	evidence("%d aliases are pairs of ARPABET phones such as `hh ae`", arpasing)
+	evidence("%d aliases are VCCV syllables such as `k@`", vccv)
+	support := vccv
+	res.Style = EnglishConventionVCCV
+	if arpasing >= vccv {
+		res.Style = EnglishConventionARPAsing
+		support = arpasing
+	}
+	res.Confidence = float64(support) / float64(total)
+	return res
+}
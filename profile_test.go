@@ -0,0 +1,54 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVoicebankProfile(t *testing.T) {
+	multi := newTestAliasVoicebank("a か↑", "a さ↑", "- か↑")
+	multi.PhonemesMap["C5"] = multi.PhonemesMap[""]
+	multi.PhonemesMap["power"] = &Phonemes{&Phoneme{Filename: "ka.wav", Alias: "a か強"}}
+	multi.PhonemesMap[""] = &Phonemes{}
+	multi.Affixes = &Affixes{"C4": &Affix{}, "C5": &Affix{Suffix: "↑"}}
+
+	testCases := []struct {
+		vb          *Voicebank
+		style       string
+		language    string
+		pitches     int
+		expressions int
+		confidence  float64
+	}{
+		{testCVBank, BankTypeCV, LanguageJapanese, 1, 1, 1},
+		{testVCVBank, BankTypeVCV, LanguageJapanese, 1, 1, 1},
+		{testCVVCBank, BankTypeCVVC, LanguageJapanese, 1, 1, 0.89},
+		{testARPAsingBank, EnglishConventionARPAsing, LanguageEnglish, 1, 1, 1},
+		{testVCCVBank, EnglishConventionVCCV, LanguageEnglish, 1, 1, 0.88},
+		{multi, BankTypeVCV, LanguageJapanese, 2, 2, 1},
+		{newTestAliasVoicebank("息", "吸"), "", "", 1, 1, 0},
+		{newTestAliasVoicebank("ka", "ki", "a k", "hh ae", "息"), BankTypeCVVC, LanguageJapanese, 1, 1, 0.75},
+	}
+	for i, tc := range testCases {
+		t.Logf("Test case %v.; %v", i+1, tc.style)
+		p := tc.vb.Profile()
+		assert.Equal(t, tc.style, p.Style)
+		assert.Equal(t, tc.language, p.Language)
+		assert.Equal(t, tc.pitches, p.PitchLayers)
+		assert.Equal(t, tc.expressions, p.ExpressionLayers)
+		assert.InDelta(t, tc.confidence, p.Confidence, 0.01)
+		assert.NotEqual(t, 0, len(p.Evidence))
+	}
+
+	bs, err := json.Marshal(multi.Profile())
+	assert.Equal(t, nil, err)
+	assert.Contains(t, string(bs), `"pitch_layers":2`)
+	assert.Contains(t, multi.Profile().Evidence, "subfolder `C5` is a pitch layer")
+	assert.Contains(t, multi.Profile().Evidence, "subfolder `power` is an expression layer")
+}
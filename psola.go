@@ -0,0 +1,209 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// NoteFrequency returns the frequency in Hz of the note number, where 69 is A4 of 440Hz.
+func NoteFrequency(noteNum int) float64 {
+	return 440 * math.Pow(2, float64(noteNum-69)/12)
+}
+
+type psolaResampler struct {
+	wr WaveReader
+}
+
+// NewPSOLAResampler creates a Resampler implemented in pure Go by TD-PSOLA.
+// The consonant region of the Phoneme is played at the speed the velocity says,
+// and the rest of the region is stretched to fill the requested length.
+// It understands the flags in FlagRegistryPSOLA.
+func NewPSOLAResampler() Resampler {
+	return psolaResampler{wr: NewWaveReader()}
+}
+
+// Resample reads the input and synthesizes the output.
+// Output of the request is ignored; callers write the result if they need a file.
+func (pr psolaResampler) Resample(ctx context.Context, r *ResampleRequest) (*Wave, error) {
+	w, err := pr.wr.Read(r.Input)
+	if err != nil {
+		return nil, err
+	}
+	return ResampleWave(ctx, w, r)
+}
+
+func validateResampleRequest(r *ResampleRequest) error {
+	if math.IsNaN(r.Length) || math.IsInf(r.Length, 0) || r.Length < 0 {
+		return errors.New("The given length is invalid; `" + formatFloat(r.Length) + "`")
+	}
+	if math.IsNaN(r.Consonant) || math.IsInf(r.Consonant, 0) || r.Consonant < 0 {
+		return errors.New("The given consonant is invalid; `" + formatFloat(r.Consonant) + "`")
+	}
+	if consonantOut := r.Consonant * VelocityScale(r.Velocity); consonantOut > r.Length {
+		return errors.New("The given consonant is longer than the length; `" + formatFloat(consonantOut) + "`")
+	}
+	return nil
+}
+
+// ResampleWave synthesizes the output of the request from the decoded input by TD-PSOLA.
+func ResampleWave(ctx context.Context, w *Wave, r *ResampleRequest) (*Wave, error) {
+	rate := w.SampleRate
+	if rate <= 0 || w.Len() == 0 {
+		return nil, errors.New("The given wave is empty")
+	}
+	if err := validateResampleRequest(r); err != nil {
+		return nil, err
+	}
+	s := w.Mono()
+	p := &Phoneme{LeftBlank: r.Offset, Consonant: r.Consonant, RightBlank: r.Cutoff}
+	startMs, endMs := p.Region(w.Duration())
+	start := clampInt(w.MsToFrame(startMs), 0, len(s))
+	end := clampInt(w.MsToFrame(endMs), 0, len(s))
+	consonantEnd := clampInt(w.MsToFrame(startMs+r.Consonant), start, end)
+	if end <= start {
+		return nil, errors.New("The region of the given phoneme is empty")
+	}
+	fm := AnalyzeFrequencyMap(w, DefaultFrqSamplesPerFrame)
+	f0At := func(i int) float64 {
+		return fm.Frequencies[clampInt(i/fm.SamplesPerFrame, 0, len(fm.Frequencies)-1)]
+	}
+
+	unvoiced := rate / 200
+	marks, periods := []int{}, []int{}
+	for m := start; m < end; {
+		period := unvoiced
+		if f := f0At(m); f > 0 {
+			period = int(float64(rate) / f)
+		}
+		marks = append(marks, m)
+		periods = append(periods, period)
+		m += period
+	}
+
+	velocity := VelocityScale(r.Velocity)
+	consonantOut := r.Consonant * velocity
+	vowelOut := r.Length - consonantOut
+	source := func(ms float64) float64 {
+		if ms < consonantOut {
+			return float64(start) + ms/velocity*float64(rate)/1000
+		}
+		if vowelOut <= 0 {
+			return float64(consonantEnd)
+		}
+		return float64(consonantEnd) + (ms-consonantOut)/vowelOut*float64(end-consonantEnd)
+	}
+	bendInterval := TickToMs(PitchBendTicks, r.Tempo)
+	target := func(ms float64, sourceF0 float64) float64 {
+		cents := 0.0
+		if len(r.PitchBend) > 0 && bendInterval > 0 {
+			cents = float64(r.PitchBend[clampInt(int(ms/bendInterval), 0, len(r.PitchBend)-1)])
+		}
+		f := NoteFrequency(r.NoteNum) * math.Pow(2, cents/1200)
+		if r.Modulation != 0 && fm.Average > 0 {
+			f *= math.Pow(sourceF0/fm.Average, r.Modulation/100)
+		}
+		return f
+	}
+
+	flags, err := FlagRegistryPSOLA.Parse(r.Flags)
+	if err != nil {
+		return nil, err
+	}
+	formant := math.Pow(2, -FlagRegistryPSOLA.Value(flags, "g")/200)
+	n := int(r.Length * float64(rate) / 1000)
+	out := make([]float64, n)
+	weights := make([]float64, n)
+	for m, grains := 0, 0; m < n; grains++ {
+		if grains%64 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		ms := float64(m) * 1000 / float64(rate)
+		src := int(source(ms))
+		k := sort.SearchInts(marks, src)
+		if k == len(marks) || (k > 0 && src-marks[k-1] < marks[k]-src) {
+			k--
+		}
+		a, period := marks[k], periods[k]
+		step := period
+		if f := f0At(a); f > 0 {
+			step = int(float64(rate) / target(ms, f))
+		}
+		if step < 1 {
+			step = 1
+		}
+		half := period
+		if step > half {
+			half = step
+		}
+		for j := -half; j < half; j++ {
+			o := m + j
+			if o < 0 || o >= n {
+				continue
+			}
+			win := 0.5 + 0.5*math.Cos(math.Pi*float64(j)/float64(half))
+			out[o] += interpolate(s, float64(a)+float64(j)*formant) * win
+			weights[o] += win
+		}
+		m += step
+	}
+	for i := range out {
+		if weights[i] > 0.1 {
+			out[i] /= weights[i]
+		}
+	}
+
+	breathiness(out, rate, FlagRegistryPSOLA.Value(flags, "B"))
+	gain := r.Volume / 100 * FlagRegistryPSOLA.Value(flags, "Y") / 100
+	for i := range out {
+		out[i] *= gain
+	}
+	return &Wave{SampleRate: rate, BitsPerSample: 16, Channels: [][]float64{out}}, nil
+}
+
+func interpolate(s []float64, x float64) float64 {
+	i := int(math.Floor(x))
+	if i < 0 || i+1 >= len(s) {
+		return 0
+	}
+	f := x - float64(i)
+	return s[i]*(1-f) + s[i+1]*f
+}
+
+// breathiness adds noise following the envelope when b is over 50,
+// and smooths the signal when b is under 50.
+func breathiness(s []float64, rate int, b float64) {
+	if b > 50 {
+		amount := math.Min((b-50)/50, 1)
+		r := rand.New(rand.NewSource(1))
+		frame := rate / 100
+		env, prev := 0.0, 0.0
+		for i := range s {
+			if i%frame == 0 {
+				sum := 0.0
+				for j := i; j < i+frame && j < len(s); j++ {
+					sum += s[j] * s[j]
+				}
+				env = math.Sqrt(sum / float64(frame))
+			}
+			noise := (r.Float64()*2 - 1) * env
+			s[i] += (noise - prev) * amount
+			prev = noise
+		}
+	} else if b < 50 {
+		amount := math.Min((50-b)/50, 1)
+		smoothed := 0.0
+		for i := range s {
+			smoothed += (s[i] - smoothed) * 0.3
+			s[i] = s[i]*(1-amount) + smoothed*amount
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testPSOLAPhoneme = &Phoneme{Filename: "a.wav", Alias: "あ", LeftBlank: 100, Consonant: 50, RightBlank: -340, PreUtterance: 50, Overlap: 10}
+
+func rms(s []float64) float64 {
+	sum := 0.0
+	for _, v := range s {
+		sum += v * v
+	}
+	return math.Sqrt(sum / float64(len(s)))
+}
+
+func TestNoteFrequency(t *testing.T) {
+	assert.Equal(t, 440.0, NoteFrequency(69))
+	assert.InDelta(t, 261.63, NoteFrequency(60), 0.01)
+}
+
+func TestResampleWaveShiftsPitchAndStretches(t *testing.T) {
+	r := NewResampleRequest("a.wav", testPSOLAPhoneme, 60, 800)
+	w, err := ResampleWave(context.Background(), newTestWave(), r)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 44100*800/1000, w.Len())
+	s := w.Channels[0]
+	assert.InDelta(t, NoteFrequency(60), estimatePitch(s[44100*400/1000:44100*450/1000], 44100), 3)
+	assert.InDelta(t, NoteFrequency(60), estimatePitch(s[44100*700/1000:44100*750/1000], 44100), 3)
+
+	r.PitchBend = []int{1200}
+	w, err = ResampleWave(context.Background(), newTestWave(), r)
+	assert.Equal(t, nil, err)
+	assert.InDelta(t, NoteFrequency(72), estimatePitch(w.Channels[0][44100*400/1000:44100*450/1000], 44100), 6)
+}
+
+func TestResampleWaveAppliesVelocity(t *testing.T) {
+	r := NewResampleRequest("a.wav", testPSOLAPhoneme, 57, 400)
+	r.Velocity = 0
+	w, err := ResampleWave(context.Background(), newTestWave(), r)
+	assert.Equal(t, nil, err)
+	// The consonant is stretched to 100ms, so the vowel starts after it.
+	s := w.Channels[0]
+	assert.Equal(t, 0.0, estimatePitch(s[44100*20/1000:44100*70/1000], 44100))
+	assert.InDelta(t, 220, estimatePitch(s[44100*150/1000:44100*200/1000], 44100), 2)
+}
+
+func TestResampleWaveAppliesFlags(t *testing.T) {
+	r := NewResampleRequest("a.wav", testPSOLAPhoneme, 57, 400)
+	plain, _ := ResampleWave(context.Background(), newTestWave(), r)
+	r.Flags = "Y50"
+	quiet, _ := ResampleWave(context.Background(), newTestWave(), r)
+	assert.InDelta(t, rms(plain.Channels[0])/2, rms(quiet.Channels[0]), 1e-9)
+	r.Flags = "B100"
+	breathy, _ := ResampleWave(context.Background(), newTestWave(), r)
+	assert.NotEqual(t, plain.Channels[0], breathy.Channels[0])
+	r.Flags = "g50"
+	gender, _ := ResampleWave(context.Background(), newTestWave(), r)
+	assert.NotEqual(t, plain.Channels[0], gender.Channels[0])
+	assert.InDelta(t, 220, estimatePitch(gender.Channels[0][44100*200/1000:44100*250/1000], 44100), 3)
+}
+
+func TestFailedCasesOfResampleWave(t *testing.T) {
+	r := NewResampleRequest("a.wav", &Phoneme{LeftBlank: 600}, 60, 400)
+	_, err := ResampleWave(context.Background(), newTestWave(), r)
+	assert.Error(t, err)
+	_, err = ResampleWave(context.Background(), NewWave(44100, 16, 1, 0), r)
+	assert.Error(t, err)
+	for i, tc := range []*ResampleRequest{
+		NewResampleRequest("a.wav", testPSOLAPhoneme, 60, -1),
+		NewResampleRequest("a.wav", testPSOLAPhoneme, 60, math.NaN()),
+		NewResampleRequest("a.wav", &Phoneme{Consonant: -10}, 60, 400),
+		NewResampleRequest("a.wav", &Phoneme{Consonant: 500}, 60, 400),
+	} {
+		t.Logf("Test case %v.; Length `%v` and Consonant `%v` cannot be resampled.", i+1, tc.Length, tc.Consonant)
+		_, err = ResampleWave(context.Background(), newTestWave(), tc)
+		assert.Error(t, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = ResampleWave(ctx, newTestWave(), NewResampleRequest("a.wav", testPSOLAPhoneme, 60, 400))
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestPSOLAResamplerReadsInput(t *testing.T) {
+	mockedWaveReader := new(waveReaderMock)
+	mockedWaveReader.On("Read", "a.wav").Return(newTestWave(), nil)
+	mockedWaveReader.On("Read", "b.wav").Return((*Wave)(nil), errors.New("FAILED"))
+	sut := psolaResampler{wr: mockedWaveReader}
+	w, err := sut.Resample(context.Background(), NewResampleRequest("a.wav", testPSOLAPhoneme, 60, 400))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 44100*400/1000, w.Len())
+	_, err = sut.Resample(context.Background(), NewResampleRequest("b.wav", testPSOLAPhoneme, 60, 400))
+	assert.Error(t, err)
+}
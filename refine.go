@@ -0,0 +1,270 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"math"
+	"sort"
+)
+
+// RefineOptions configures how Phoneme timings are snapped to the features of the sample.
+type RefineOptions struct {
+	// Window is how far in milliseconds a value may move.
+	Window float64 `json:"window"`
+	// FrameLength is the length in milliseconds of a single analysis frame.
+	FrameLength float64 `json:"frame_length"`
+	// VoicedThreshold is the minimum RMS relative to the loudest frame for a frame to be voiced.
+	VoicedThreshold float64 `json:"voiced_threshold"`
+	// MaxZeroCrossingRate is the maximum zero crossing rate per sample for a frame to be voiced.
+	MaxZeroCrossingRate float64 `json:"max_zero_crossing_rate"`
+	// StableRatio is the minimum RMS relative to the median of the voiced run for voicing to be stable.
+	StableRatio float64 `json:"stable_ratio"`
+	// DryRun leaves the Phonemes untouched and only reports the refinements.
+	DryRun bool `json:"dry_run"`
+}
+
+// DefaultRefineOptions returns RefineOptions that work for most of voicebanks.
+func DefaultRefineOptions() RefineOptions {
+	return RefineOptions{
+		Window:              30,
+		FrameLength:         10,
+		VoicedThreshold:     0.1,
+		MaxZeroCrossingRate: 0.25,
+		StableRatio:         0.5,
+	}
+}
+
+// Refinement records how a single Phoneme is moved by the refinement.
+// Deltas are the distances in milliseconds the absolute positions moved.
+type Refinement struct {
+	OtoChange
+	LeftBlankDelta    float64 `json:"left_blank_delta"`
+	PreUtteranceDelta float64 `json:"pre_utterance_delta"`
+	RightBlankDelta   float64 `json:"right_blank_delta"`
+	// Error is why the sample could not be read, in which case the Phoneme is left as is.
+	Error string `json:"error,omitempty"`
+}
+
+// RefinePhoneme snaps LeftBlank to the nearest zero crossing, PreUtterance to the vowel onset
+// and RightBlank to the end of stable voicing of the given Wave.
+// The given Phoneme is not modified.
+func RefinePhoneme(p *Phoneme, w *Wave, o RefineOptions) *Refinement {
+	before := *p
+	after := *p
+	r := &Refinement{OtoChange: OtoChange{Phoneme: p, Before: before, After: after}}
+	if w.Len() == 0 || w.SampleRate == 0 {
+		return r
+	}
+	s := w.Mono()
+	_, end := before.Region(w.Duration())
+	pre := before.LeftBlank + before.PreUtterance
+
+	left := w.FrameToMs(nearestZeroCrossing(s, w.MsToFrame(before.LeftBlank), w.MsToFrame(o.Window)))
+
+	a := newFrameAnalysis(s, w.SampleRate, o.FrameLength)
+	voiced := a.voiced(o.VoicedThreshold, o.MaxZeroCrossingRate)
+	onset := -1
+	for k := 1; k < len(voiced); k++ {
+		if !voiced[k] || voiced[k-1] {
+			continue
+		}
+		t := a.time(k)
+		if math.Abs(t-pre) > o.Window {
+			continue
+		}
+		if onset < 0 || math.Abs(t-pre) < math.Abs(a.time(onset)-pre) {
+			onset = k
+		}
+	}
+	newPre := pre
+	if onset >= 0 {
+		newPre = a.time(onset)
+	} else {
+		onset = a.frame(pre)
+	}
+	newEnd := end
+	if e, ok := a.stableEnd(voiced, onset, o.StableRatio); ok && e > newPre {
+		newEnd = e
+	}
+	if left > newPre {
+		left = before.LeftBlank
+	}
+
+	after.LeftBlank = left
+	after.Consonant = math.Max(before.LeftBlank+before.Consonant-left, 0)
+	after.PreUtterance = newPre - left
+	after.Overlap = before.LeftBlank + before.Overlap - left
+	if before.RightBlank < 0 {
+		after.RightBlank = -(newEnd - left)
+	} else {
+		after.RightBlank = w.Duration() - newEnd
+	}
+	after.LeftBlank = roundMs(after.LeftBlank)
+	after.Consonant = roundMs(after.Consonant)
+	after.PreUtterance = roundMs(after.PreUtterance)
+	after.Overlap = roundMs(after.Overlap)
+	after.RightBlank = roundMs(after.RightBlank)
+
+	r.After = after
+	r.LeftBlankDelta = after.LeftBlank - before.LeftBlank
+	r.PreUtteranceDelta = (after.LeftBlank + after.PreUtterance) - pre
+	_, e := after.Region(w.Duration())
+	r.RightBlankDelta = e - end
+	return r
+}
+
+func roundMs(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
+
+func nearestZeroCrossing(s []float64, center int, window int) int {
+	best := -1
+	for d := 0; d <= window; d++ {
+		for _, i := range []int{center - d, center + d} {
+			if i <= 0 || i >= len(s) {
+				continue
+			}
+			if (s[i-1] < 0) != (s[i] < 0) {
+				best = i
+				break
+			}
+		}
+		if best >= 0 {
+			return best
+		}
+	}
+	return clampInt(center, 0, len(s))
+}
+
+type frameAnalysis struct {
+	hop  int
+	rate int
+	rms  []float64
+	zcr  []float64
+}
+
+func newFrameAnalysis(s []float64, rate int, frameLength float64) *frameAnalysis {
+	size := int(frameLength * float64(rate) / 1000)
+	if size < 2 {
+		size = 2
+	}
+	hop := size / 2
+	a := &frameAnalysis{hop: hop, rate: rate}
+	for start := 0; start+size <= len(s); start += hop {
+		sum, crossings := 0.0, 0
+		for i := start; i < start+size; i++ {
+			sum += s[i] * s[i]
+			if i > start && (s[i-1] < 0) != (s[i] < 0) {
+				crossings++
+			}
+		}
+		a.rms = append(a.rms, math.Sqrt(sum/float64(size)))
+		a.zcr = append(a.zcr, float64(crossings)/float64(size-1))
+	}
+	return a
+}
+
+func (a *frameAnalysis) time(k int) float64 {
+	return float64(k*a.hop) * 1000 / float64(a.rate)
+}
+
+func (a *frameAnalysis) frame(ms float64) int {
+	return clampInt(int(ms*float64(a.rate)/1000)/a.hop, 0, len(a.rms))
+}
+
+func (a *frameAnalysis) voiced(threshold float64, maxZcr float64) []bool {
+	max := 0.0
+	for _, v := range a.rms {
+		max = math.Max(max, v)
+	}
+	res := make([]bool, len(a.rms))
+	for k := range a.rms {
+		res[k] = max > 0 && a.rms[k] >= threshold*max && a.zcr[k] <= maxZcr
+	}
+	return res
+}
+
+// stableEnd returns the end of the voiced run that starts at the given frame,
+// ignoring the decaying tail whose energy falls below ratio of the median.
+func (a *frameAnalysis) stableEnd(voiced []bool, start int, ratio float64) (float64, bool) {
+	for start < len(voiced) && !voiced[start] {
+		start++
+	}
+	if start >= len(voiced) {
+		return 0, false
+	}
+	last := start
+	for last+1 < len(voiced) && voiced[last+1] {
+		last++
+	}
+	rs := append([]float64{}, a.rms[start:last+1]...)
+	sort.Float64s(rs)
+	median := rs[len(rs)/2]
+	for last > start && a.rms[last] < ratio*median {
+		last--
+	}
+	return a.time(last + 1), true
+}
+
+// VoicebankRefiner refines all Phonemes in a Voicebank against their samples.
+type VoicebankRefiner interface {
+	Refine(*Voicebank) ([]*Refinement, error)
+}
+
+type voicebankRefinerDefault struct {
+	wr WaveReader
+	o  RefineOptions
+}
+
+// NewVoicebankRefiner creates a VoicebankRefiner that reads samples from filesystem.
+func NewVoicebankRefiner(o RefineOptions) VoicebankRefiner {
+	return voicebankRefinerDefault{wr: NewWaveReader(), o: o}
+}
+
+// Refine all Phonemes in the Voicebank.
+// Phonemes are updated in place unless RefineOptions.DryRun is set.
+// Phonemes whose samples cannot be read are reported with Refinement.Error and left as is.
+func (vr voicebankRefinerDefault) Refine(vb *Voicebank) ([]*Refinement, error) {
+	res := []*Refinement{}
+	for _, sub := range sortedSubfolders(vb) {
+		waves := map[string]*Wave{}
+		errs := map[string]error{}
+		for _, p := range *vb.PhonemesMap[sub] {
+			path := vb.SamplePath(sub, p)
+			w, ok := waves[path]
+			err := errs[path]
+			if !ok && err == nil {
+				if w, err = vr.wr.Read(path); err != nil {
+					errs[path] = err
+				} else {
+					waves[path] = w
+				}
+			}
+			if err != nil {
+				r := &Refinement{OtoChange: OtoChange{Subfolder: sub, Phoneme: p, Before: *p, After: *p}, Error: err.Error()}
+				res = append(res, r)
+				continue
+			}
+			r := RefinePhoneme(p, w, vr.o)
+			r.Subfolder = sub
+			res = append(res, r)
+		}
+	}
+	if !vr.o.DryRun {
+		for _, r := range res {
+			r.Apply()
+		}
+	}
+	return res, nil
+}
+
+// FormatRefinements lists the oto.ini lines of the changed Refinements by FormatOtoChanges.
+func FormatRefinements(rs []*Refinement) string {
+	cs := make([]*OtoChange, len(rs))
+	for i, r := range rs {
+		cs[i] = &r.OtoChange
+	}
+	return FormatOtoChanges(cs)
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type waveReaderMock struct {
+	mock.Mock
+}
+
+func (m *waveReaderMock) Read(fn string) (*Wave, error) {
+	args := m.Called(fn)
+	return args.Get(0).(*Wave), args.Error(1)
+}
+
+func TestRefinePhonemeSnapsToFeatures(t *testing.T) {
+	w := newTestWave()
+	p := &Phoneme{Filename: "ka.wav", Alias: "か", LeftBlank: 95, Consonant: 70, RightBlank: -400, PreUtterance: 70, Overlap: 20}
+	r := RefinePhoneme(p, w, DefaultRefineOptions())
+	assert.True(t, r.Changed())
+	assert.Equal(t, 95.0, p.LeftBlank, "RefinePhoneme should not modify the given Phoneme.")
+	assert.InDelta(t, 150, r.After.LeftBlank+r.After.PreUtterance, 5)
+	assert.InDelta(t, 450, r.After.LeftBlank-r.After.RightBlank, 10)
+	assert.InDelta(t, 115, r.After.LeftBlank+r.After.Overlap, 0.001, "Overlap should stay at the same absolute position.")
+	assert.True(t, r.After.RightBlank < 0, "RightBlank should keep its sign convention.")
+	assert.InDelta(t, r.PreUtteranceDelta, (r.After.LeftBlank+r.After.PreUtterance)-165, 0.001)
+}
+
+func TestRefinePhonemeKeepsValuesOutsideOfWindow(t *testing.T) {
+	w := newTestWave()
+	p := &Phoneme{Filename: "ka.wav", Alias: "か", LeftBlank: 0, Consonant: 10, RightBlank: 0, PreUtterance: 10, Overlap: 0}
+	o := DefaultRefineOptions()
+	o.Window = 5
+	r := RefinePhoneme(p, w, o)
+	assert.Equal(t, 10.0, r.After.LeftBlank+r.After.PreUtterance)
+}
+
+func TestVoicebankRefinerRefinesAndFormatsDiff(t *testing.T) {
+	mockedWaveReader := new(waveReaderMock)
+	p := &Phoneme{Filename: "ka.wav", Alias: "か", LeftBlank: 95, Consonant: 70, RightBlank: -400, PreUtterance: 70, Overlap: 20}
+	vb := &Voicebank{Path: "vb", PhonemesMap: map[string]*Phonemes{"C4": {p}}}
+	mockedWaveReader.On("Read", resolvePath(resolvePath("vb", "C4"), "ka.wav")).Return(newTestWave(), nil)
+	o := DefaultRefineOptions()
+	o.DryRun = true
+	sut := voicebankRefinerDefault{wr: mockedWaveReader, o: o}
+	rs, err := sut.Refine(vb)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(rs))
+	assert.Equal(t, 95.0, p.LeftBlank, "Dry run should not modify Phonemes.")
+	diff := FormatRefinements(rs)
+	assert.True(t, strings.HasPrefix(diff, "--- a/C4/oto.ini\n+++ b/C4/oto.ini\n-ka.wav=か,95,70,-400,70,20\n+ka.wav=か,"))
+
+	sut.o.DryRun = false
+	_, err = sut.Refine(vb)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, rs[0].After, *p)
+}
+
+func TestVoicebankRefinerReportsUnreadableSamples(t *testing.T) {
+	mockedWaveReader := new(waveReaderMock)
+	p := &Phoneme{Filename: "missing.wav", Alias: "あ", LeftBlank: 95}
+	q := &Phoneme{Filename: "missing.wav", Alias: "- あ", LeftBlank: 95}
+	vb := &Voicebank{Path: "vb", PhonemesMap: map[string]*Phonemes{"": {p, q}}}
+	mockedWaveReader.On("Read", resolvePath("vb", "missing.wav")).Return((*Wave)(nil), errors.New("FAILED")).Once()
+	sut := voicebankRefinerDefault{wr: mockedWaveReader, o: DefaultRefineOptions()}
+	rs, err := sut.Refine(vb)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(rs))
+	assert.Equal(t, "FAILED", rs[0].Error)
+	assert.Equal(t, "FAILED", rs[1].Error)
+	assert.False(t, rs[0].Changed())
+	assert.Equal(t, 95.0, p.LeftBlank)
+	assert.Equal(t, "", FormatRefinements(rs))
+	mockedWaveReader.AssertExpectations(t)
+}
@@ -0,0 +1,265 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// RenderCacheKey returns the key of the resampled output of the request.
+// It covers every parameter but Output, so an edit of a note changes only the keys of the segments it affects.
+// The sample is identified by its path, and by its size and modification time given its os.FileInfo,
+// so that a sample recorded again or normalized is resampled again. The info is nil when it is unknown.
+func RenderCacheKey(r *ResampleRequest, sample os.FileInfo) string {
+	req := *r
+	req.Output = ""
+	key := struct {
+		Request *ResampleRequest `json:"request"`
+		Size    int64            `json:"size"`
+		ModTime int64            `json:"mod_time"`
+	}{Request: &req}
+	if sample != nil {
+		key.Size, key.ModTime = sample.Size(), sample.ModTime().UnixNano()
+	}
+	bs, _ := json.Marshal(&key)
+	sum := sha256.Sum256(bs)
+	return hex.EncodeToString(sum[:])
+}
+
+// RenderCache stores resampled segments by RenderCacheKey.
+// Implementations must be safe for concurrent use.
+type RenderCache interface {
+	Get(key string) (*Wave, bool)
+	Put(key string, w *Wave) error
+}
+
+type memoryRenderCache struct {
+	mu    sync.Mutex
+	waves map[string]*Wave
+}
+
+// NewMemoryRenderCache creates a RenderCache that keeps segments in memory.
+func NewMemoryRenderCache() RenderCache {
+	return &memoryRenderCache{waves: map[string]*Wave{}}
+}
+
+func (c *memoryRenderCache) Get(key string) (*Wave, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w, ok := c.waves[key]
+	return w, ok
+}
+
+func (c *memoryRenderCache) Put(key string, w *Wave) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.waves[key] = w
+	return nil
+}
+
+type fileRenderCache struct {
+	dir string
+	wr  WaveReader
+	fw  fileWriter
+}
+
+// NewFileRenderCache creates a RenderCache that keeps segments as `key.wav` in the directory,
+// which survives across runs.
+func NewFileRenderCache(dir string) RenderCache {
+	return fileRenderCache{dir: dir, wr: NewWaveReader(), fw: fileWriterDefault{}}
+}
+
+func (c fileRenderCache) Get(key string) (*Wave, bool) {
+	w, err := c.wr.Read(filepath.Join(c.dir, key+".wav"))
+	if err != nil {
+		return nil, false
+	}
+	return w, true
+}
+
+func (c fileRenderCache) Put(key string, w *Wave) error {
+	bs, err := w.Bytes()
+	if err != nil {
+		return err
+	}
+	return c.fw.Write(filepath.Join(c.dir, key+".wav"), string(bs))
+}
+
+// RenderProgress tells a segment has been resampled or taken from the cache.
+type RenderProgress struct {
+	Done    int      `json:"done"`
+	Total   int      `json:"total"`
+	Segment *Segment `json:"segment"`
+	Cached  bool     `json:"cached"`
+}
+
+// RenderOptions configures Renderer.
+type RenderOptions struct {
+	// Workers is the number of resampler jobs run at once. Zero means the number of CPUs.
+	Workers int
+	// Cache is nil when segments are not cached.
+	Cache RenderCache
+	// Progress is called after each segment, one call at a time.
+	Progress func(RenderProgress)
+}
+
+// DefaultRenderOptions returns RenderOptions with a cache in memory.
+func DefaultRenderOptions() RenderOptions {
+	return RenderOptions{Cache: NewMemoryRenderCache()}
+}
+
+// RenderResult is a rendered track.
+type RenderResult struct {
+	Wave *Wave `json:"-"`
+	Plan *Plan `json:"plan"`
+	// Resampled and Cached are the numbers of segments resampled and taken from the cache.
+	Resampled int `json:"resampled"`
+	Cached    int `json:"cached"`
+}
+
+// Renderer renders a Project into a track.
+type Renderer interface {
+	Render(ctx context.Context, p *Project) (*RenderResult, error)
+}
+
+type rendererDefault struct {
+	vb *Voicebank
+	rs Resampler
+	st fileStater
+	o  RenderOptions
+}
+
+// NewRenderer creates a Renderer that resolves notes by the Voicebank and resamples them by the Resampler.
+func NewRenderer(vb *Voicebank, rs Resampler, o RenderOptions) Renderer {
+	return rendererDefault{vb: vb, rs: rs, st: fileStaterDefault{}, o: o}
+}
+
+type renderJob struct {
+	segment *Segment
+	request *ResampleRequest
+	key     string
+}
+
+// Render plans the notes, resamples the segments missing in the cache in parallel,
+// and concatenates them as wavtool does. The first error cancels the rest of the jobs.
+func (rd rendererDefault) Render(ctx context.Context, p *Project) (*RenderResult, error) {
+	plan, err := NewPlanner(rd.vb).Plan(p.Notes, p.Tempo)
+	if err != nil {
+		return nil, err
+	}
+	res := &RenderResult{Plan: plan}
+	waves := make([]*Wave, len(plan.Segments))
+	jobs := []*renderJob{}
+	for _, s := range plan.Segments {
+		if s.IsSilent() || s.Length <= 0 {
+			continue
+		}
+		r, err := NewResampleRequestFromSegment(s, p.Flags)
+		if err != nil {
+			return nil, err
+		}
+		info, err := rd.st.Stat(r.Input)
+		if err != nil {
+			info = nil
+		}
+		jobs = append(jobs, &renderJob{segment: s, request: r, key: RenderCacheKey(r, info)})
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	workers := rd.o.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	queue := make(chan *renderJob)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var firstErr error
+	done := 0
+	finish := func(j *renderJob, w *Wave, cached bool, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+				cancel()
+			}
+			return
+		}
+		waves[j.segment.Index] = w
+		done++
+		if cached {
+			res.Cached++
+		} else {
+			res.Resampled++
+		}
+		if rd.o.Progress != nil {
+			rd.o.Progress(RenderProgress{Done: done, Total: len(jobs), Segment: j.segment, Cached: cached})
+		}
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				w, cached, err := rd.resample(ctx, j)
+				finish(j, w, cached, err)
+			}
+		}()
+	}
+	for _, j := range jobs {
+		select {
+		case queue <- j:
+		case <-ctx.Done():
+		}
+	}
+	close(queue)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c := NewConcatenator(WavtoolSampleRate)
+	for _, s := range plan.Segments {
+		c.AppendRequest(waves[s.Index], NewWavtoolRequestFromSegment(s, "", ""))
+	}
+	res.Wave = c.Wave
+	return res, nil
+}
+
+func (rd rendererDefault) resample(ctx context.Context, j *renderJob) (*Wave, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	if rd.o.Cache != nil {
+		if w, ok := rd.o.Cache.Get(j.key); ok {
+			return w, true, nil
+		}
+	}
+	w, err := rd.rs.Resample(ctx, j.request)
+	if err != nil {
+		return nil, false, err
+	}
+	if w == nil {
+		return nil, false, errors.New("The resampler returned no wave for `" + j.request.Input + "`")
+	}
+	if rd.o.Cache != nil {
+		if err := rd.o.Cache.Put(j.key, w); err != nil {
+			return nil, false, err
+		}
+	}
+	return w, false, nil
+}
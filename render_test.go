@@ -0,0 +1,165 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// constantResampler writes a constant of the request length and counts the requests.
+type constantResampler struct {
+	mu       sync.Mutex
+	requests []*ResampleRequest
+	fail     string
+	wait     time.Duration
+}
+
+func (cr *constantResampler) Resample(ctx context.Context, r *ResampleRequest) (*Wave, error) {
+	cr.mu.Lock()
+	cr.requests = append(cr.requests, r)
+	cr.mu.Unlock()
+	if cr.wait > 0 {
+		select {
+		case <-time.After(cr.wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if cr.fail != "" && r.Input == cr.fail {
+		return nil, errors.New("FAILED")
+	}
+	return newConstantWave(WavtoolSampleRate, 0.25, r.Length), nil
+}
+
+func TestRenderCacheKey(t *testing.T) {
+	r := NewResampleRequest("a.wav", &Phoneme{LeftBlank: 100}, 60, 500)
+	key := RenderCacheKey(r, nil)
+	assert.Equal(t, 64, len(key))
+	r.Output = "out.wav"
+	assert.Equal(t, key, RenderCacheKey(r, nil), "Output should not change the key.")
+	r.PitchBend = []int{1}
+	assert.NotEqual(t, key, RenderCacheKey(r, nil))
+	r.PitchBend = []int{}
+	r.Flags = "g-5"
+	assert.NotEqual(t, key, RenderCacheKey(r, nil))
+	key = RenderCacheKey(r, nil)
+	info := dummyFileInfo{n: "a.wav", s: 1000, t: time.Unix(1600000000, 0)}
+	assert.NotEqual(t, key, RenderCacheKey(r, info))
+	assert.Equal(t, RenderCacheKey(r, info), RenderCacheKey(r, dummyFileInfo{n: "a.wav", s: 1000, t: time.Unix(1600000000, 0)}))
+	assert.NotEqual(t, RenderCacheKey(r, info), RenderCacheKey(r, dummyFileInfo{n: "a.wav", s: 1000, t: time.Unix(1600000001, 0)}), "A modified sample should change the key.")
+	assert.NotEqual(t, RenderCacheKey(r, info), RenderCacheKey(r, dummyFileInfo{n: "a.wav", s: 1002, t: time.Unix(1600000000, 0)}), "A resized sample should change the key.")
+}
+
+func TestRendererRendersAndCaches(t *testing.T) {
+	rs := &constantResampler{}
+	progress := []RenderProgress{}
+	o := DefaultRenderOptions()
+	o.Workers = 2
+	o.Progress = func(p RenderProgress) { progress = append(progress, p) }
+	sut := NewRenderer(testPlannerVoicebank, rs, o)
+	p := newTestProject("R あ か あ", 60, 60, 62, 64)
+
+	res, err := sut.Render(context.Background(), p)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, res.Resampled)
+	assert.Equal(t, 0, res.Cached)
+	assert.Equal(t, 3, len(rs.requests))
+	assert.Equal(t, 3, len(progress))
+	assert.Equal(t, 3, progress[2].Done)
+	assert.Equal(t, 3, progress[2].Total)
+	for _, r := range rs.requests {
+		assert.Equal(t, "g-5", r.Flags)
+	}
+	ss := res.Plan.Segments
+	c := NewConcatenator(WavtoolSampleRate)
+	for _, s := range ss {
+		c.Append(nil, 0, s.Length, s.Overlap, nil)
+	}
+	assert.Equal(t, c.Wave.Len(), res.Wave.Len())
+	assert.Equal(t, 0.0, res.Wave.Channels[0][0])
+	middle := res.Wave.MsToFrame(ss[0].Length + ss[1].Length/2)
+	assert.InDelta(t, 0.25, res.Wave.Channels[0][middle], 1e-9)
+
+	p.Notes[2].Flags = "B40"
+	progress = progress[:0]
+	res, err = sut.Render(context.Background(), p)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, res.Resampled, "Only the edited note should be resampled.")
+	assert.Equal(t, 2, res.Cached)
+	assert.Equal(t, "g-5B40", rs.requests[3].Flags)
+	assert.Equal(t, 3, len(progress))
+}
+
+func TestRendererRendersModifiedSamplesAgain(t *testing.T) {
+	rs := &constantResampler{}
+	mockedFileStater := new(fileStaterMock)
+	a := resolvePath("vb", "a.wav")
+	mockedFileStater.On("Stat", a).Return(dummyFileInfo{n: "a.wav", s: 1000, t: time.Unix(1600000000, 0)}, nil).Twice()
+	mockedFileStater.On("Stat", a).Return(dummyFileInfo{n: "a.wav", s: 1000, t: time.Unix(1600000100, 0)}, nil)
+	mockedFileStater.On("Stat", mock.Anything).Return(nil, os.ErrNotExist)
+	sut := rendererDefault{vb: testPlannerVoicebank, rs: rs, st: mockedFileStater, o: RenderOptions{Workers: 1, Cache: NewMemoryRenderCache()}}
+
+	res, err := sut.Render(context.Background(), newTestProject("R あ か あ", 60, 60, 62, 64))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, res.Resampled)
+	res, err = sut.Render(context.Background(), newTestProject("R あ か あ", 60, 60, 62, 64))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, res.Resampled, "Notes of the modified sample should be resampled again.")
+	assert.Equal(t, 1, res.Cached)
+}
+
+func TestRendererWithoutCache(t *testing.T) {
+	rs := &constantResampler{}
+	sut := NewRenderer(testPlannerVoicebank, rs, RenderOptions{})
+	for i := 0; i < 2; i++ {
+		res, err := sut.Render(context.Background(), newTestProject("R あ か あ", 60, 60, 62, 64))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 3, res.Resampled)
+	}
+	assert.Equal(t, 6, len(rs.requests))
+}
+
+func TestFailedCasesOfRenderer(t *testing.T) {
+	rs := &constantResampler{fail: resolvePath("vb", "ka.wav")}
+	_, err := NewRenderer(testPlannerVoicebank, rs, RenderOptions{Workers: 1}).Render(context.Background(), newTestProject("R あ か あ", 60, 60, 62, 64))
+	assert.Equal(t, errors.New("FAILED"), err)
+
+	p := newTestProject("R あ か あ", 60, 60, 62, 64)
+	p.Tempo = 0
+	_, err = NewRenderer(testPlannerVoicebank, rs, RenderOptions{}).Render(context.Background(), p)
+	assert.Error(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = NewRenderer(testPlannerVoicebank, &constantResampler{wait: time.Second}, RenderOptions{Workers: 1}).Render(ctx, newTestProject("R あ か あ", 60, 60, 62, 64))
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestFileRenderCache(t *testing.T) {
+	mockedWaveReader := new(waveReaderMock)
+	mockedFileWriter := new(fileWriterMock)
+	w := newConstantWave(1000, 0.5, 10)
+	mockedWaveReader.On("Read", resolvePath("cache", "k1.wav")).Return(w, nil)
+	mockedWaveReader.On("Read", resolvePath("cache", "k2.wav")).Return((*Wave)(nil), errors.New("FAILED"))
+	mockedFileWriter.On("Write", resolvePath("cache", "k2.wav"), mock.Anything).Return(nil)
+	sut := fileRenderCache{dir: "cache", wr: mockedWaveReader, fw: mockedFileWriter}
+	res, ok := sut.Get("k1")
+	assert.True(t, ok)
+	assert.Equal(t, w, res)
+	_, ok = sut.Get("k2")
+	assert.False(t, ok)
+	assert.Equal(t, nil, sut.Put("k2", w))
+	bs, _ := w.Bytes()
+	mockedFileWriter.AssertCalled(t, "Write", resolvePath("cache", "k2.wav"), string(bs))
+	assert.Error(t, sut.Put("k3", &Wave{BitsPerSample: 12}))
+}
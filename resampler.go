@@ -0,0 +1,211 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const pitchBendAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// EncodePitchBend encodes pitch bend in cents into UTAU's base64 format.
+// Each value takes two characters of 12 bit two's complement,
+// and a run of the same value is shortened as `#count#` after the first one.
+func EncodePitchBend(cents []int) string {
+	var b strings.Builder
+	for i := 0; i < len(cents); {
+		v := clampInt(cents[i], -2048, 2047) & 0xFFF
+		b.WriteByte(pitchBendAlphabet[v>>6])
+		b.WriteByte(pitchBendAlphabet[v&0x3F])
+		j := i + 1
+		for j < len(cents) && clampInt(cents[j], -2048, 2047) == clampInt(cents[i], -2048, 2047) {
+			j++
+		}
+		if j-i > 1 {
+			b.WriteString("#" + strconv.Itoa(j-i-1) + "#")
+		}
+		i = j
+	}
+	return b.String()
+}
+
+// DecodePitchBend decodes UTAU's base64 pitch bend into cents.
+func DecodePitchBend(s string) ([]int, error) {
+	res := []int{}
+	for i := 0; i < len(s); {
+		if s[i] == '#' {
+			end := strings.IndexByte(s[i+1:], '#')
+			if end < 0 || len(res) == 0 {
+				return nil, errors.New("The given pitch bend has an invalid run; `" + s + "`")
+			}
+			n, err := strconv.Atoi(s[i+1 : i+1+end])
+			if err != nil {
+				return nil, errors.New("The given pitch bend has an invalid run; `" + s + "`")
+			}
+			for k := 0; k < n; k++ {
+				res = append(res, res[len(res)-1])
+			}
+			i += end + 2
+			continue
+		}
+		if i+1 >= len(s) {
+			return nil, errors.New("The given pitch bend is truncated; `" + s + "`")
+		}
+		hi := strings.IndexByte(pitchBendAlphabet, s[i])
+		lo := strings.IndexByte(pitchBendAlphabet, s[i+1])
+		if hi < 0 || lo < 0 {
+			return nil, errors.New("The given pitch bend has an invalid character; `" + s + "`")
+		}
+		v := hi<<6 | lo
+		if v >= 2048 {
+			v -= 4096
+		}
+		res = append(res, v)
+		i += 2
+	}
+	return res, nil
+}
+
+// ResampleRequest is the set of parameters UTAU passes to a resampler.
+// Times are in milliseconds.
+type ResampleRequest struct {
+	Input    string  `json:"input"`
+	Output   string  `json:"output"`
+	NoteNum  int     `json:"note_num"`
+	Velocity float64 `json:"velocity"`
+	Flags    string  `json:"flags"`
+	// Offset, Consonant and Cutoff are LeftBlank, Consonant and RightBlank of the Phoneme.
+	Offset    float64 `json:"offset"`
+	Length    float64 `json:"length"`
+	Consonant float64 `json:"consonant"`
+	Cutoff    float64 `json:"cutoff"`
+	// Volume and Modulation are in percent.
+	Volume     float64 `json:"volume"`
+	Modulation float64 `json:"modulation"`
+	Tempo      float64 `json:"tempo"`
+	// PitchBend is the pitch in cents relative to NoteNum, sampled every PitchBendTicks.
+	PitchBend []int `json:"pitch_bend"`
+}
+
+// NewResampleRequest creates ResampleRequest for the region of the Phoneme in the sample
+// with the default velocity, volume, modulation and tempo of UTAU.
+func NewResampleRequest(input string, p *Phoneme, noteNum int, length float64) *ResampleRequest {
+	return &ResampleRequest{
+		Input:      input,
+		NoteNum:    noteNum,
+		Velocity:   100,
+		Offset:     p.LeftBlank,
+		Length:     length,
+		Consonant:  p.Consonant,
+		Cutoff:     p.RightBlank,
+		Volume:     100,
+		Modulation: 0,
+		Tempo:      120,
+		PitchBend:  []int{},
+	}
+}
+
+// NewResampleRequestFromSegment creates ResampleRequest for a planned Segment.
+// Flags of the note are merged into flags of the project by MergeFlags,
+// and they are just joined when either of them cannot be parsed,
+// and the pitch bend is sampled from the pitch bend and the vibrato of the note.
+func NewResampleRequestFromSegment(s *Segment, projectFlags string) (*ResampleRequest, error) {
+	if s.IsSilent() {
+		return nil, errors.New("The given segment has no sample to resample")
+	}
+	r := NewResampleRequest(s.SamplePath, s.Phoneme, s.Note.NoteNum, s.RequestLength)
+	r.Velocity = s.Velocity
+	r.Tempo = s.Tempo
+	r.Flags = projectFlags + s.Note.Flags
+	if pf, err := ParseFlags(projectFlags); err == nil {
+		if nf, err := ParseFlags(s.Note.Flags); err == nil {
+			r.Flags = MergeFlags(pf, nf).String()
+		}
+	}
+	r.PitchBend = SegmentPitchCurve(s)
+	if s.Note.Intensity != nil {
+		r.Volume = *s.Note.Intensity
+	}
+	if s.Note.Modulation != nil {
+		r.Modulation = *s.Note.Modulation
+	}
+	return r, nil
+}
+
+// Args returns the command line arguments of UTAU's resampler protocol.
+func (r *ResampleRequest) Args() []string {
+	return []string{
+		r.Input,
+		r.Output,
+		NoteName(r.NoteNum),
+		formatFloat(r.Velocity),
+		r.Flags,
+		formatFloat(r.Offset),
+		strconv.Itoa(int(r.Length)),
+		formatFloat(r.Consonant),
+		formatFloat(r.Cutoff),
+		formatFloat(r.Volume),
+		formatFloat(r.Modulation),
+		"!" + formatFloat(r.Tempo),
+		EncodePitchBend(r.PitchBend),
+	}
+}
+
+// Resampler pitch-shifts and time-stretches a region of a sample.
+type Resampler interface {
+	Resample(context.Context, *ResampleRequest) (*Wave, error)
+}
+
+type externalResampler struct {
+	command string
+	args    []string
+	timeout time.Duration
+	wr      WaveReader
+}
+
+// NewExternalResampler creates a Resampler that runs an executable speaking UTAU's resampler protocol.
+// args are put before the protocol arguments, which allows running a Windows resampler by `wine resampler.exe`.
+// A timeout of zero means no timeout other than the context.
+func NewExternalResampler(command string, timeout time.Duration, args ...string) Resampler {
+	return externalResampler{command: command, args: args, timeout: timeout, wr: NewWaveReader()}
+}
+
+// Resample runs the executable and reads the output.
+// When Output of the request is empty, a temporary file is used and removed.
+func (er externalResampler) Resample(ctx context.Context, r *ResampleRequest) (*Wave, error) {
+	if er.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, er.timeout)
+		defer cancel()
+	}
+	req := *r
+	if req.Output == "" {
+		f, err := ioutil.TempFile("", "utau-resampler-*.wav")
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
+		defer os.Remove(f.Name())
+		req.Output = f.Name()
+	}
+	cmd := exec.CommandContext(ctx, er.command, append(append([]string{}, er.args...), req.Args()...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, errors.New("The resampler failed; " + err.Error() + "; " + strings.TrimSpace(stderr.String()))
+	}
+	return er.wr.Read(req.Output)
+}
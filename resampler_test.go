@@ -0,0 +1,137 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPitchBendRoundTrip(t *testing.T) {
+	type TestCase struct {
+		cents   []int
+		encoded string
+	}
+	for i, tc := range []TestCase{
+		{[]int{}, ""},
+		{[]int{0}, "AA"},
+		{[]int{0, 0, 0, 0}, "AA#3#"},
+		{[]int{1, -1, 2047, -2048}, "AB//f/gA"},
+		{[]int{-1, -1, 5, 5, 5, 0}, "//#1#AF#2#AA"},
+	} {
+		t.Logf("Test case %v.; `%v` should be encoded into `%v`.", i+1, tc.cents, tc.encoded)
+		assert.Equal(t, tc.encoded, EncodePitchBend(tc.cents))
+		actual, err := DecodePitchBend(tc.encoded)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, tc.cents, actual)
+	}
+	assert.Equal(t, "f/", EncodePitchBend([]int{3000}))
+}
+
+func TestFailedCasesOfDecodePitchBend(t *testing.T) {
+	for i, tc := range []string{"A", "#3#", "AA#3", "AA#x#", "A*"} {
+		t.Logf("Test case %v.; `%v` cannot be decoded.", i+1, tc)
+		_, err := DecodePitchBend(tc)
+		assert.Error(t, err)
+	}
+}
+
+func TestResampleRequestArgs(t *testing.T) {
+	p := &Phoneme{Filename: "a.wav", Alias: "あ", LeftBlank: 100, Consonant: 60.5, RightBlank: -500, PreUtterance: 60, Overlap: 20}
+	r := NewResampleRequest("in.wav", p, 60, 550)
+	r.Output = "out.wav"
+	r.Flags = "g-5"
+	r.PitchBend = []int{0, 0, 1}
+	assert.Equal(t, []string{"in.wav", "out.wav", "C4", "100", "g-5", "100", "550", "60.5", "-500", "100", "0", "!120", "AA#1#AB"}, r.Args())
+}
+
+func TestNewResampleRequestFromSegment(t *testing.T) {
+	plan, _ := NewPlanner(testPlannerVoicebank).Plan([]*Note{
+		{Length: 480, Lyric: "R"},
+		{Length: 480, Lyric: "か", NoteNum: 62, Intensity: float64Pointer(80), Modulation: float64Pointer(50), Flags: "B40"},
+	}, 150)
+	_, err := NewResampleRequestFromSegment(plan.Segments[0], "g-5")
+	assert.Error(t, err)
+	r, err := NewResampleRequestFromSegment(plan.Segments[1], "g-5")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, resolvePath("vb", "ka.wav"), r.Input)
+	assert.Equal(t, "g-5B40", r.Flags)
+	assert.Equal(t, 80.0, r.Volume)
+	assert.Equal(t, 50.0, r.Modulation)
+	assert.Equal(t, 150.0, r.Tempo)
+	assert.Equal(t, plan.Segments[1].RequestLength, r.Length)
+	assert.Equal(t, SegmentPitchCurve(plan.Segments[1]), r.PitchBend)
+	assert.Equal(t, 0, r.PitchBend[0])
+}
+
+// TestHelperResampler is not a real test but a fake resampler run by the tests of externalResampler.
+// It writes silence of the requested length.
+func TestHelperResampler(t *testing.T) {
+	if os.Getenv("UTAU_TEST_HELPER_RESAMPLER") != "1" {
+		return
+	}
+	defer os.Exit(0)
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	args = args[1:]
+	if strings.Contains(args[4], "sleep") {
+		time.Sleep(10 * time.Second)
+	}
+	if strings.Contains(args[4], "fail") {
+		fmt.Fprint(os.Stderr, "failed as requested")
+		os.Exit(1)
+	}
+	length, _ := strconv.Atoi(args[6])
+	bs, _ := NewWave(44100, 16, 1, 44100*length/1000).Bytes()
+	ioutil.WriteFile(args[1], bs, 0644)
+}
+
+func newHelperResampler(timeout time.Duration) Resampler {
+	return NewExternalResampler(os.Args[0], timeout, "-test.run=TestHelperResampler", "--")
+}
+
+func TestExternalResamplerRunsExecutable(t *testing.T) {
+	os.Setenv("UTAU_TEST_HELPER_RESAMPLER", "1")
+	defer os.Unsetenv("UTAU_TEST_HELPER_RESAMPLER")
+	dir, err := ioutil.TempDir("", "utau")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+
+	r := NewResampleRequest("in.wav", &Phoneme{}, 60, 100)
+	w, err := newHelperResampler(10*time.Second).Resample(context.Background(), r)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4410, w.Len())
+
+	r.Output = filepath.Join(dir, "out.wav")
+	_, err = newHelperResampler(10*time.Second).Resample(context.Background(), r)
+	assert.Equal(t, nil, err)
+	_, err = os.Stat(r.Output)
+	assert.Equal(t, nil, err)
+}
+
+func TestExternalResamplerFails(t *testing.T) {
+	os.Setenv("UTAU_TEST_HELPER_RESAMPLER", "1")
+	defer os.Unsetenv("UTAU_TEST_HELPER_RESAMPLER")
+	r := NewResampleRequest("in.wav", &Phoneme{}, 60, 100)
+	r.Flags = "fail"
+	_, err := newHelperResampler(10*time.Second).Resample(context.Background(), r)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed as requested")
+
+	r.Flags = "sleep"
+	_, err = newHelperResampler(100*time.Millisecond).Resample(context.Background(), r)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
@@ -0,0 +1,301 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BatchHelper is temp_helper.bat UTAU writes.
+// It resamples the note unless the cache has it, and appends it by wavtool.
+// The arguments are the sample, the note, the length for wavtool, PreUtterance,
+// which the helper does not use, offset, the requested length, consonant and cutoff.
+const BatchHelper = "@if exist %temp% goto A\r\n" +
+	"@if exist \"%cachedir%\\\" goto B\r\n" +
+	"@mkdir \"%cachedir%\"\r\n" +
+	":B\r\n" +
+	"@\"%resamp%\" %1 %temp% %2 %vel% %flag% %5 %6 %7 %8 %params%\r\n" +
+	":A\r\n" +
+	"@\"%tool%\" \"%output%\" %temp% %stp% %3 %env%\r\n"
+
+// ScriptOptions configures the render scripts.
+// Empty Wavtool, Resampler, Output and CacheDir are taken from the Project,
+// and from the names UTAU uses when the Project lacks them.
+type ScriptOptions struct {
+	Wavtool   string
+	Resampler string
+	Output    string
+	CacheDir  string
+	// VoiceDir is the directory of the Voicebank, which samples under it are written relative to.
+	VoiceDir string
+}
+
+func (o ScriptOptions) resolve(p *Project) ScriptOptions {
+	first := func(vs ...string) string {
+		for _, v := range vs {
+			if v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	o.Wavtool = first(o.Wavtool, p.Tool1, "wavtool.exe")
+	o.Resampler = first(o.Resampler, p.Tool2, "resampler.exe")
+	o.Output = first(o.Output, p.OutFile, "temp.wav")
+	o.CacheDir = first(o.CacheDir, p.CacheDir, "temp.cache")
+	return o
+}
+
+// scriptNote is the set of values a render script passes for a Segment.
+type scriptNote struct {
+	silent bool
+	params string
+	flags  string
+	env    []string
+	stp    string
+	vel    string
+	temp   string
+	args   []string
+}
+
+func newScriptNotes(p *Project, plan *Plan) ([]*scriptNote, error) {
+	res := []*scriptNote{}
+	for _, s := range plan.Segments {
+		wt := NewWavtoolRequestFromSegment(s, "", "")
+		adjust := s.Length - s.NoteLength
+		sign := "+"
+		if adjust < 0 {
+			sign = "-"
+		}
+		length := strconv.Itoa(s.Note.Length) + "@" + formatFloat(s.Tempo) + sign + strconv.FormatFloat(math.Abs(adjust), 'f', 1, 64)
+		if s.IsSilent() {
+			res = append(res, &scriptNote{silent: true, stp: "0", args: []string{length}})
+			continue
+		}
+		r, err := NewResampleRequestFromSegment(s, p.Flags)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, &scriptNote{
+			params: formatFloat(r.Volume) + " " + formatFloat(r.Modulation) + " !" + formatFloat(r.Tempo) + " " + EncodePitchBend(r.PitchBend),
+			flags:  r.Flags,
+			env:    wt.Args()[4:],
+			stp:    formatFloat(s.StartPoint),
+			vel:    formatFloat(r.Velocity),
+			temp:   strconv.Itoa(s.Index+1) + "_" + tempFilenameAlias(s.Phoneme.Alias) + "_" + NoteName(r.NoteNum) + "_" + RenderCacheKey(r, nil)[:6] + ".wav",
+			args: []string{
+				r.Input,
+				NoteName(r.NoteNum),
+				length,
+				formatFloat(s.PreUtterance),
+				formatFloat(r.Offset),
+				strconv.Itoa(int(r.Length)),
+				formatFloat(r.Consonant),
+				formatFloat(r.Cutoff),
+			},
+		})
+	}
+	return res, nil
+}
+
+// tempFilenameAlias replaces the characters of the alias that cannot be in filenames
+// or break quoting of the scripts with underscores, as UTAU does for cache files.
+func tempFilenameAlias(alias string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' || strings.ContainsRune(`\/:*?"<>|%$`+"`", r) {
+			return '_'
+		}
+		return r
+	}, alias)
+}
+
+// relativeSample returns the path of the sample relative to the directory of the Voicebank
+// with slashes, or false when the sample is not under the directory.
+func relativeSample(path string, voiceDir string) (string, bool) {
+	if voiceDir == "" {
+		return "", false
+	}
+	rel, err := filepath.Rel(voiceDir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(filepath.ToSlash(rel), "../") {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+func windowsPath(path string) string {
+	return strings.ReplaceAll(filepath.ToSlash(path), "/", "\\")
+}
+
+// BatchScript returns temp.bat UTAU writes to render the planned Project with BatchHelper.
+// Lines end with CRLF; encode it by the Encoding of the Project as UTAU does.
+func BatchScript(p *Project, plan *Plan, o ScriptOptions) (string, error) {
+	o = o.resolve(p)
+	ns, err := newScriptNotes(p, plan)
+	if err != nil {
+		return "", err
+	}
+	ls := []string{
+		"@rem project=" + p.ProjectName,
+		"@set loadmodule=",
+		"@set tempo=" + strconv.FormatFloat(p.Tempo, 'f', 2, 64),
+		"@set samples=" + strconv.Itoa(WavtoolSampleRate),
+		"@set oto=" + windowsPath(o.VoiceDir),
+		"@set tool=" + windowsPath(o.Wavtool),
+		"@set resamp=" + windowsPath(o.Resampler),
+		"@set output=" + windowsPath(o.Output),
+		"@set helper=temp_helper.bat",
+		"@set cachedir=" + windowsPath(o.CacheDir),
+		"@set flag=\"" + p.Flags + "\"",
+		"@set env=" + strings.Join(DefaultEnvelope().Fields(), " "),
+		"@set stp=0",
+		"",
+		"@del \"%output%\" 2>nul",
+		"@mkdir \"%cachedir%\" 2>nul",
+		"",
+	}
+	for i, n := range ns {
+		if n.silent {
+			ls = append(ls, "@\"%tool%\" \"%output%\" \"%oto%\\R.wav\" 0 "+n.args[0]+" 0 0", "")
+			continue
+		}
+		sample := windowsPath(n.args[0])
+		if rel, ok := relativeSample(n.args[0], o.VoiceDir); ok {
+			sample = "%oto%\\" + windowsPath(rel)
+		}
+		args := append([]string{"\"" + sample + "\""}, n.args[1:]...)
+		ls = append(ls,
+			"@set params="+n.params,
+			"@set flag=\""+n.flags+"\"",
+			"@set env="+strings.Join(n.env, " "),
+			"@set stp="+n.stp,
+			"@set vel="+n.vel,
+			"@set temp=\"%cachedir%\\"+n.temp+"\"",
+			"@echo "+strings.Repeat("#", 40)+"("+strconv.Itoa(i+1)+"/"+strconv.Itoa(len(ns))+")",
+			"@call %helper% "+strings.Join(args, " "),
+			"",
+		)
+	}
+	ls = append(ls,
+		"@if not exist \"%output%.whd\" goto E",
+		"@if not exist \"%output%.dat\" goto E",
+		"copy /Y \"%output%.whd\" /B + \"%output%.dat\" /B \"%output%\"",
+		"del \"%output%.whd\"",
+		"del \"%output%.dat\"",
+		":E",
+	)
+	return strings.Join(ls, "\r\n") + "\r\n", nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "'\\''") + "'"
+}
+
+// ShellScript returns a POSIX shell script doing the same as BatchScript and BatchHelper,
+// which renders the Project on Linux by resampler and wavtool of the paths in the options.
+func ShellScript(p *Project, plan *Plan, o ScriptOptions) (string, error) {
+	o = o.resolve(p)
+	ns, err := newScriptNotes(p, plan)
+	if err != nil {
+		return "", err
+	}
+	ls := []string{
+		"#!/bin/sh",
+		"# project=" + p.ProjectName,
+		"set -e",
+		"oto=" + shellQuote(o.VoiceDir),
+		"tool=" + shellQuote(o.Wavtool),
+		"resamp=" + shellQuote(o.Resampler),
+		"output=" + shellQuote(o.Output),
+		"cachedir=" + shellQuote(o.CacheDir),
+		"",
+		"helper() {",
+		"\tif [ ! -f \"$temp\" ]; then",
+		"\t\tmkdir -p \"$cachedir\"",
+		"\t\t\"$resamp\" \"$1\" \"$temp\" \"$2\" \"$vel\" \"$flag\" \"$5\" \"$6\" \"$7\" \"$8\" $params",
+		"\tfi",
+		"\t\"$tool\" \"$output\" \"$temp\" \"$stp\" \"$3\" $env",
+		"}",
+		"",
+		"rm -f \"$output\" \"$output.whd\" \"$output.dat\"",
+		"mkdir -p \"$cachedir\"",
+		"",
+	}
+	for i, n := range ns {
+		if n.silent {
+			ls = append(ls, "\"$tool\" \"$output\" \"$oto/R.wav\" 0 "+n.args[0]+" 0 0", "")
+			continue
+		}
+		sample := shellQuote(n.args[0])
+		if rel, ok := relativeSample(n.args[0], o.VoiceDir); ok {
+			sample = "\"$oto\"/" + shellQuote(rel)
+		}
+		args := append([]string{sample}, n.args[1:]...)
+		ls = append(ls,
+			"params="+shellQuote(n.params),
+			"flag="+shellQuote(n.flags),
+			"env="+shellQuote(strings.Join(n.env, " ")),
+			"stp="+shellQuote(n.stp),
+			"vel="+shellQuote(n.vel),
+			"temp=\"$cachedir\"/"+shellQuote(n.temp),
+			"echo "+shellQuote(strings.Repeat("#", 40)+"("+strconv.Itoa(i+1)+"/"+strconv.Itoa(len(ns))+")"),
+			"helper "+strings.Join(args, " "),
+			"",
+		)
+	}
+	ls = append(ls,
+		"if [ -f \"$output.whd\" ] && [ -f \"$output.dat\" ]; then",
+		"\tcat \"$output.whd\" \"$output.dat\" > \"$output\"",
+		"\trm -f \"$output.whd\" \"$output.dat\"",
+		"fi",
+	)
+	return strings.Join(ls, "\n") + "\n", nil
+}
+
+// ScriptWriter writes render scripts.
+type ScriptWriter interface {
+	WriteBatch(dir string, p *Project, plan *Plan, o ScriptOptions) error
+	WriteShell(filename string, p *Project, plan *Plan, o ScriptOptions) error
+}
+
+type scriptWriterDefault struct {
+	fw fileWriter
+	fc fileModeChanger
+}
+
+// NewScriptWriter creates a default ScriptWriter that writes to filesystem.
+func NewScriptWriter() ScriptWriter {
+	return scriptWriterDefault{fw: fileWriterDefault{}, fc: fileModeChangerDefault{}}
+}
+
+// WriteBatch writes temp.bat and temp_helper.bat into the directory in the Encoding of the Project.
+func (sw scriptWriterDefault) WriteBatch(dir string, p *Project, plan *Plan, o ScriptOptions) error {
+	t, err := BatchScript(p, plan, o)
+	if err != nil {
+		return err
+	}
+	bs, err := p.Encoding().Encode(t)
+	if err != nil {
+		return err
+	}
+	if err := sw.fw.Write(filepath.Join(dir, "temp.bat"), string(bs)); err != nil {
+		return err
+	}
+	return sw.fw.Write(filepath.Join(dir, "temp_helper.bat"), BatchHelper)
+}
+
+// WriteShell writes the shell script in UTF-8 and makes it executable.
+func (sw scriptWriterDefault) WriteShell(filename string, p *Project, plan *Plan, o ScriptOptions) error {
+	t, err := ShellScript(p, plan, o)
+	if err != nil {
+		return err
+	}
+	if err := sw.fw.Write(filename, t); err != nil {
+		return err
+	}
+	return sw.fc.Chmod(filename, 0755)
+}
@@ -0,0 +1,160 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newTestScriptProject() (*Project, *Plan) {
+	p := newTestProject("R あ か", 60, 60, 62)
+	p.Notes[2].Flags = "B40"
+	plan, _ := NewPlanner(testPlannerVoicebank).Plan(p.Notes, p.Tempo)
+	return p, plan
+}
+
+func TestBatchScript(t *testing.T) {
+	p, plan := newTestScriptProject()
+	r1, _ := NewResampleRequestFromSegment(plan.Segments[1], p.Flags)
+	r2, _ := NewResampleRequestFromSegment(plan.Segments[2], p.Flags)
+	expected := strings.Join([]string{
+		`@rem project=test`,
+		`@set loadmodule=`,
+		`@set tempo=120.00`,
+		`@set samples=44100`,
+		`@set oto=vb`,
+		`@set tool=wavtool.exe`,
+		`@set resamp=resampler.exe`,
+		`@set output=temp.wav`,
+		`@set helper=temp_helper.bat`,
+		`@set cachedir=temp.cache`,
+		`@set flag="g-5"`,
+		`@set env=0 5 35 0 100 100 0`,
+		`@set stp=0`,
+		``,
+		`@del "%output%" 2>nul`,
+		`@mkdir "%cachedir%" 2>nul`,
+		``,
+		`@"%tool%" "%output%" "%oto%\R.wav" 0 480@120-40.0 0 0`,
+		``,
+		`@set params=100 0 !120 AA#95#`,
+		`@set flag="g-5"`,
+		`@set env=0 5 35 0 100 100 0 20`,
+		`@set stp=0`,
+		`@set vel=100`,
+		`@set temp="%cachedir%\2_あ_C4_` + RenderCacheKey(r1, nil)[:6] + `.wav"`,
+		`@echo ########################################(2/3)`,
+		`@call %helper% "%oto%\a.wav" C4 480@120-20.0 60 100 500 60 -500`,
+		``,
+		`@set params=100 0 !120 AA#124#`,
+		`@set flag="g-5B40"`,
+		`@set env=0 5 35 0 100 100 0 40`,
+		`@set stp=0`,
+		`@set vel=100`,
+		`@set temp="%cachedir%\3_か_D4_` + RenderCacheKey(r2, nil)[:6] + `.wav"`,
+		`@echo ########################################(3/3)`,
+		`@call %helper% "%oto%\ka.wav" D4 480@120+120.0 120 100 650 150 -500`,
+		``,
+		`@if not exist "%output%.whd" goto E`,
+		`@if not exist "%output%.dat" goto E`,
+		`copy /Y "%output%.whd" /B + "%output%.dat" /B "%output%"`,
+		`del "%output%.whd"`,
+		`del "%output%.dat"`,
+		`:E`,
+		``,
+	}, "\r\n")
+	actual, err := BatchScript(p, plan, ScriptOptions{VoiceDir: "vb"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, expected, actual)
+
+	p.Tool1, p.Tool2, p.OutFile, p.CacheDir = "C:/UTAU/wavtool.exe", "C:/UTAU/resampler.exe", "out.wav", "out.cache"
+	actual, _ = BatchScript(p, plan, ScriptOptions{Resampler: "C:/tools/moresampler.exe"})
+	assert.Contains(t, actual, "@set tool=C:\\UTAU\\wavtool.exe\r\n")
+	assert.Contains(t, actual, "@set resamp=C:\\tools\\moresampler.exe\r\n")
+	assert.Contains(t, actual, "@set output=out.wav\r\n")
+	assert.Contains(t, actual, "@set cachedir=out.cache\r\n")
+	assert.Contains(t, actual, `@call %helper% "vb\a.wav" C4`, "Samples should be written as they are without VoiceDir.")
+}
+
+func TestShellScript(t *testing.T) {
+	p, plan := newTestScriptProject()
+	p.ProjectName = "it's"
+	actual, err := ShellScript(p, plan, ScriptOptions{VoiceDir: "vb", Wavtool: "/usr/local/bin/wavtool", Resampler: "/usr/local/bin/resampler"})
+	assert.Equal(t, nil, err)
+	assert.True(t, strings.HasPrefix(actual, "#!/bin/sh\n# project=it's\n"))
+	for _, l := range []string{
+		"tool='/usr/local/bin/wavtool'",
+		"resamp='/usr/local/bin/resampler'",
+		`"$tool" "$output" "$oto/R.wav" 0 480@120-40.0 0 0`,
+		"params='100 0 !120 AA#95#'",
+		"flag='g-5B40'",
+		"env='0 5 35 0 100 100 0 40'",
+		`helper "$oto"/'ka.wav' D4 480@120+120.0 120 100 650 150 -500`,
+		`	cat "$output.whd" "$output.dat" > "$output"`,
+	} {
+		assert.Contains(t, actual, l+"\n")
+	}
+	assert.NotContains(t, actual, "\r")
+}
+
+func TestShellQuote(t *testing.T) {
+	assert.Equal(t, `'a b'`, shellQuote("a b"))
+	assert.Equal(t, `'it'\''s'`, shellQuote("it's"))
+}
+
+func TestRelativeSample(t *testing.T) {
+	testCases := []struct {
+		path     string
+		voiceDir string
+		res      string
+		ok       bool
+	}{
+		{resolvePath("vb", "a.wav"), "vb", "a.wav", true},
+		{resolvePath("vb", "C4/a.wav"), "vb", "C4/a.wav", true},
+		{resolvePath("other", "a.wav"), "vb", "", false},
+		{resolvePath("vb", "a.wav"), "", "", false},
+	}
+	for i, tc := range testCases {
+		t.Logf("Test case %v.; %v in %v", i+1, tc.path, tc.voiceDir)
+		res, ok := relativeSample(tc.path, tc.voiceDir)
+		assert.Equal(t, tc.res, res)
+		assert.Equal(t, tc.ok, ok)
+	}
+}
+
+func TestTempFilenameAlias(t *testing.T) {
+	assert.Equal(t, "- あ", tempFilenameAlias("- あ"))
+	assert.Equal(t, "a_b_c_d_e_f_g_", tempFilenameAlias(`a%b"c?d*e:f/g\`))
+}
+
+func TestScriptWriter(t *testing.T) {
+	mockedFileWriter := new(fileWriterMock)
+	mockedFileWriter.On("Write", mock.Anything, mock.Anything).Return(nil).Times(3)
+	mockedFileModeChanger := new(fileModeChangerMock)
+	mockedFileModeChanger.On("Chmod", "render.sh", os.FileMode(0755)).Return(nil)
+	sut := scriptWriterDefault{fw: mockedFileWriter, fc: mockedFileModeChanger}
+	p, plan := newTestScriptProject()
+	assert.Equal(t, nil, sut.WriteBatch("dir", p, plan, ScriptOptions{VoiceDir: "vb"}))
+	t1, _ := BatchScript(p, plan, ScriptOptions{VoiceDir: "vb"})
+	bs, _ := ShiftJIS.Encode(t1)
+	mockedFileWriter.AssertCalled(t, "Write", resolvePath("dir", "temp.bat"), string(bs))
+	mockedFileWriter.AssertCalled(t, "Write", resolvePath("dir", "temp_helper.bat"), BatchHelper)
+	assert.Equal(t, nil, sut.WriteShell("render.sh", p, plan, ScriptOptions{VoiceDir: "vb"}))
+	t2, _ := ShellScript(p, plan, ScriptOptions{VoiceDir: "vb"})
+	mockedFileWriter.AssertCalled(t, "Write", "render.sh", t2)
+	mockedFileModeChanger.AssertExpectations(t)
+
+	failing := new(fileWriterMock)
+	failing.On("Write", mock.Anything, mock.Anything).Return(errors.New("FAILED"))
+	sut = scriptWriterDefault{fw: failing, fc: mockedFileModeChanger}
+	assert.Error(t, sut.WriteBatch("dir", p, plan, ScriptOptions{}))
+	assert.Error(t, sut.WriteShell("render.sh", p, plan, ScriptOptions{}))
+}
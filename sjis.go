@@ -0,0 +1,80 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"bytes"
+	"errors"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+// Encoding converts text between bytes in files and strings.
+type Encoding interface {
+	Decode([]byte) (string, error)
+	Encode(string) ([]byte, error)
+}
+
+// ShiftJIS is the Encoding of CP932, the Shift_JIS of Windows that UTAU uses, by golang.org/x/text.
+// Undefined bytes are decoded into U+FFFD, and runes that CP932 cannot express fail to be encoded.
+var ShiftJIS Encoding = shiftJIS{}
+
+// UTF8 is the Encoding of UTF-8. A byte order mark is removed on decoding.
+var UTF8 Encoding = utf8Encoding{}
+
+type shiftJIS struct {
+}
+
+func (e shiftJIS) Decode(bs []byte) (string, error) {
+	res, err := japanese.ShiftJIS.NewDecoder().Bytes(bs)
+	if err != nil {
+		return "", err
+	}
+	return string(res), nil
+}
+
+func (e shiftJIS) Encode(s string) ([]byte, error) {
+	res, err := japanese.ShiftJIS.NewEncoder().Bytes([]byte(s))
+	if err == nil {
+		return res, nil
+	}
+	for _, r := range s {
+		if _, err := japanese.ShiftJIS.NewEncoder().String(string(r)); err != nil {
+			return nil, errors.New("The given text contains a character that Shift_JIS cannot express; `" + string(r) + "`")
+		}
+	}
+	return nil, err
+}
+
+type utf8Encoding struct {
+}
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+func (e utf8Encoding) Decode(bs []byte) (string, error) {
+	bs = bytes.TrimPrefix(bs, utf8BOM)
+	if !utf8.Valid(bs) {
+		return "", errors.New("The given bytes are not valid UTF-8")
+	}
+	return string(bs), nil
+}
+
+func (e utf8Encoding) Encode(s string) ([]byte, error) {
+	return []byte(s), nil
+}
+
+// DetectEncoding guesses the Encoding of a text file written by UTAU or other editors.
+// Files with a byte order mark, a `Charset=UTF-8` line or only valid UTF-8 sequences are UTF-8,
+// and the others are Shift_JIS.
+func DetectEncoding(bs []byte) Encoding {
+	if bytes.HasPrefix(bs, utf8BOM) || bytes.Contains(bytes.ToLower(bs), []byte("charset=utf-8")) {
+		return UTF8
+	}
+	if utf8.Valid(bs) {
+		return UTF8
+	}
+	return ShiftJIS
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShiftJISConvertsCharacters(t *testing.T) {
+	type TestCase struct {
+		text  string
+		bytes []byte
+	}
+	for i, tc := range []TestCase{
+		{"Lyric=あ", []byte{'L', 'y', 'r', 'i', 'c', '=', 0x82, 0xA0}},
+		{"ア", []byte{0x83, 0x41}},
+		{"ｱ", []byte{0xB1}},
+		{"表", []byte{0x95, 0x5C}},
+		{"～", []byte{0x81, 0x60}},
+		{"①", []byte{0x87, 0x40}},
+		{"髙", []byte{0xEE, 0xE0}},
+	} {
+		t.Logf("Test case %v.; `%v` should be converted from and into `%v`.", i+1, tc.text, tc.bytes)
+		actual, err := ShiftJIS.Decode(tc.bytes)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, tc.text, actual)
+		bs, err := ShiftJIS.Encode(tc.text)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, tc.bytes, bs)
+	}
+}
+
+func TestShiftJISHandlesInvalidInput(t *testing.T) {
+	actual, err := ShiftJIS.Decode([]byte{0x82})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "�", actual)
+	_, err = ShiftJIS.Encode("😀")
+	assert.Error(t, err)
+	_, err = ShiftJIS.Encode(string([]rune{0xE000}))
+	assert.Error(t, err, "Runes of the private use area should not be encoded.")
+}
+
+func TestDetectEncoding(t *testing.T) {
+	type TestCase struct {
+		input    []byte
+		expected Encoding
+	}
+	for i, tc := range []TestCase{
+		{[]byte("[#SETTING]\nLyric=あ"), UTF8},
+		{[]byte("\xEF\xBB\xBF[#SETTING]"), UTF8},
+		{[]byte("[#VERSION]\nCharset=UTF-8\n\x82\xA0"), UTF8},
+		{[]byte("Lyric=\x82\xA0"), ShiftJIS},
+	} {
+		t.Logf("Test case %v.; the encoding of `%v` should be detected.", i+1, tc.input)
+		assert.Equal(t, tc.expected, DetectEncoding(tc.input))
+	}
+}
+
+func TestUTF8RemovesBOM(t *testing.T) {
+	actual, err := UTF8.Decode([]byte("\xEF\xBB\xBFあ"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "あ", actual)
+	_, err = UTF8.Decode([]byte{0x82, 0xA0})
+	assert.Error(t, err)
+}
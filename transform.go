@@ -0,0 +1,298 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"errors"
+	"math"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Names of the fields of Phoneme a transformation works on, which are the same as the JSON keys.
+const (
+	PhonemeFieldLeftBlank    = "left_blank"
+	PhonemeFieldConsonant    = "consonant"
+	PhonemeFieldRightBlank   = "right_blank"
+	PhonemeFieldPreUtterance = "pre_utterance"
+	PhonemeFieldOverlap      = "overlap"
+)
+
+// phonemeField returns the pointer to the field of the Phoneme, or nil for an unknown name.
+func phonemeField(p *Phoneme, name string) *float64 {
+	switch name {
+	case PhonemeFieldLeftBlank:
+		return &p.LeftBlank
+	case PhonemeFieldConsonant:
+		return &p.Consonant
+	case PhonemeFieldRightBlank:
+		return &p.RightBlank
+	case PhonemeFieldPreUtterance:
+		return &p.PreUtterance
+	case PhonemeFieldOverlap:
+		return &p.Overlap
+	}
+	return nil
+}
+
+func validatePhonemeField(name string) error {
+	if phonemeField(&Phoneme{}, name) == nil {
+		return errors.New("The given field is unknown; `" + name + "`")
+	}
+	return nil
+}
+
+// OtoChange records how a single Phoneme is changed by a refinement, a transformation or a rewrite.
+type OtoChange struct {
+	Subfolder string   `json:"subfolder"`
+	Phoneme   *Phoneme `json:"-"`
+	Before    Phoneme  `json:"before"`
+	After     Phoneme  `json:"after"`
+}
+
+// Changed reports whether the change modifies any value.
+func (c *OtoChange) Changed() bool {
+	return c.Before != c.After
+}
+
+// Apply overwrites the changed Phoneme with the values after the change.
+func (c *OtoChange) Apply() {
+	if c.Phoneme != nil {
+		*c.Phoneme = c.After
+	}
+}
+
+// FormatOtoChanges lists the oto.ini lines of the OtoChanges that change values,
+// the line before prefixed with `-` and the line after with `+`, under `---`/`+++` headers of each oto.ini.
+// It has no hunk headers, so it is meant for review and cannot be applied as a patch.
+func FormatOtoChanges(cs []*OtoChange) string {
+	var b strings.Builder
+	current := ""
+	first := true
+	for _, c := range cs {
+		if !c.Changed() {
+			continue
+		}
+		if first || c.Subfolder != current {
+			writeDiffHeader(&b, c.Subfolder)
+			current = c.Subfolder
+			first = false
+		}
+		b.WriteString("-" + c.Before.Line() + "\n")
+		b.WriteString("+" + c.After.Line() + "\n")
+	}
+	return b.String()
+}
+
+// Selector chooses the Phonemes a transformation works on.
+// The subfolder is the key of Voicebank.PhonemesMap the Phoneme is in.
+type Selector func(subfolder string, p *Phoneme) bool
+
+// SelectAlias selects Phonemes whose aliases match the regular expression.
+func SelectAlias(pattern string) (Selector, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.New("The given pattern is invalid; `" + pattern + "`; " + err.Error())
+	}
+	return func(_ string, p *Phoneme) bool {
+		return re.MatchString(p.Alias)
+	}, nil
+}
+
+// SelectFilename selects Phonemes whose filenames match the glob pattern of path.Match.
+func SelectFilename(glob string) (Selector, error) {
+	if _, err := path.Match(glob, ""); err != nil {
+		return nil, errors.New("The given glob is invalid; `" + glob + "`")
+	}
+	return func(_ string, p *Phoneme) bool {
+		ok, _ := path.Match(glob, p.Filename)
+		return ok
+	}, nil
+}
+
+// SelectSubfolder selects Phonemes in the subfolder, where an empty name is the root of the Voicebank.
+func SelectSubfolder(name string) Selector {
+	return func(subfolder string, _ *Phoneme) bool {
+		return subfolder == name
+	}
+}
+
+// SelectValue selects Phonemes whose field compares to the value by the operator,
+// one of `<`, `<=`, `>`, `>=`, `==` and `!=`.
+func SelectValue(field string, operator string, value float64) (Selector, error) {
+	if err := validatePhonemeField(field); err != nil {
+		return nil, err
+	}
+	compare := map[string]func(float64, float64) bool{
+		"<":  func(a, b float64) bool { return a < b },
+		"<=": func(a, b float64) bool { return a <= b },
+		">":  func(a, b float64) bool { return a > b },
+		">=": func(a, b float64) bool { return a >= b },
+		"==": func(a, b float64) bool { return a == b },
+		"!=": func(a, b float64) bool { return a != b },
+	}[operator]
+	if compare == nil {
+		return nil, errors.New("The given operator is unknown; `" + operator + "`")
+	}
+	return func(_ string, p *Phoneme) bool {
+		return compare(*phonemeField(p, field), value)
+	}, nil
+}
+
+// SelectAll selects Phonemes all the Selectors select. It selects every Phoneme without Selectors.
+func SelectAll(ss ...Selector) Selector {
+	return func(subfolder string, p *Phoneme) bool {
+		for _, s := range ss {
+			if !s(subfolder, p) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// SelectAny selects Phonemes any of the Selectors selects.
+func SelectAny(ss ...Selector) Selector {
+	return func(subfolder string, p *Phoneme) bool {
+		for _, s := range ss {
+			if s(subfolder, p) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// SelectNot selects Phonemes the Selector does not select.
+func SelectNot(s Selector) Selector {
+	return func(subfolder string, p *Phoneme) bool {
+		return !s(subfolder, p)
+	}
+}
+
+// Operation modifies a field of a Phoneme. Results are rounded to thousandths of a millisecond.
+type Operation func(p *Phoneme)
+
+// roundOtoValue rounds the value to thousandths of a millisecond, dropping errors of floating point
+// such as 50 * 1.1 = 55.00000000000001 which would otherwise be written into oto.ini.
+func roundOtoValue(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
+
+func newFieldOperation(field string, f func(float64) float64) (Operation, error) {
+	if err := validatePhonemeField(field); err != nil {
+		return nil, err
+	}
+	return func(p *Phoneme) {
+		v := phonemeField(p, field)
+		*v = roundOtoValue(f(*v))
+	}, nil
+}
+
+// SetField sets the field to the value.
+func SetField(field string, value float64) (Operation, error) {
+	return newFieldOperation(field, func(float64) float64 { return value })
+}
+
+// AddField adds the delta to the field.
+func AddField(field string, delta float64) (Operation, error) {
+	return newFieldOperation(field, func(v float64) float64 { return v + delta })
+}
+
+// ScaleField multiplies the field by the factor.
+func ScaleField(field string, factor float64) (Operation, error) {
+	return newFieldOperation(field, func(v float64) float64 { return v * factor })
+}
+
+// ClampField limits the field to the range from min to max.
+func ClampField(field string, min float64, max float64) (Operation, error) {
+	if min > max {
+		return nil, errors.New("The given range is empty; `" + formatFloat(min) + "` to `" + formatFloat(max) + "`")
+	}
+	return newFieldOperation(field, func(v float64) float64 { return math.Max(min, math.Min(max, v)) })
+}
+
+// CopyField sets the field dst to the field src plus the offset, as `Consonant = PreUtterance + 20`.
+func CopyField(dst string, src string, offset float64) (Operation, error) {
+	if err := validatePhonemeField(src); err != nil {
+		return nil, err
+	}
+	if err := validatePhonemeField(dst); err != nil {
+		return nil, err
+	}
+	return func(p *Phoneme) {
+		*phonemeField(p, dst) = roundOtoValue(*phonemeField(p, src) + offset)
+	}, nil
+}
+
+// transformStep is a set of Operations applied to the Phonemes a Selector selects.
+type transformStep struct {
+	selector   Selector
+	operations []Operation
+}
+
+// Transform is a pipeline of steps, each of which applies Operations to the Phonemes a Selector selects.
+// Steps run in order, and a step selects Phonemes by the values the previous steps made.
+type Transform struct {
+	steps []*transformStep
+}
+
+// NewTransform creates an empty Transform.
+func NewTransform() *Transform {
+	return &Transform{steps: []*transformStep{}}
+}
+
+// Where appends a step applying the Operations in order to the Phonemes the Selector selects.
+// A nil Selector selects every Phoneme. It returns the Transform to chain steps.
+func (t *Transform) Where(s Selector, ops ...Operation) *Transform {
+	if s == nil {
+		s = SelectAll()
+	}
+	t.steps = append(t.steps, &transformStep{selector: s, operations: ops})
+	return t
+}
+
+// transform returns the change the Transform makes to the Phoneme in the subfolder.
+func (t *Transform) transform(subfolder string, p *Phoneme) *OtoChange {
+	c := &OtoChange{Subfolder: subfolder, Phoneme: p, Before: *p, After: *p}
+	for _, s := range t.steps {
+		if !s.selector(subfolder, &c.After) {
+			continue
+		}
+		for _, op := range s.operations {
+			op(&c.After)
+		}
+	}
+	return c
+}
+
+// ApplyPhonemes transforms the Phonemes in the subfolder and returns the changes that modify values.
+// Phonemes are updated in place unless dryRun is set.
+func (t *Transform) ApplyPhonemes(subfolder string, ps *Phonemes, dryRun bool) []*OtoChange {
+	res := []*OtoChange{}
+	for _, p := range *ps {
+		if c := t.transform(subfolder, p); c.Changed() {
+			res = append(res, c)
+		}
+	}
+	if !dryRun {
+		for _, c := range res {
+			c.Apply()
+		}
+	}
+	return res
+}
+
+// Apply transforms all Phonemes in the Voicebank in the order of the subfolders,
+// and returns the changes that modify values. Phonemes are updated in place unless dryRun is set.
+// Format the changes by FormatOtoChanges to review them.
+func (t *Transform) Apply(vb *Voicebank, dryRun bool) []*OtoChange {
+	res := []*OtoChange{}
+	for _, sub := range sortedSubfolders(vb) {
+		res = append(res, t.ApplyPhonemes(sub, vb.PhonemesMap[sub], dryRun)...)
+	}
+	return res
+}
@@ -0,0 +1,117 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestTransformVoicebank() *Voicebank {
+	return newTestVoicebank(map[string]string{
+		"":   "ka.wav=- か,100,80,-300,60,20\nsa.wav=a さ,200,120,-250,100,30",
+		"C5": "ka.wav=- か↑,90,70,-300,50,10",
+	}, nil)
+}
+
+func TestSelectors(t *testing.T) {
+	p := &Phoneme{Filename: "_ka.wav", Alias: "a か", PreUtterance: 60}
+	must := func(s Selector, err error) Selector {
+		assert.Equal(t, nil, err)
+		return s
+	}
+	testCases := []struct {
+		s   Selector
+		res bool
+	}{
+		{must(SelectAlias("^a ")), true},
+		{must(SelectAlias("^- ")), false},
+		{must(SelectFilename("_k*.wav")), true},
+		{must(SelectFilename("ka.wav")), false},
+		{SelectSubfolder("C5"), true},
+		{SelectSubfolder(""), false},
+		{must(SelectValue(PhonemeFieldPreUtterance, ">=", 60)), true},
+		{must(SelectValue(PhonemeFieldPreUtterance, "<", 60)), false},
+		{SelectAll(SelectSubfolder("C5"), must(SelectAlias("か"))), true},
+		{SelectAll(SelectSubfolder("C5"), SelectSubfolder("")), false},
+		{SelectAny(SelectSubfolder("C4"), SelectSubfolder("C5")), true},
+		{SelectNot(SelectSubfolder("C5")), false},
+		{SelectAll(), true},
+	}
+	for i, tc := range testCases {
+		t.Logf("Test case %v.", i+1)
+		assert.Equal(t, tc.res, tc.s("C5", p))
+	}
+
+	_, err := SelectAlias("(")
+	assert.NotEqual(t, nil, err)
+	_, err = SelectFilename("[")
+	assert.NotEqual(t, nil, err)
+	_, err = SelectValue("tempo", ">", 0)
+	assert.NotEqual(t, nil, err)
+	_, err = SelectValue(PhonemeFieldOverlap, "=~", 0)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestOperations(t *testing.T) {
+	must := func(op Operation, err error) Operation {
+		assert.Equal(t, nil, err)
+		return op
+	}
+	testCases := []struct {
+		op  Operation
+		res Phoneme
+	}{
+		{must(SetField(PhonemeFieldOverlap, 5)), Phoneme{Consonant: 80, PreUtterance: 60, Overlap: 5}},
+		{must(AddField(PhonemeFieldOverlap, 5)), Phoneme{Consonant: 80, PreUtterance: 60, Overlap: 25}},
+		{must(ScaleField(PhonemeFieldPreUtterance, 1.5)), Phoneme{Consonant: 80, PreUtterance: 90, Overlap: 20}},
+		{must(ClampField(PhonemeFieldConsonant, 0, 70)), Phoneme{Consonant: 70, PreUtterance: 60, Overlap: 20}},
+		{must(CopyField(PhonemeFieldConsonant, PhonemeFieldPreUtterance, 20)), Phoneme{Consonant: 80, PreUtterance: 60, Overlap: 20}},
+		{must(CopyField(PhonemeFieldOverlap, PhonemeFieldPreUtterance, -50)), Phoneme{Consonant: 80, PreUtterance: 60, Overlap: 10}},
+	}
+	for i, tc := range testCases {
+		t.Logf("Test case %v.", i+1)
+		p := Phoneme{Consonant: 80, PreUtterance: 60, Overlap: 20}
+		tc.op(&p)
+		assert.Equal(t, tc.res, p)
+	}
+
+	_, err := SetField("tempo", 0)
+	assert.NotEqual(t, nil, err)
+	_, err = ClampField(PhonemeFieldOverlap, 10, 0)
+	assert.NotEqual(t, nil, err)
+	_, err = CopyField(PhonemeFieldOverlap, "tempo", 0)
+	assert.NotEqual(t, nil, err)
+	_, err = CopyField("tempo", PhonemeFieldOverlap, 0)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestTransformAppliesToVoicebank(t *testing.T) {
+	overlap, _ := AddField(PhonemeFieldOverlap, 5)
+	scale, _ := ScaleField(PhonemeFieldPreUtterance, 1.1)
+	consonant, _ := CopyField(PhonemeFieldConsonant, PhonemeFieldPreUtterance, 20)
+	heads, _ := SelectAlias("^- ")
+	tr := NewTransform().
+		Where(nil, overlap).
+		Where(SelectSubfolder("C5"), scale).
+		Where(heads, consonant)
+
+	vb := newTestTransformVoicebank()
+	cs := tr.Apply(vb, true)
+	assert.Equal(t, 3, len(cs))
+	assert.Equal(t, 20.0, (*vb.PhonemesMap[""])[0].Overlap, "Dry run should not modify Phonemes.")
+	assert.Equal(t, "--- a/oto.ini\n+++ b/oto.ini\n"+
+		"-ka.wav=- か,100,80,-300,60,20\n+ka.wav=- か,100,80,-300,60,25\n"+
+		"-sa.wav=a さ,200,120,-250,100,30\n+sa.wav=a さ,200,120,-250,100,35\n"+
+		"--- a/C5/oto.ini\n+++ b/C5/oto.ini\n"+
+		"-ka.wav=- か↑,90,70,-300,50,10\n+ka.wav=- か↑,90,75,-300,55,15\n", FormatOtoChanges(cs))
+
+	cs = tr.Apply(vb, false)
+	assert.Equal(t, 3, len(cs))
+	assert.Equal(t, Phoneme{Filename: "ka.wav", Alias: "- か↑", LeftBlank: 90, Consonant: 75, RightBlank: -300, PreUtterance: 55, Overlap: 15}, *(*vb.PhonemesMap["C5"])[0])
+
+	assert.Equal(t, 0, len(NewTransform().Apply(vb, false)))
+}
@@ -0,0 +1,639 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// TicksPerBeat is the resolution of note lengths in UST.
+const TicksPerBeat = 480
+
+// UstEntry is a single `key=value` line in UST that is not interpreted.
+type UstEntry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// PitchBend represents the Mode2 pitch bend of a note; PBS, PBW, PBY and PBM in UST.
+// StartX is in milliseconds from the beginning of the note,
+// and StartY and Ys are in 10 cents from the pitch of the note.
+type PitchBend struct {
+	StartX float64   `json:"start_x"`
+	StartY float64   `json:"start_y"`
+	Widths []float64 `json:"widths"`
+	Ys     []float64 `json:"ys"`
+	Modes  []string  `json:"modes"`
+}
+
+// Vibrato represents VBR in UST.
+// Length, FadeIn and FadeOut are in percent, Cycle is in milliseconds, Depth is in cents,
+// and Phase and Height are in percent of the cycle and the depth.
+type Vibrato struct {
+	Length  float64 `json:"length"`
+	Cycle   float64 `json:"cycle"`
+	Depth   float64 `json:"depth"`
+	FadeIn  float64 `json:"fade_in"`
+	FadeOut float64 `json:"fade_out"`
+	Phase   float64 `json:"phase"`
+	Height  float64 `json:"height"`
+}
+
+// Note represents a single note section in UST.
+// Optional values are nil when the key is absent or empty.
+type Note struct {
+	// Section is the name of the section the note is read from, such as `#0000` or `#PREV`.
+	Section      string     `json:"section"`
+	Length       int        `json:"length"`
+	Lyric        string     `json:"lyric"`
+	NoteNum      int        `json:"note_num"`
+	PreUtterance *float64   `json:"pre_utterance,omitempty"`
+	VoiceOverlap *float64   `json:"voice_overlap,omitempty"`
+	Velocity     *float64   `json:"velocity,omitempty"`
+	StartPoint   *float64   `json:"start_point,omitempty"`
+	Intensity    *float64   `json:"intensity,omitempty"`
+	Modulation   *float64   `json:"modulation,omitempty"`
+	Tempo        *float64   `json:"tempo,omitempty"`
+	Envelope     *Envelope  `json:"envelope,omitempty"`
+	PitchBend    *PitchBend `json:"pitch_bend,omitempty"`
+	Vibrato      *Vibrato   `json:"vibrato,omitempty"`
+	Flags        string     `json:"flags"`
+	// Extra are the keys this package does not interpret, kept in the order of the file.
+	Extra []*UstEntry `json:"extra"`
+
+	// modulationKey is `Moduration` for a note read with the misspelled key older UTAU writes.
+	modulationKey string
+}
+
+// IsRest reports whether the note is a rest.
+func (n *Note) IsRest() bool {
+	switch strings.TrimSpace(n.Lyric) {
+	case "R", "r", "":
+		return true
+	}
+	return false
+}
+
+// copyNote returns a copy of the note that shares no values with it.
+func copyNote(n *Note) *Note {
+	res := *n
+	for _, f := range []**float64{&res.PreUtterance, &res.VoiceOverlap, &res.Velocity, &res.StartPoint, &res.Intensity, &res.Modulation, &res.Tempo} {
+		if *f != nil {
+			v := **f
+			*f = &v
+		}
+	}
+	if n.Envelope != nil {
+		res.Envelope = &Envelope{P: copyFloats(n.Envelope.P), V: copyFloats(n.Envelope.V)}
+	}
+	if n.PitchBend != nil {
+		pb := *n.PitchBend
+		pb.Widths = copyFloats(pb.Widths)
+		pb.Ys = copyFloats(pb.Ys)
+		if pb.Modes != nil {
+			pb.Modes = append([]string{}, pb.Modes...)
+		}
+		res.PitchBend = &pb
+	}
+	if n.Vibrato != nil {
+		v := *n.Vibrato
+		res.Vibrato = &v
+	}
+	if n.Extra != nil {
+		res.Extra = make([]*UstEntry, len(n.Extra))
+		for k, e := range n.Extra {
+			c := *e
+			res.Extra[k] = &c
+		}
+	}
+	return &res
+}
+
+func copyFloats(vs []float64) []float64 {
+	if vs == nil {
+		return nil
+	}
+	return append([]float64{}, vs...)
+}
+
+// Project represents a UST file.
+type Project struct {
+	Version     string  `json:"version"`
+	Charset     string  `json:"charset,omitempty"`
+	Tempo       float64 `json:"tempo"`
+	ProjectName string  `json:"project_name"`
+	VoiceDir    string  `json:"voice_dir"`
+	OutFile     string  `json:"out_file"`
+	CacheDir    string  `json:"cache_dir"`
+	Tool1       string  `json:"tool1"`
+	Tool2       string  `json:"tool2"`
+	Flags       string  `json:"flags"`
+	Mode2       bool    `json:"mode2"`
+	// VersionExtra are the keys in [#VERSION] this package does not interpret.
+	VersionExtra []*UstEntry `json:"version_extra"`
+	// Settings are the keys in [#SETTING] this package does not interpret.
+	Settings []*UstEntry `json:"settings"`
+	Notes    []*Note     `json:"notes"`
+}
+
+type ustSection struct {
+	name    string
+	header  []string
+	entries []*UstEntry
+}
+
+func parseUstSections(t string) []*ustSection {
+	res := []*ustSection{}
+	var current *ustSection
+	for _, l := range strings.Split(strings.ReplaceAll(t, "\r\n", "\n"), "\n") {
+		l = strings.TrimRight(l, "\r")
+		if strings.HasPrefix(l, "[#") && strings.HasSuffix(l, "]") {
+			current = &ustSection{name: l[1 : len(l)-1]}
+			res = append(res, current)
+			continue
+		}
+		if current == nil || l == "" {
+			continue
+		}
+		i := strings.Index(l, "=")
+		if i < 0 {
+			current.header = append(current.header, l)
+			continue
+		}
+		current.entries = append(current.entries, &UstEntry{Key: l[:i], Value: l[i+1:]})
+	}
+	return res
+}
+
+// isNoteSection reports whether the section name is `#` followed by digits.
+func isNoteSection(name string) bool {
+	if len(name) < 2 || name[0] != '#' {
+		return false
+	}
+	for _, c := range name[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// NewProjectFromBytes creates Project from the content of UST, detecting its encoding.
+func NewProjectFromBytes(bs []byte) (*Project, error) {
+	t, err := DetectEncoding(bs).Decode(bs)
+	if err != nil {
+		return nil, err
+	}
+	return NewProjectFromText(t)
+}
+
+// NewProjectFromText creates Project from the text of UST.
+func NewProjectFromText(t string) (*Project, error) {
+	p := &Project{Tempo: 120, Settings: []*UstEntry{}, Notes: []*Note{}}
+	found := false
+	for _, s := range parseUstSections(t) {
+		switch {
+		case s.name == "#VERSION" || s.name == "#SETTING":
+			found = true
+			for _, h := range s.header {
+				if strings.HasPrefix(h, "UST Version") {
+					p.Version = strings.TrimSpace(strings.TrimPrefix(h, "UST Version"))
+				}
+			}
+			for _, e := range s.entries {
+				if s.name == "#VERSION" && e.Key != "UST Version" && e.Key != "Charset" {
+					p.VersionExtra = append(p.VersionExtra, e)
+					continue
+				}
+				if err := p.set(e); err != nil {
+					return nil, err
+				}
+			}
+		case isNoteSection(s.name):
+			n, err := NewNoteFromEntries(s.entries)
+			if err != nil {
+				return nil, errors.New("The section `" + s.name + "` is not a valid note; " + err.Error())
+			}
+			n.Section = s.name
+			p.Notes = append(p.Notes, n)
+		}
+	}
+	if !found {
+		return nil, errors.New("The given text does not contain [#SETTING]")
+	}
+	return p, nil
+}
+
+func (p *Project) set(e *UstEntry) error {
+	switch e.Key {
+	case "UST Version":
+		p.Version = e.Value
+	case "Charset":
+		p.Charset = e.Value
+	case "Tempo":
+		v, err := strconv.ParseFloat(strings.TrimSpace(e.Value), 64)
+		if err != nil {
+			return err
+		}
+		p.Tempo = v
+	case "ProjectName":
+		p.ProjectName = e.Value
+	case "VoiceDir":
+		p.VoiceDir = e.Value
+	case "OutFile":
+		p.OutFile = e.Value
+	case "CacheDir":
+		p.CacheDir = e.Value
+	case "Tool1":
+		p.Tool1 = e.Value
+	case "Tool2":
+		p.Tool2 = e.Value
+	case "Flags":
+		p.Flags = e.Value
+	case "Mode2":
+		p.Mode2 = strings.EqualFold(e.Value, "True")
+	default:
+		p.Settings = append(p.Settings, e)
+	}
+	return nil
+}
+
+// NewNoteFromEntries creates Note from the lines of a note section.
+func NewNoteFromEntries(es []*UstEntry) (*Note, error) {
+	n := &Note{Extra: []*UstEntry{}}
+	var pbs, pbw, pby, pbm *UstEntry
+	for _, e := range es {
+		var err error
+		switch e.Key {
+		case "Length":
+			n.Length, err = strconv.Atoi(strings.TrimSpace(e.Value))
+		case "Lyric":
+			n.Lyric = e.Value
+		case "NoteNum":
+			n.NoteNum, err = strconv.Atoi(strings.TrimSpace(e.Value))
+		case "PreUtterance":
+			n.PreUtterance, err = parseOptionalFloat(e.Value)
+		case "VoiceOverlap":
+			n.VoiceOverlap, err = parseOptionalFloat(e.Value)
+		case "Velocity":
+			n.Velocity, err = parseOptionalFloat(e.Value)
+		case "StartPoint":
+			n.StartPoint, err = parseOptionalFloat(e.Value)
+		case "Intensity":
+			n.Intensity, err = parseOptionalFloat(e.Value)
+		case "Modulation", "Moduration":
+			n.Modulation, err = parseOptionalFloat(e.Value)
+			if e.Key == "Moduration" {
+				n.modulationKey = e.Key
+			}
+		case "Tempo":
+			n.Tempo, err = parseOptionalFloat(e.Value)
+		case "Envelope":
+			if e.Value != "" {
+				n.Envelope, err = NewEnvelopeFromText(e.Value)
+			}
+		case "PBS":
+			if e.Value != "" {
+				pbs = e
+			}
+		case "PBW":
+			if e.Value != "" {
+				pbw = e
+			}
+		case "PBY":
+			pby = e
+		case "PBM":
+			pbm = e
+		case "VBR":
+			if e.Value != "" {
+				n.Vibrato, err = NewVibratoFromText(e.Value)
+			}
+		case "Flags":
+			n.Flags = e.Value
+		default:
+			n.Extra = append(n.Extra, e)
+		}
+		if err != nil {
+			return nil, errors.New("The value of `" + e.Key + "` is invalid; `" + e.Value + "`")
+		}
+	}
+	if pbs != nil || pbw != nil {
+		pb, err := newPitchBend(pbs, pbw, pby, pbm)
+		if err != nil {
+			return nil, err
+		}
+		n.PitchBend = pb
+	}
+	return n, nil
+}
+
+func parseOptionalFloat(s string) (*float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func parseFloats(s string) ([]float64, error) {
+	res := []float64{}
+	if strings.TrimSpace(s) == "" {
+		return res, nil
+	}
+	for _, e := range strings.Split(s, ",") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			res = append(res, 0)
+			continue
+		}
+		v, err := strconv.ParseFloat(e, 64)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+	return res, nil
+}
+
+func formatFloats(vs []float64) string {
+	res := make([]string, len(vs))
+	for i, v := range vs {
+		res[i] = formatFloat(v)
+	}
+	return strings.Join(res, ",")
+}
+
+func newPitchBend(pbs, pbw, pby, pbm *UstEntry) (*PitchBend, error) {
+	pb := &PitchBend{Widths: []float64{}, Ys: []float64{}, Modes: []string{}}
+	if pbs != nil && strings.TrimSpace(pbs.Value) != "" {
+		es := strings.FieldsFunc(pbs.Value, func(r rune) bool { return r == ';' || r == ',' })
+		vs, err := parseFloats(strings.Join(es, ","))
+		if err != nil {
+			return nil, errors.New("The value of `PBS` is invalid; `" + pbs.Value + "`")
+		}
+		if len(vs) > 0 {
+			pb.StartX = vs[0]
+		}
+		if len(vs) > 1 {
+			pb.StartY = vs[1]
+		}
+	}
+	var err error
+	if pbw != nil {
+		if pb.Widths, err = parseFloats(pbw.Value); err != nil {
+			return nil, errors.New("The value of `PBW` is invalid; `" + pbw.Value + "`")
+		}
+	}
+	if pby != nil {
+		if pb.Ys, err = parseFloats(pby.Value); err != nil {
+			return nil, errors.New("The value of `PBY` is invalid; `" + pby.Value + "`")
+		}
+	}
+	if pbm != nil && pbm.Value != "" {
+		pb.Modes = strings.Split(pbm.Value, ",")
+	}
+	return pb, nil
+}
+
+// NewVibratoFromText creates Vibrato from the value of `VBR` in UST.
+func NewVibratoFromText(t string) (*Vibrato, error) {
+	vs, err := parseFloats(t)
+	if err != nil {
+		return nil, err
+	}
+	if len(vs) < 3 {
+		return nil, errors.New("The given vibrato does not contain 3 elements; `" + t + "`")
+	}
+	for len(vs) < 7 {
+		vs = append(vs, 0)
+	}
+	return &Vibrato{Length: vs[0], Cycle: vs[1], Depth: vs[2], FadeIn: vs[3], FadeOut: vs[4], Phase: vs[5], Height: vs[6]}, nil
+}
+
+// String formats the Vibrato as the value of `VBR` in UST.
+func (v *Vibrato) String() string {
+	return formatFloats([]float64{v.Length, v.Cycle, v.Depth, v.FadeIn, v.FadeOut, v.Phase, v.Height, 0})
+}
+
+// Entries formats the Note as the lines of a note section.
+func (n *Note) Entries() []*UstEntry {
+	res := []*UstEntry{
+		{Key: "Length", Value: strconv.Itoa(n.Length)},
+		{Key: "Lyric", Value: n.Lyric},
+		{Key: "NoteNum", Value: strconv.Itoa(n.NoteNum)},
+	}
+	optional := func(k string, v *float64) {
+		if v != nil {
+			res = append(res, &UstEntry{Key: k, Value: formatFloat(*v)})
+		}
+	}
+	optional("PreUtterance", n.PreUtterance)
+	optional("VoiceOverlap", n.VoiceOverlap)
+	optional("Velocity", n.Velocity)
+	optional("StartPoint", n.StartPoint)
+	optional("Intensity", n.Intensity)
+	if n.modulationKey != "" {
+		optional(n.modulationKey, n.Modulation)
+	} else {
+		optional("Modulation", n.Modulation)
+	}
+	optional("Tempo", n.Tempo)
+	if n.Envelope != nil {
+		res = append(res, &UstEntry{Key: "Envelope", Value: n.Envelope.String()})
+	}
+	if pb := n.PitchBend; pb != nil {
+		res = append(res,
+			&UstEntry{Key: "PBS", Value: formatFloat(pb.StartX) + ";" + formatFloat(pb.StartY)},
+			&UstEntry{Key: "PBW", Value: formatFloats(pb.Widths)},
+			&UstEntry{Key: "PBY", Value: formatFloats(pb.Ys)},
+			&UstEntry{Key: "PBM", Value: strings.Join(pb.Modes, ",")},
+		)
+	}
+	if n.Vibrato != nil {
+		res = append(res, &UstEntry{Key: "VBR", Value: n.Vibrato.String()})
+	}
+	if n.Flags != "" {
+		res = append(res, &UstEntry{Key: "Flags", Value: n.Flags})
+	}
+	return append(res, n.Extra...)
+}
+
+// Text formats the Project as UST. Notes are renumbered from `#0000`.
+func (p *Project) Text() string {
+	var b strings.Builder
+	version := p.Version
+	if version == "" {
+		version = "1.2"
+	}
+	b.WriteString("[#VERSION]\r\n")
+	b.WriteString("UST Version" + version + "\r\n")
+	if p.Charset != "" {
+		b.WriteString("Charset=" + p.Charset + "\r\n")
+	}
+	writeUstEntries(&b, p.VersionExtra)
+	b.WriteString("[#SETTING]\r\n")
+	writeUstEntries(&b, p.settingEntries())
+	for i, n := range p.Notes {
+		b.WriteString(fmt.Sprintf("[#%04d]\r\n", i))
+		writeUstEntries(&b, n.Entries())
+	}
+	b.WriteString("[#TRACKEND]\r\n")
+	return b.String()
+}
+
+// settingEntries formats [#SETTING]. Tempo is always written, and the other keys are written
+// when they have values so that keys the project lacks are not added.
+func (p *Project) settingEntries() []*UstEntry {
+	res := []*UstEntry{{Key: "Tempo", Value: strconv.FormatFloat(p.Tempo, 'f', 2, 64)}}
+	for _, e := range p.Settings {
+		if e.Key == "Tracks" {
+			res = append(res, e)
+		}
+	}
+	for _, e := range []*UstEntry{
+		{Key: "ProjectName", Value: p.ProjectName},
+		{Key: "VoiceDir", Value: p.VoiceDir},
+		{Key: "OutFile", Value: p.OutFile},
+		{Key: "CacheDir", Value: p.CacheDir},
+		{Key: "Tool1", Value: p.Tool1},
+		{Key: "Tool2", Value: p.Tool2},
+		{Key: "Flags", Value: p.Flags},
+	} {
+		if e.Value != "" {
+			res = append(res, e)
+		}
+	}
+	if p.Mode2 {
+		res = append(res, &UstEntry{Key: "Mode2", Value: "True"})
+	}
+	for _, e := range p.Settings {
+		if e.Key != "Tracks" {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+func writeUstEntries(b *strings.Builder, es []*UstEntry) {
+	for _, e := range es {
+		b.WriteString(e.Key + "=" + e.Value + "\r\n")
+	}
+}
+
+// Encoding returns the Encoding the Project should be written in.
+func (p *Project) Encoding() Encoding {
+	if strings.EqualFold(p.Charset, "UTF-8") {
+		return UTF8
+	}
+	return ShiftJIS
+}
+
+// Bytes formats the Project as UST in the Encoding of the Project.
+func (p *Project) Bytes() ([]byte, error) {
+	return p.Encoding().Encode(p.Text())
+}
+
+// TickToMs converts ticks into milliseconds at the tempo.
+func TickToMs(ticks int, tempo float64) float64 {
+	return float64(ticks) * 60000 / (tempo * TicksPerBeat)
+}
+
+var noteNames = []string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+
+// NoteName returns the name of the note number such as `C4` for 60, as prefix.map and resamplers use.
+func NoteName(noteNum int) string {
+	octave := noteNum/12 - 1
+	if noteNum < 0 && noteNum%12 != 0 {
+		octave--
+	}
+	return noteNames[(noteNum%12+12)%12] + strconv.Itoa(octave)
+}
+
+// ResolveVoiceDir returns the path of the voicebank VoiceDir points to.
+// `%VOICE%` is replaced by voiceRoot, and backslashes are converted into the path separator.
+func (p *Project) ResolveVoiceDir(voiceRoot string) string {
+	d := p.VoiceDir
+	if strings.HasPrefix(d, "%VOICE%") {
+		d = strings.TrimRight(voiceRoot, `/\`) + `\` + strings.TrimPrefix(d, "%VOICE%")
+	}
+	return strings.ReplaceAll(d, `\`, string(os.PathSeparator))
+}
+
+// Voicebank reads the voicebank VoiceDir points to by the VoicebankReader.
+func (p *Project) Voicebank(vr VoicebankReader, voiceRoot string) (*Voicebank, error) {
+	if p.VoiceDir == "" {
+		return nil, errors.New("The project does not have VoiceDir")
+	}
+	return vr.Read(p.ResolveVoiceDir(voiceRoot))
+}
+
+type projectFactory interface {
+	New([]byte) (*Project, error)
+}
+
+type projectFactoryDefault struct {
+}
+
+func (pf projectFactoryDefault) New(bs []byte) (*Project, error) {
+	return NewProjectFromBytes(bs)
+}
+
+// ProjectReader reads Project from filesystem.
+type ProjectReader interface {
+	Read(string) (*Project, error)
+}
+
+type projectReaderDefault struct {
+	fr fileReader
+	pf projectFactory
+}
+
+// NewProjectReader creates a default ProjectReader that reads UST from filesystem.
+func NewProjectReader() ProjectReader {
+	return projectReaderDefault{
+		fr: fileReaderDefault{},
+		pf: projectFactoryDefault{},
+	}
+}
+
+// Read Project from the file specified by filename.
+func (pr projectReaderDefault) Read(filename string) (*Project, error) {
+	t, err := pr.fr.Read(filename)
+	if err != nil {
+		return nil, err
+	}
+	return pr.pf.New([]byte(t))
+}
+
+// ProjectWriter writes Project to filesystem.
+type ProjectWriter interface {
+	Write(string, *Project) error
+}
+
+type projectWriterDefault struct {
+	fw fileWriter
+}
+
+// NewProjectWriter creates a default ProjectWriter that writes UST to filesystem.
+func NewProjectWriter() ProjectWriter {
+	return projectWriterDefault{fw: fileWriterDefault{}}
+}
+
+// Write Project to the file specified by filename in the Encoding of the Project.
+func (pw projectWriterDefault) Write(filename string, p *Project) error {
+	bs, err := p.Bytes()
+	if err != nil {
+		return err
+	}
+	return pw.fw.Write(filename, string(bs))
+}
@@ -0,0 +1,250 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const testUst = "[#VERSION]\r\n" +
+	"UST Version1.2\r\n" +
+	"[#SETTING]\r\n" +
+	"Tempo=125.00\r\n" +
+	"Tracks=1\r\n" +
+	"ProjectName=test\r\n" +
+	"VoiceDir=%VOICE%uta\r\n" +
+	"OutFile=out.wav\r\n" +
+	"CacheDir=test.cache\r\n" +
+	"Tool1=wavtool.exe\r\n" +
+	"Tool2=resampler.exe\r\n" +
+	"Flags=g-5\r\n" +
+	"Mode2=True\r\n" +
+	"Unknown=kept\r\n" +
+	"[#0000]\r\n" +
+	"Length=480\r\n" +
+	"Lyric=R\r\n" +
+	"NoteNum=60\r\n" +
+	"PreUtterance=\r\n" +
+	"[#0001]\r\n" +
+	"Length=960\r\n" +
+	"Lyric=あ\r\n" +
+	"NoteNum=62\r\n" +
+	"PreUtterance=30.5\r\n" +
+	"VoiceOverlap=10\r\n" +
+	"Intensity=90\r\n" +
+	"Modulation=0\r\n" +
+	"Envelope=0,5,35,0,100,100,0,%,10\r\n" +
+	"PBS=-40;-20\r\n" +
+	"PBW=80,40\r\n" +
+	"PBY=0,\r\n" +
+	"PBM=,r\r\n" +
+	"VBR=65,180,35,20,20,0,0,0\r\n" +
+	"Flags=B40\r\n" +
+	"$direct=True\r\n" +
+	"[#TRACKEND]\r\n"
+
+func float64Pointer(v float64) *float64 {
+	return &v
+}
+
+var testProject = &Project{
+	Version:     "1.2",
+	Tempo:       125,
+	ProjectName: "test",
+	VoiceDir:    "%VOICE%uta",
+	OutFile:     "out.wav",
+	CacheDir:    "test.cache",
+	Tool1:       "wavtool.exe",
+	Tool2:       "resampler.exe",
+	Flags:       "g-5",
+	Mode2:       true,
+	Settings:    []*UstEntry{{Key: "Tracks", Value: "1"}, {Key: "Unknown", Value: "kept"}},
+	Notes: []*Note{
+		{Section: "#0000", Length: 480, Lyric: "R", NoteNum: 60, Extra: []*UstEntry{}},
+		{
+			Section:      "#0001",
+			Length:       960,
+			Lyric:        "あ",
+			NoteNum:      62,
+			PreUtterance: float64Pointer(30.5),
+			VoiceOverlap: float64Pointer(10),
+			Intensity:    float64Pointer(90),
+			Modulation:   float64Pointer(0),
+			Envelope:     &Envelope{P: []float64{0, 5, 35, 10}, V: []float64{0, 100, 100, 0}},
+			PitchBend:    &PitchBend{StartX: -40, StartY: -20, Widths: []float64{80, 40}, Ys: []float64{0, 0}, Modes: []string{"", "r"}},
+			Vibrato:      &Vibrato{Length: 65, Cycle: 180, Depth: 35, FadeIn: 20, FadeOut: 20},
+			Flags:        "B40",
+			Extra:        []*UstEntry{{Key: "$direct", Value: "True"}},
+		},
+	},
+}
+
+func TestSuccessfulCasesOfNewProjectFromText(t *testing.T) {
+	actual, err := NewProjectFromText(testUst)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, testProject, actual)
+}
+
+func TestNewProjectFromTextReadsVersion2(t *testing.T) {
+	actual, err := NewProjectFromText("[#VERSION]\nUST Version2.0\nCharset=UTF-8\n[#SETTING]\nTempo=100\n[#0000]\nLength=240\nLyric=か\nNoteNum=60\n[#TRACKEND]\n")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "2.0", actual.Version)
+	assert.Equal(t, "UTF-8", actual.Charset)
+	assert.Equal(t, 100.0, actual.Tempo)
+	assert.Equal(t, "か", actual.Notes[0].Lyric)
+	assert.Equal(t, UTF8, actual.Encoding())
+}
+
+func TestProjectTextKeepsOnlyKeysOfInput(t *testing.T) {
+	const text = "[#VERSION]\r\n" +
+		"UST Version1.2\r\n" +
+		"Build=1\r\n" +
+		"[#SETTING]\r\n" +
+		"Tempo=100.00\r\n" +
+		"[#0000]\r\n" +
+		"Length=240\r\n" +
+		"Lyric=か\r\n" +
+		"NoteNum=60\r\n" +
+		"Moduration=0\r\n" +
+		"[#TRACKEND]\r\n"
+	p, err := NewProjectFromText(text)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []*UstEntry{{Key: "Build", Value: "1"}}, p.VersionExtra)
+	assert.Equal(t, text, p.Text())
+}
+
+func TestFailedCasesOfNewProjectFromText(t *testing.T) {
+	for i, tc := range []string{
+		"This is not a UST.",
+		"[#SETTING]\nTempo=fast\n",
+		"[#SETTING]\n[#0000]\nLength=long\n",
+		"[#SETTING]\n[#0000]\nEnvelope=0,5\n",
+		"[#SETTING]\n[#0000]\nPBW=1,a\n",
+	} {
+		t.Logf("Test case %v.; `%v` cannot be interpreted as Project.", i+1, tc)
+		_, err := NewProjectFromText(tc)
+		assert.Error(t, err)
+	}
+}
+
+func TestProjectRoundTripsInShiftJIS(t *testing.T) {
+	bs, err := testProject.Bytes()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ShiftJIS, DetectEncoding(bs))
+	actual, err := NewProjectFromBytes(bs)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, testProject, actual)
+}
+
+func TestNoteIsRest(t *testing.T) {
+	assert.True(t, (&Note{Lyric: "R"}).IsRest())
+	assert.True(t, (&Note{Lyric: ""}).IsRest())
+	assert.False(t, (&Note{Lyric: "あ"}).IsRest())
+}
+
+func TestCopyNoteSharesNoValues(t *testing.T) {
+	src := testProject.Notes[1]
+	n := copyNote(src)
+	assert.Equal(t, src, n)
+	*n.PreUtterance = 0
+	n.Envelope.P[0] = 1
+	n.PitchBend.Widths[0] = 1
+	n.PitchBend.Modes[1] = "s"
+	n.Vibrato.Depth = 0
+	n.Extra[0].Value = "False"
+	assert.Equal(t, 30.5, *src.PreUtterance)
+	assert.Equal(t, 0.0, src.Envelope.P[0])
+	assert.Equal(t, 80.0, src.PitchBend.Widths[0])
+	assert.Equal(t, "r", src.PitchBend.Modes[1])
+	assert.Equal(t, 35.0, src.Vibrato.Depth)
+	assert.Equal(t, "True", src.Extra[0].Value)
+}
+
+func TestTickToMs(t *testing.T) {
+	assert.Equal(t, 500.0, TickToMs(480, 120))
+	assert.Equal(t, 250.0, TickToMs(240, 120))
+}
+
+type voicebankReaderMock struct {
+	mock.Mock
+}
+
+func (m *voicebankReaderMock) Read(path string) (*Voicebank, error) {
+	args := m.Called(path)
+	return args.Get(0).(*Voicebank), args.Error(1)
+}
+
+func TestProjectResolvesVoicebank(t *testing.T) {
+	sep := string(os.PathSeparator)
+	mockedVoicebankReader := new(voicebankReaderMock)
+	mockedVoicebankReader.On("Read", "voice"+sep+"uta").Return(testVoicebank, nil)
+	actual, err := testProject.Voicebank(mockedVoicebankReader, "voice/")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, testVoicebank, actual)
+	assert.Equal(t, sep+"voice"+sep+"uta", (&Project{VoiceDir: `\voice\uta`}).ResolveVoiceDir(""))
+	_, err = (&Project{}).Voicebank(mockedVoicebankReader, "voice")
+	assert.Error(t, err)
+}
+
+type projectFactoryMock struct {
+	mock.Mock
+}
+
+func (m *projectFactoryMock) New(bs []byte) (*Project, error) {
+	args := m.Called(bs)
+	return args.Get(0).(*Project), args.Error(1)
+}
+
+func TestProjectReaderReadsFileSuccessfully(t *testing.T) {
+	const testCase = "testCase"
+	const fakeFileText = "This is a fake text."
+	mockedFileReader := new(fileReaderMock)
+	mockedProjectFactory := new(projectFactoryMock)
+	sut := &projectReaderDefault{
+		fr: mockedFileReader,
+		pf: mockedProjectFactory,
+	}
+	mockedFileReader.On("Read", testCase).Return(fakeFileText, nil)
+	mockedProjectFactory.On("New", []byte(fakeFileText)).Return(testProject, nil)
+	actual, err := sut.Read(testCase)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, testProject, actual)
+}
+
+func TestProjectReaderReadsFileInFailWhenReadingFileFails(t *testing.T) {
+	const testCase = "testCase"
+	mockedFileReader := new(fileReaderMock)
+	mockedProjectFactory := new(projectFactoryMock)
+	sut := &projectReaderDefault{
+		fr: mockedFileReader,
+		pf: mockedProjectFactory,
+	}
+	expected := errors.New("FAILED")
+	mockedFileReader.On("Read", testCase).Return("", expected)
+	_, err := sut.Read(testCase)
+	assert.Equal(t, expected, err)
+	mockedProjectFactory.AssertNumberOfCalls(t, "New", 0)
+}
+
+func TestProjectWriterWritesFile(t *testing.T) {
+	mockedFileWriter := new(fileWriterMock)
+	bs, _ := testProject.Bytes()
+	mockedFileWriter.On("Write", "out.ust", string(bs)).Return(nil)
+	sut := projectWriterDefault{fw: mockedFileWriter}
+	assert.Equal(t, nil, sut.Write("out.ust", testProject))
+	mockedFileWriter.AssertExpectations(t)
+}
+
+func TestNoteName(t *testing.T) {
+	assert.Equal(t, "C4", NoteName(60))
+	assert.Equal(t, "A4", NoteName(69))
+	assert.Equal(t, "C#-1", NoteName(1))
+	assert.Equal(t, "B-2", NoteName(-1))
+}
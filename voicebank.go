@@ -4,7 +4,10 @@
 
 package utau
 
-import "os"
+import (
+	"os"
+	"strings"
+)
 
 // Voicebank represents a single UTAU library.
 type Voicebank struct {
@@ -27,6 +30,16 @@ type voicebankReaderDefault struct {
 	de directoryEnumerator
 }
 
+// NewVoicebankReader creates a default VoicebankReader that reads voicebanks from filesystem.
+func NewVoicebankReader() VoicebankReader {
+	return voicebankReaderDefault{
+		pr: NewPhonemesReader(),
+		cr: NewCharacterReader(),
+		ar: NewAffixesReaderDefault(),
+		de: directoryEnumeratorDefault{},
+	}
+}
+
 // VoicebankReaderDefault is a default VoicebankReader.
 func (vr voicebankReaderDefault) Read(path string) (*Voicebank, error) {
 	ds, err := vr.de.Enumerate(path)
@@ -59,6 +72,50 @@ func (vr voicebankReaderDefault) Read(path string) (*Voicebank, error) {
 	}, nil
 }
 
+// SamplePath returns the path to the wave file the Phoneme in the subfolder refers to.
+func (vb *Voicebank) SamplePath(subfolder string, p *Phoneme) string {
+	if subfolder == "" {
+		return resolvePath(vb.Path, p.Filename)
+	}
+	return resolvePath(resolvePath(vb.Path, subfolder), p.Filename)
+}
+
+// Lookup finds the Phoneme of the alias sung at the note number.
+// A Phoneme without alias is found by its filename without the extension.
+// The prefix and suffix of prefix.map for the note are tried first, then the alias itself.
+// Subfolders are searched in the order of their names.
+func (vb *Voicebank) Lookup(alias string, noteNum int) (string, *Phoneme, bool) {
+	candidates := []string{}
+	if vb.Affixes != nil {
+		if a, ok := (*vb.Affixes)[NoteName(noteNum)]; ok && (a.Prefix != "" || a.Suffix != "") {
+			candidates = append(candidates, a.Prefix+alias+a.Suffix)
+		}
+	}
+	candidates = append(candidates, alias)
+	subs := sortedSubfolders(vb)
+	for _, c := range candidates {
+		for _, sub := range subs {
+			for _, p := range *vb.PhonemesMap[sub] {
+				if p.Alias == c || (p.Alias == "" && strings.TrimSuffix(p.Filename, ".wav") == c) {
+					return sub, p, true
+				}
+			}
+		}
+	}
+	return "", nil, false
+}
+
+// LookupAnyForm finds the Phoneme of the alias like Lookup, trying the forms AliasForms gives in order,
+// so that `か` finds `ka` in a romaji bank and `カ` finds `か`. It returns the alias found as well.
+func (vb *Voicebank) LookupAnyForm(alias string, noteNum int) (string, string, *Phoneme, bool) {
+	for _, a := range AliasForms(alias) {
+		if sub, p, ok := vb.Lookup(a, noteNum); ok {
+			return a, sub, p, true
+		}
+	}
+	return "", "", nil, false
+}
+
 func resolvePath(path string, filename string) string {
 	return path + string(os.PathSeparator) + filename
 }
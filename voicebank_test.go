@@ -88,3 +88,57 @@ func TestVoicebankReaderReadsFileSuccessfully(t *testing.T) {
 	mockedDirectoryEnumerator.AssertExpectations(t)
 	mockedPhonemesReader.AssertExpectations(t)
 }
+
+func TestVoicebankLooksUpAliases(t *testing.T) {
+	type TestCase struct {
+		alias     string
+		noteNum   int
+		subfolder string
+		filename  string
+	}
+	vb := &Voicebank{
+		PhonemesMap: map[string]*Phonemes{
+			"":     {&Phoneme{Filename: "a.wav", Alias: "あ"}, &Phoneme{Filename: "i.wav"}},
+			"high": {&Phoneme{Filename: "a_high.wav", Alias: "あ↑"}},
+		},
+		Affixes: &Affixes{"C5": &Affix{Prefix: "", Suffix: "↑"}},
+	}
+	for i, tc := range []TestCase{
+		{"あ", 60, "", "a.wav"},
+		{"あ", 72, "high", "a_high.wav"},
+		{"i", 72, "", "i.wav"},
+		{"う", 60, "", ""},
+	} {
+		t.Logf("Test case %v.; `%v` at %v should be found in `%v`.", i+1, tc.alias, tc.noteNum, tc.filename)
+		sub, p, ok := vb.Lookup(tc.alias, tc.noteNum)
+		assert.Equal(t, tc.filename != "", ok)
+		if ok {
+			assert.Equal(t, tc.subfolder, sub)
+			assert.Equal(t, tc.filename, p.Filename)
+		}
+	}
+}
+
+func TestVoicebankLooksUpAnyForms(t *testing.T) {
+	vb := newTestAliasVoicebank("ka", "a ki", "く")
+	for i, tc := range []struct {
+		alias string
+		found string
+	}{
+		{"ka", "ka"},
+		{"か", "ka"},
+		{"カ", "ka"},
+		{"a き", "a ki"},
+		{"ku", "く"},
+		{"ク", "く"},
+		{"け", ""},
+	} {
+		t.Logf("Test case %v.; `%v` should be found as `%v`.", i+1, tc.alias, tc.found)
+		alias, _, p, ok := vb.LookupAnyForm(tc.alias, 60)
+		assert.Equal(t, tc.found != "", ok)
+		if ok {
+			assert.Equal(t, tc.found, alias)
+			assert.Equal(t, tc.found+".wav", p.Filename)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+)
+
+const (
+	waveFormatPCM        = 1
+	waveFormatFloat      = 3
+	waveFormatExtensible = 0xFFFE
+)
+
+// Wave represents a decoded RIFF wave file.
+// Samples are normalized into [-1, 1] and stored per channel.
+type Wave struct {
+	SampleRate    int         `json:"sample_rate"`
+	BitsPerSample int         `json:"bits_per_sample"`
+	Float         bool        `json:"float"`
+	Channels      [][]float64 `json:"-"`
+}
+
+// NewWave creates a silent Wave with the given format.
+func NewWave(sampleRate int, bitsPerSample int, channels int, frames int) *Wave {
+	cs := make([][]float64, channels)
+	for i := range cs {
+		cs[i] = make([]float64, frames)
+	}
+	return &Wave{SampleRate: sampleRate, BitsPerSample: bitsPerSample, Channels: cs}
+}
+
+// NewWaveFromBytes decodes a RIFF wave file.
+func NewWaveFromBytes(bs []byte) (*Wave, error) {
+	if len(bs) < 12 || string(bs[0:4]) != "RIFF" || string(bs[8:12]) != "WAVE" {
+		return nil, errors.New("The given bytes are not a RIFF wave file")
+	}
+	var format, channels, bits int
+	var rate int
+	var data []byte
+	hasFormat, hasData := false, false
+	for pos := 12; pos+8 <= len(bs); {
+		id := string(bs[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(bs[pos+4 : pos+8]))
+		body := pos + 8
+		end := body + size
+		if end > len(bs) || size < 0 {
+			// Some tools write a bogus size for the last chunk; take what is there.
+			end = len(bs)
+		}
+		switch id {
+		case "fmt ":
+			if end-body < 16 {
+				return nil, errors.New("The fmt chunk is too short")
+			}
+			format = int(binary.LittleEndian.Uint16(bs[body:]))
+			channels = int(binary.LittleEndian.Uint16(bs[body+2:]))
+			rate = int(binary.LittleEndian.Uint32(bs[body+4:]))
+			bits = int(binary.LittleEndian.Uint16(bs[body+14:]))
+			if format == waveFormatExtensible && end-body >= 26 {
+				format = int(binary.LittleEndian.Uint16(bs[body+24:]))
+			}
+			hasFormat = true
+		case "data":
+			data = bs[body:end]
+			hasData = true
+		}
+		pos = end + size%2
+		if end == len(bs) {
+			break
+		}
+	}
+	if !hasFormat || !hasData {
+		return nil, errors.New("The given wave file lacks fmt or data chunk")
+	}
+	if channels <= 0 {
+		return nil, errors.New("The given wave file has no channels")
+	}
+	if format != waveFormatPCM && format != waveFormatFloat {
+		return nil, errors.New("Unsupported wave format; `" + strconv.Itoa(format) + "`")
+	}
+	w := &Wave{SampleRate: rate, BitsPerSample: bits, Float: format == waveFormatFloat}
+	bytesPerSample := bits / 8
+	if !w.supported() {
+		return nil, errors.New("Unsupported bits per sample; `" + strconv.Itoa(bits) + "`")
+	}
+	frames := len(data) / (bytesPerSample * channels)
+	w.Channels = make([][]float64, channels)
+	for c := range w.Channels {
+		w.Channels[c] = make([]float64, frames)
+	}
+	for i := 0; i < frames; i++ {
+		for c := 0; c < channels; c++ {
+			off := (i*channels + c) * bytesPerSample
+			w.Channels[c][i] = w.decodeSample(data[off : off+bytesPerSample])
+		}
+	}
+	return w, nil
+}
+
+func (w *Wave) supported() bool {
+	if w.Float {
+		return w.BitsPerSample == 32 || w.BitsPerSample == 64
+	}
+	switch w.BitsPerSample {
+	case 8, 16, 24, 32:
+		return true
+	}
+	return false
+}
+
+func (w *Wave) decodeSample(b []byte) float64 {
+	if w.Float {
+		if w.BitsPerSample == 64 {
+			return math.Float64frombits(binary.LittleEndian.Uint64(b))
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	}
+	switch w.BitsPerSample {
+	case 8:
+		return (float64(b[0]) - 128) / 128
+	case 16:
+		return float64(int16(binary.LittleEndian.Uint16(b))) / 32768
+	case 24:
+		v := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+		return float64(v) / 8388608
+	default:
+		return float64(int32(binary.LittleEndian.Uint32(b))) / 2147483648
+	}
+}
+
+func (w *Wave) encodeSample(b []byte, v float64) {
+	if w.Float {
+		if w.BitsPerSample == 64 {
+			binary.LittleEndian.PutUint64(b, math.Float64bits(v))
+		} else {
+			binary.LittleEndian.PutUint32(b, math.Float32bits(float32(v)))
+		}
+		return
+	}
+	if v > 1 {
+		v = 1
+	} else if v < -1 {
+		v = -1
+	}
+	switch w.BitsPerSample {
+	case 8:
+		b[0] = byte(clampInt(int(math.Round(v*128))+128, 0, 255))
+	case 16:
+		binary.LittleEndian.PutUint16(b, uint16(int16(clampInt(int(math.Round(v*32768)), -32768, 32767))))
+	case 24:
+		i := clampInt(int(math.Round(v*8388608)), -8388608, 8388607)
+		b[0], b[1], b[2] = byte(i), byte(i>>8), byte(i>>16)
+	default:
+		i := clampInt(int(math.Round(v*2147483648)), -2147483648, 2147483647)
+		binary.LittleEndian.PutUint32(b, uint32(int32(i)))
+	}
+}
+
+// Bytes encodes the Wave as a RIFF wave file.
+func (w *Wave) Bytes() ([]byte, error) {
+	if !w.supported() {
+		return nil, errors.New("Unsupported bits per sample; `" + strconv.Itoa(w.BitsPerSample) + "`")
+	}
+	channels := w.NumChannels()
+	if channels == 0 {
+		return nil, errors.New("The given wave has no channels")
+	}
+	bytesPerSample := w.BitsPerSample / 8
+	frames := w.Len()
+	dataSize := frames * channels * bytesPerSample
+	format := waveFormatPCM
+	if w.Float {
+		format = waveFormatFloat
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, 44+dataSize))
+	buf.WriteString("RIFF")
+	binary.Write(buf, binary.LittleEndian, uint32(36+dataSize+dataSize%2))
+	buf.WriteString("WAVEfmt ")
+	binary.Write(buf, binary.LittleEndian, uint32(16))
+	binary.Write(buf, binary.LittleEndian, uint16(format))
+	binary.Write(buf, binary.LittleEndian, uint16(channels))
+	binary.Write(buf, binary.LittleEndian, uint32(w.SampleRate))
+	binary.Write(buf, binary.LittleEndian, uint32(w.SampleRate*channels*bytesPerSample))
+	binary.Write(buf, binary.LittleEndian, uint16(channels*bytesPerSample))
+	binary.Write(buf, binary.LittleEndian, uint16(w.BitsPerSample))
+	buf.WriteString("data")
+	binary.Write(buf, binary.LittleEndian, uint32(dataSize))
+	b := make([]byte, bytesPerSample)
+	for i := 0; i < frames; i++ {
+		for c := 0; c < channels; c++ {
+			w.encodeSample(b, w.Channels[c][i])
+			buf.Write(b)
+		}
+	}
+	if dataSize%2 == 1 {
+		buf.WriteByte(0)
+	}
+	return buf.Bytes(), nil
+}
+
+// NumChannels returns the number of channels.
+func (w *Wave) NumChannels() int {
+	return len(w.Channels)
+}
+
+// Len returns the number of frames.
+func (w *Wave) Len() int {
+	if len(w.Channels) == 0 {
+		return 0
+	}
+	return len(w.Channels[0])
+}
+
+// Duration returns the length of the Wave in milliseconds.
+func (w *Wave) Duration() float64 {
+	return w.FrameToMs(w.Len())
+}
+
+// MsToFrame converts milliseconds into the index of a frame.
+func (w *Wave) MsToFrame(ms float64) int {
+	return int(math.Round(ms * float64(w.SampleRate) / 1000))
+}
+
+// FrameToMs converts the index of a frame into milliseconds.
+func (w *Wave) FrameToMs(i int) float64 {
+	if w.SampleRate == 0 {
+		return 0
+	}
+	return float64(i) * 1000 / float64(w.SampleRate)
+}
+
+// Mono returns the average of all channels.
+func (w *Wave) Mono() []float64 {
+	if len(w.Channels) == 1 {
+		return w.Channels[0]
+	}
+	res := make([]float64, w.Len())
+	for _, c := range w.Channels {
+		for i, v := range c {
+			res[i] += v / float64(len(w.Channels))
+		}
+	}
+	return res
+}
+
+func clampInt(v int, lo int, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+type waveFactory interface {
+	New([]byte) (*Wave, error)
+}
+
+type waveFactoryDefault struct {
+}
+
+func (wf waveFactoryDefault) New(bs []byte) (*Wave, error) {
+	return NewWaveFromBytes(bs)
+}
+
+// WaveReader reads Wave from filesystem.
+type WaveReader interface {
+	Read(string) (*Wave, error)
+}
+
+type waveReaderDefault struct {
+	fr fileReader
+	wf waveFactory
+}
+
+// NewWaveReader creates a default WaveReader that reads Wave from filesystem.
+func NewWaveReader() WaveReader {
+	return waveReaderDefault{
+		fr: fileReaderDefault{},
+		wf: waveFactoryDefault{},
+	}
+}
+
+// Read Wave from the file specified by filename.
+func (wr waveReaderDefault) Read(filename string) (*Wave, error) {
+	t, err := wr.fr.Read(filename)
+	if err != nil {
+		return nil, err
+	}
+	return wr.wf.New([]byte(t))
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// newTestWave returns a mono wave that contains 100ms of silence, 50ms of noise like a consonant,
+// 300ms of a 220Hz tone like a vowel and 100ms of silence.
+func newTestWave() *Wave {
+	const rate = 44100
+	r := rand.New(rand.NewSource(1))
+	w := NewWave(rate, 16, 1, rate*550/1000)
+	for i := range w.Channels[0] {
+		t := float64(i) * 1000 / rate
+		switch {
+		case t >= 100 && t < 150:
+			w.Channels[0][i] = (r.Float64()*2 - 1) * 0.3
+		case t >= 150 && t < 450:
+			w.Channels[0][i] = 0.5 * math.Sin(2*math.Pi*220*float64(i)/rate)
+		}
+	}
+	return w
+}
+
+func TestWaveRoundTrip(t *testing.T) {
+	type TestCase struct {
+		bits  int
+		float bool
+	}
+	for i, tc := range []TestCase{{8, false}, {16, false}, {24, false}, {32, false}, {32, true}, {64, true}} {
+		t.Logf("Test case %v.; %v bits wave (float: %v) should survive encoding.", i+1, tc.bits, tc.float)
+		w := NewWave(44100, tc.bits, 2, 4)
+		w.Float = tc.float
+		w.Channels[0] = []float64{0, 0.5, -0.5, 0.25}
+		w.Channels[1] = []float64{-0.25, 0, 0.125, -1}
+		bs, err := w.Bytes()
+		assert.Equal(t, nil, err)
+		actual, err := NewWaveFromBytes(bs)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, w.SampleRate, actual.SampleRate)
+		assert.Equal(t, w.BitsPerSample, actual.BitsPerSample)
+		assert.Equal(t, 2, actual.NumChannels())
+		for c := range w.Channels {
+			assert.InDeltaSlice(t, w.Channels[c], actual.Channels[c], 1.0/64)
+		}
+	}
+}
+
+func TestFailedCasesOfNewWaveFromBytes(t *testing.T) {
+	for i, tc := range [][]byte{
+		[]byte("This is not a wave file"),
+		[]byte("RIFF\x04\x00\x00\x00WAVE"),
+		[]byte("RIFF\x24\x00\x00\x00WAVEfmt \x10\x00\x00\x00\x02\x00\x01\x00\x44\xac\x00\x00\x10\xb1\x02\x00\x02\x00\x10\x00data\x00\x00\x00\x00"),
+	} {
+		t.Logf("Test case %v.; `%v` cannot be interpreted as Wave.", i+1, tc)
+		_, err := NewWaveFromBytes(tc)
+		assert.Error(t, err)
+	}
+}
+
+func TestWaveConvertsTimes(t *testing.T) {
+	w := NewWave(44100, 16, 1, 44100)
+	assert.Equal(t, 4410, w.MsToFrame(100))
+	assert.Equal(t, 100.0, w.FrameToMs(4410))
+	assert.Equal(t, 1000.0, w.Duration())
+}
+
+type waveFactoryMock struct {
+	mock.Mock
+}
+
+func (m *waveFactoryMock) New(bs []byte) (*Wave, error) {
+	args := m.Called(bs)
+	return args.Get(0).(*Wave), args.Error(1)
+}
+
+func TestWaveReaderReadsFileSuccessfully(t *testing.T) {
+	const testCase = "testCase"
+	const fakeFileText = "This is a fake wave."
+	mockedFileReader := new(fileReaderMock)
+	mockedWaveFactory := new(waveFactoryMock)
+	sut := &waveReaderDefault{
+		fr: mockedFileReader,
+		wf: mockedWaveFactory,
+	}
+	expected := NewWave(44100, 16, 1, 1)
+	mockedFileReader.On("Read", testCase).Return(fakeFileText, nil)
+	mockedWaveFactory.On("New", []byte(fakeFileText)).Return(expected, nil)
+	actual, err := sut.Read(testCase)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, expected, actual)
+	mockedFileReader.AssertExpectations(t)
+	mockedWaveFactory.AssertExpectations(t)
+}
+
+func TestWaveReaderReadsFileInFailWhenReadingFileFails(t *testing.T) {
+	const testCase = "testCase"
+	mockedFileReader := new(fileReaderMock)
+	mockedWaveFactory := new(waveFactoryMock)
+	sut := &waveReaderDefault{
+		fr: mockedFileReader,
+		wf: mockedWaveFactory,
+	}
+	expected := errors.New("FAILED")
+	mockedFileReader.On("Read", testCase).Return("", expected)
+	_, err := sut.Read(testCase)
+	assert.Equal(t, expected, err)
+	mockedWaveFactory.AssertNumberOfCalls(t, "New", 0)
+}
@@ -0,0 +1,290 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// WavtoolSampleRate is the sample rate of the track wavtool writes.
+const WavtoolSampleRate = 44100
+
+// Points returns the times and gains of the Envelope applied to a segment of the length in milliseconds.
+// p1, p2 and p5 are measured forward from the start, each from the previous one,
+// and p4 and p3 are measured backward from the end in the same way,
+// so the points are at p1, p1+p2, p1+p2+p5, length-p4-p3 and length-p4 with v1, v2, v5, v3 and v4.
+// The gain is zero before the first point and after the last one.
+func (e *Envelope) Points(length float64) ([]float64, []float64) {
+	p := make([]float64, 5)
+	copy(p, e.P)
+	v := make([]float64, 5)
+	copy(v, e.V)
+	ts := []float64{p[0], p[0] + p[1]}
+	gs := []float64{v[0] / 100, v[1] / 100}
+	if len(e.P) > 4 && len(e.V) > 4 {
+		ts = append(ts, p[0]+p[1]+p[4])
+		gs = append(gs, v[4]/100)
+	}
+	ts = append(ts, length-p[3]-p[2], length-p[3])
+	gs = append(gs, v[2]/100, v[3]/100)
+	return ts, gs
+}
+
+// Gains returns the gain of each of the frames of a segment of the length in milliseconds.
+// Points are truncated to frames as wavtool does, and the gain is linear between them.
+func (e *Envelope) Gains(length float64, sampleRate int) []float64 {
+	ts, gs := e.Points(length)
+	frames := int(length * float64(sampleRate) / 1000)
+	fs := make([]int, len(ts))
+	for i, t := range ts {
+		fs[i] = int(t * float64(sampleRate) / 1000)
+	}
+	res := make([]float64, frames)
+	for i := range res {
+		if i < fs[0] || i > fs[len(fs)-1] {
+			continue
+		}
+		k := 0
+		for k+1 < len(fs) && fs[k+1] <= i {
+			k++
+		}
+		if k+1 == len(fs) || fs[k+1] == fs[k] {
+			res[i] = gs[k]
+			continue
+		}
+		res[i] = gs[k] + (gs[k+1]-gs[k])*float64(i-fs[k])/float64(fs[k+1]-fs[k])
+	}
+	return res
+}
+
+// WavtoolRequest is the set of parameters UTAU passes to wavtool for a note.
+// Times are in milliseconds.
+type WavtoolRequest struct {
+	Output string `json:"output"`
+	Input  string `json:"input"`
+	// Offset is the time to skip at the head of the input, which is StartPoint of the note.
+	Offset float64 `json:"offset"`
+	Length float64 `json:"length"`
+	// Overlap is the time the input starts before the end of the output.
+	Overlap  float64   `json:"overlap"`
+	Envelope *Envelope `json:"envelope"`
+}
+
+// ParseWavtoolLength parses the length argument of wavtool.
+// It is either milliseconds, or `ticks@tempo` followed by a signed adjustment in milliseconds such as `480@120+25.5`.
+func ParseWavtoolLength(s string) (float64, error) {
+	at := strings.IndexByte(s, '@')
+	if at < 0 {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, errors.New("The given length of wavtool is invalid; `" + s + "`")
+		}
+		return v, nil
+	}
+	ticks, err := strconv.Atoi(s[:at])
+	if err != nil {
+		return 0, errors.New("The given length of wavtool is invalid; `" + s + "`")
+	}
+	rest := s[at+1:]
+	adjust := 0.0
+	if i := strings.IndexAny(rest, "+-"); i >= 0 {
+		adjust, err = strconv.ParseFloat(rest[i:], 64)
+		if err != nil {
+			return 0, errors.New("The given length of wavtool is invalid; `" + s + "`")
+		}
+		rest = rest[:i]
+	}
+	tempo, err := strconv.ParseFloat(rest, 64)
+	if err != nil || tempo <= 0 {
+		return 0, errors.New("The given length of wavtool is invalid; `" + s + "`")
+	}
+	return TickToMs(ticks, tempo) + adjust, nil
+}
+
+// NewWavtoolRequestFromArgs parses the command line arguments of wavtool,
+// `outfile infile offset length p1 p2 p3 v1 v2 v3 v4 overlap p4 p5 v5`, where the last ones are optional.
+// Without the envelope, the default envelope is used. A partial envelope such as `0 0`, which UTAU gives to rests,
+// is filled with zeros.
+func NewWavtoolRequestFromArgs(args []string) (*WavtoolRequest, error) {
+	if len(args) < 4 {
+		return nil, errors.New("The given arguments of wavtool do not contain 4 elements; `" + strings.Join(args, " ") + "`")
+	}
+	res := &WavtoolRequest{Output: args[0], Input: args[1], Envelope: DefaultEnvelope()}
+	offset, err := strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		return nil, errors.New("The given offset of wavtool is invalid; `" + args[2] + "`")
+	}
+	res.Offset = offset
+	if res.Length, err = ParseWavtoolLength(args[3]); err != nil {
+		return nil, err
+	}
+	if len(args) == 4 {
+		return res, nil
+	}
+	vs := make([]float64, len(args)-4)
+	for i, a := range args[4:] {
+		if vs[i], err = strconv.ParseFloat(a, 64); err != nil {
+			return nil, errors.New("The given envelope of wavtool is invalid; `" + a + "`")
+		}
+	}
+	for len(vs) < 8 {
+		vs = append(vs, 0)
+	}
+	res.Envelope = &Envelope{P: append([]float64{}, vs[0:3]...), V: append([]float64{}, vs[3:7]...)}
+	res.Overlap = vs[7]
+	if len(vs) > 8 {
+		res.Envelope.P = append(res.Envelope.P, vs[8])
+	}
+	if len(vs) > 10 {
+		res.Envelope.P = append(res.Envelope.P, vs[9])
+		res.Envelope.V = append(res.Envelope.V, vs[10])
+	}
+	return res, nil
+}
+
+// NewWavtoolRequestFromSegment creates WavtoolRequest appending a planned Segment rendered to input.
+// The envelope of the note is used if it has one, and the overlap comes from the Segment,
+// which defaults to Overlap of the Phoneme.
+func NewWavtoolRequestFromSegment(s *Segment, output string, input string) *WavtoolRequest {
+	res := &WavtoolRequest{
+		Output:   output,
+		Input:    input,
+		Offset:   s.StartPoint,
+		Length:   s.Length,
+		Overlap:  s.Overlap,
+		Envelope: DefaultEnvelope(),
+	}
+	if s.Note != nil && s.Note.Envelope != nil {
+		res.Envelope = s.Note.Envelope
+	}
+	return res
+}
+
+// Args returns the command line arguments of wavtool.
+func (r *WavtoolRequest) Args() []string {
+	e := r.Envelope
+	if e == nil {
+		e = DefaultEnvelope()
+	}
+	fs := e.Fields()
+	res := []string{r.Output, r.Input, formatFloat(r.Offset), formatFloat(r.Length)}
+	res = append(res, fs[:7]...)
+	res = append(res, formatFloat(r.Overlap))
+	return append(res, fs[7:]...)
+}
+
+// Concatenator builds a track by appending rendered segments the same way as wavtool.
+type Concatenator struct {
+	Wave *Wave
+}
+
+// NewConcatenator creates an empty Concatenator writing 16 bit mono of the sample rate.
+func NewConcatenator(sampleRate int) *Concatenator {
+	return &Concatenator{Wave: NewWave(sampleRate, 16, 1, 0)}
+}
+
+// Append mixes the input into the track.
+// The input is skipped by offset, cut or padded with silence to length, and shaped by the envelope.
+// It starts overlap before the end of the track, where it is added to the track,
+// and a negative overlap leaves a silent gap. A nil input appends silence as wavtool does for rests.
+func (c *Concatenator) Append(w *Wave, offset float64, length float64, overlap float64, e *Envelope) {
+	rate := c.Wave.SampleRate
+	track := c.Wave.Channels[0]
+	start := len(track) - int(overlap*float64(rate)/1000)
+	if start < 0 {
+		start = 0
+	}
+	frames := int(length * float64(rate) / 1000)
+	if frames < 0 {
+		frames = 0
+	}
+	for len(track) < start+frames {
+		track = append(track, 0)
+	}
+	if w != nil && w.Len() > 0 {
+		if w.SampleRate != rate {
+			w = &Wave{SampleRate: rate, BitsPerSample: w.BitsPerSample, Channels: [][]float64{resample(w.Mono(), w.SampleRate, rate)}}
+		}
+		s := w.Mono()
+		skip := int(offset * float64(rate) / 1000)
+		if e == nil {
+			e = DefaultEnvelope()
+		}
+		gains := e.Gains(length, rate)
+		for i := 0; i < frames && i < len(gains) && skip+i < len(s); i++ {
+			if skip+i < 0 {
+				continue
+			}
+			track[start+i] += s[skip+i] * gains[i]
+		}
+	}
+	c.Wave.Channels[0] = track
+}
+
+// AppendRequest mixes the input into the track by the parameters of the WavtoolRequest.
+func (c *Concatenator) AppendRequest(w *Wave, r *WavtoolRequest) {
+	c.Append(w, r.Offset, r.Length, r.Overlap, r.Envelope)
+}
+
+// Duration returns the length of the track in milliseconds.
+func (c *Concatenator) Duration() float64 {
+	return c.Wave.Duration()
+}
+
+// Wavtool appends a note to the track UTAU builds as a pair of `.whd` and `.dat` files,
+// which are the header and the data of the wave file to be joined at the end.
+type Wavtool interface {
+	Run(r *WavtoolRequest) error
+}
+
+type wavtoolDefault struct {
+	fr fileReader
+	fw fileWriter
+	wr WaveReader
+}
+
+// NewWavtool creates a default Wavtool that works on filesystem.
+func NewWavtool() Wavtool {
+	return wavtoolDefault{
+		fr: fileReaderDefault{},
+		fw: fileWriterDefault{},
+		wr: NewWaveReader(),
+	}
+}
+
+// Run reads `Output.dat`, appends Input to it and writes `Output.whd` and `Output.dat`.
+// A missing Input is treated as silence, which is how UTAU renders rests.
+func (wt wavtoolDefault) Run(r *WavtoolRequest) error {
+	c := NewConcatenator(WavtoolSampleRate)
+	dat, err := wt.fr.Read(r.Output + ".dat")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	track := make([]float64, len(dat)/2)
+	for i := range track {
+		track[i] = float64(int16(binary.LittleEndian.Uint16([]byte(dat[i*2:i*2+2])))) / 32768
+	}
+	c.Wave.Channels[0] = track
+	var w *Wave
+	if r.Input != "" {
+		w, err = wt.wr.Read(r.Input)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	c.AppendRequest(w, r)
+	bs, err := c.Wave.Bytes()
+	if err != nil {
+		return err
+	}
+	if err := wt.fw.Write(r.Output+".whd", string(bs[:44])); err != nil {
+		return err
+	}
+	return wt.fw.Write(r.Output+".dat", string(bs[44:]))
+}
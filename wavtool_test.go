@@ -0,0 +1,189 @@
+// Copyright 2020 Hal@shurabaP.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package utau
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newConstantWave(rate int, v float64, ms float64) *Wave {
+	w := NewWave(rate, 16, 1, int(ms*float64(rate)/1000))
+	for i := range w.Channels[0] {
+		w.Channels[0][i] = v
+	}
+	return w
+}
+
+func TestEnvelopePoints(t *testing.T) {
+	testCases := []struct {
+		e  *Envelope
+		ts []float64
+		gs []float64
+	}{
+		{DefaultEnvelope(), []float64{0, 5, 65, 100}, []float64{0, 1, 1, 0}},
+		{&Envelope{P: []float64{10, 20, 30, 5}, V: []float64{50, 100, 80, 0}}, []float64{10, 30, 65, 95}, []float64{0.5, 1, 0.8, 0}},
+		{&Envelope{P: []float64{10, 20, 30, 5, 15}, V: []float64{50, 100, 80, 0, 120}}, []float64{10, 30, 45, 65, 95}, []float64{0.5, 1, 1.2, 0.8, 0}},
+	}
+	for i, tc := range testCases {
+		t.Logf("Test case %v.; %v", i+1, tc.e)
+		ts, gs := tc.e.Points(100)
+		assert.Equal(t, tc.ts, ts)
+		assert.Equal(t, tc.gs, gs)
+	}
+}
+
+func TestEnvelopeGains(t *testing.T) {
+	e := &Envelope{P: []float64{0, 10, 10, 0}, V: []float64{0, 100, 100, 0}}
+	gs := e.Gains(100, 1000)
+	assert.Equal(t, 100, len(gs))
+	assert.Equal(t, 0.0, gs[0])
+	assert.Equal(t, 0.5, gs[5])
+	assert.Equal(t, 1.0, gs[10])
+	assert.Equal(t, 1.0, gs[90])
+	assert.Equal(t, 0.5, gs[95])
+
+	gs = (&Envelope{P: []float64{20, 0, 0, 20}, V: []float64{100, 100, 100, 100}}).Gains(100, 1000)
+	assert.Equal(t, 0.0, gs[19])
+	assert.Equal(t, 1.0, gs[20])
+	assert.Equal(t, 1.0, gs[80])
+	assert.Equal(t, 0.0, gs[81])
+}
+
+func TestParseWavtoolLength(t *testing.T) {
+	testCases := []struct {
+		s   string
+		res float64
+	}{
+		{"250", 250},
+		{"480@120", 500},
+		{"480@120+25.5", 525.5},
+		{"960@60-100", 1900},
+	}
+	for i, tc := range testCases {
+		t.Logf("Test case %v.; %v", i+1, tc.s)
+		res, err := ParseWavtoolLength(tc.s)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, tc.res, res)
+	}
+	for _, s := range []string{"", "a", "x@120", "480@0", "480@120+a"} {
+		_, err := ParseWavtoolLength(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestNewWavtoolRequestFromArgs(t *testing.T) {
+	args := []string{"out.wav", "in.wav", "10", "480@120+25", "0", "5", "35", "0", "100", "100", "0", "20", "0", "7", "90"}
+	r, err := NewWavtoolRequestFromArgs(args)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &WavtoolRequest{
+		Output:   "out.wav",
+		Input:    "in.wav",
+		Offset:   10,
+		Length:   525,
+		Overlap:  20,
+		Envelope: &Envelope{P: []float64{0, 5, 35, 0, 7}, V: []float64{0, 100, 100, 0, 90}},
+	}, r)
+	assert.Equal(t, []string{"out.wav", "in.wav", "10", "525", "0", "5", "35", "0", "100", "100", "0", "20", "0", "7", "90"}, r.Args())
+
+	r, err = NewWavtoolRequestFromArgs(args[:4])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, DefaultEnvelope(), r.Envelope)
+	assert.Equal(t, 0.0, r.Overlap)
+
+	r, err = NewWavtoolRequestFromArgs(args[:6])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &Envelope{P: []float64{0, 5, 0}, V: []float64{0, 0, 0, 0}}, r.Envelope)
+
+	for _, as := range [][]string{args[:3], append(append([]string{}, args[:5]...), "x", "35", "0", "100", "100", "0", "20")} {
+		_, err = NewWavtoolRequestFromArgs(as)
+		assert.Error(t, err)
+	}
+}
+
+func TestNewWavtoolRequestFromSegment(t *testing.T) {
+	e := &Envelope{P: []float64{5, 10, 20}, V: []float64{0, 100, 100, 0}}
+	s := &Segment{Note: &Note{Envelope: e}, StartPoint: 3, Length: 400, Overlap: 25}
+	r := NewWavtoolRequestFromSegment(s, "out.wav", "cache/1.wav")
+	assert.Equal(t, &WavtoolRequest{Output: "out.wav", Input: "cache/1.wav", Offset: 3, Length: 400, Overlap: 25, Envelope: e}, r)
+	r = NewWavtoolRequestFromSegment(&Segment{Note: &Note{}, Length: 100}, "out.wav", "R.wav")
+	assert.Equal(t, DefaultEnvelope(), r.Envelope)
+}
+
+func TestConcatenatorAppend(t *testing.T) {
+	flat := &Envelope{P: []float64{0, 0, 0}, V: []float64{100, 100, 100, 100}}
+	c := NewConcatenator(1000)
+	c.Append(newConstantWave(1000, 0.5, 100), 0, 100, 0, flat)
+	assert.Equal(t, 100, c.Wave.Len())
+	c.Append(newConstantWave(1000, 0.25, 100), 0, 100, 20, flat)
+	assert.Equal(t, 180, c.Wave.Len())
+	assert.Equal(t, 0.5, c.Wave.Channels[0][79])
+	assert.Equal(t, 0.75, c.Wave.Channels[0][80])
+	assert.Equal(t, 0.25, c.Wave.Channels[0][100])
+	c.Append(nil, 0, 50, 0, nil)
+	assert.Equal(t, 230, c.Wave.Len())
+	assert.Equal(t, 0.0, c.Wave.Channels[0][200])
+	c.Append(newConstantWave(1000, 0.5, 10), 0, 50, -20, flat)
+	assert.Equal(t, 300, c.Wave.Len())
+	assert.Equal(t, 0.0, c.Wave.Channels[0][240])
+	assert.Equal(t, 0.5, c.Wave.Channels[0][250])
+	assert.Equal(t, 0.0, c.Wave.Channels[0][260], "The input shorter than the length should be padded with silence.")
+	assert.InDelta(t, 300, c.Duration(), 1e-9)
+
+	w := newConstantWave(1000, 0, 100)
+	for i := range w.Channels[0] {
+		w.Channels[0][i] = float64(i) / 100
+	}
+	c = NewConcatenator(1000)
+	c.Append(w, 30, 50, 0, flat)
+	assert.Equal(t, 0.3, c.Wave.Channels[0][0])
+	c.Append(w, 0, 1000, 2000, DefaultEnvelope())
+	assert.Equal(t, 1000, c.Wave.Len(), "The overlap longer than the track should start at the head.")
+}
+
+func TestWavtoolRun(t *testing.T) {
+	mockedFileReader := new(fileReaderMock)
+	mockedFileWriter := new(fileWriterMock)
+	mockedWaveReader := new(waveReaderMock)
+	existing := make([]byte, 4)
+	binary.LittleEndian.PutUint16(existing[0:], uint16(16384))
+	binary.LittleEndian.PutUint16(existing[2:], uint16(16384))
+	mockedFileReader.On("Read", "out.wav.dat").Return(string(existing), nil)
+	mockedFileReader.On("Read", "new.wav.dat").Return("", os.ErrNotExist)
+	mockedFileReader.On("Read", "broken.wav.dat").Return("", errors.New("FAILED"))
+	mockedWaveReader.On("Read", "in.wav").Return(newConstantWave(WavtoolSampleRate, 0.25, 10), nil)
+	mockedWaveReader.On("Read", "R.wav").Return((*Wave)(nil), os.ErrNotExist)
+	mockedWaveReader.On("Read", "bad.wav").Return((*Wave)(nil), errors.New("FAILED"))
+	mockedFileWriter.On("Write", mock.Anything, mock.Anything).Return(nil)
+	sut := wavtoolDefault{fr: mockedFileReader, fw: mockedFileWriter, wr: mockedWaveReader}
+	flat := &Envelope{P: []float64{0, 0, 0}, V: []float64{100, 100, 100, 100}}
+
+	err := sut.Run(&WavtoolRequest{Output: "out.wav", Input: "in.wav", Length: 10, Envelope: flat})
+	assert.Equal(t, nil, err)
+	whd := mockedFileWriter.Calls[0].Arguments.String(1)
+	dat := mockedFileWriter.Calls[1].Arguments.String(1)
+	assert.Equal(t, "out.wav.whd", mockedFileWriter.Calls[0].Arguments.String(0))
+	assert.Equal(t, "out.wav.dat", mockedFileWriter.Calls[1].Arguments.String(0))
+	assert.Equal(t, 44, len(whd))
+	assert.Equal(t, 4+441*2, len(dat))
+	assert.Equal(t, uint32(len(dat)), binary.LittleEndian.Uint32([]byte(whd[40:44])))
+	assert.Equal(t, existing, []byte(dat[:4]))
+	assert.Equal(t, int16(8192), int16(binary.LittleEndian.Uint16([]byte(dat[4:6]))))
+	w, err := NewWaveFromBytes([]byte(whd + dat))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 443, w.Len())
+
+	err = sut.Run(&WavtoolRequest{Output: "new.wav", Input: "R.wav", Length: 10})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 441*2, len(mockedFileWriter.Calls[3].Arguments.String(1)))
+
+	assert.Error(t, sut.Run(&WavtoolRequest{Output: "broken.wav", Input: "in.wav", Length: 10}))
+	assert.Error(t, sut.Run(&WavtoolRequest{Output: "new.wav", Input: "bad.wav", Length: 10}))
+}